@@ -0,0 +1,150 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// AccessRule grants root visibility and capabilities to a user or a group
+type AccessRule struct {
+	Roots          []string `json:"roots,omitempty"`          // Visible root names, or "*" for every root; omitted adds no roots (see accessFor)
+	Upload         bool     `json:"upload,omitempty"`         // Browser uploads (still subject to allowUpload)
+	FileOperations bool     `json:"fileOperations,omitempty"` // Copy, move, rename, delete, mkdir, archive (still subject to allowFileOperations)
+	Settings       bool     `json:"settings,omitempty"`       // Edit config.json and restart the server
+	Share          bool     `json:"share,omitempty"`          // Create share links for books and folders
+	Collections    bool     `json:"collections,omitempty"`    // Create and edit collections and book tags, which every user shares
+}
+
+// Access is the effective permission set of a request
+type Access struct {
+	roots          map[string]bool // nil: every root is visible
+	scope          string          // Share links: the only "RootName/..." subtree that is visible
+	Upload         bool
+	FileOperations bool
+	Settings       bool
+	Share          bool
+	Collections    bool
+}
+
+// fullAccess applies when authentication is disabled and to administrators
+var fullAccess = &Access{Upload: true, FileOperations: true, Settings: true, Share: true, Collections: true}
+
+// canSeeRoot reports whether a root is visible
+func (a *Access) canSeeRoot(rootName string) bool {
+	return a.roots == nil || a.roots[rootName]
+}
+
+// canSeePath reports whether a canonical "RootName/..." path lies in a visible root
+func (a *Access) canSeePath(p string) bool {
+	p = filepath.ToSlash(p)
+	if a.scope != "" && p != a.scope && !strings.HasPrefix(p, a.scope+"/") {
+		return false
+	}
+	if a.roots == nil {
+		return true
+	}
+	rootName, _, _ := strings.Cut(p, "/")
+	return a.roots[rootName]
+}
+
+// filterItems drops items in roots the request cannot see
+func (a *Access) filterItems(items []fileItem) []fileItem {
+	if a.roots == nil {
+		return items
+	}
+	result := make([]fileItem, 0, len(items))
+	for _, item := range items {
+		if a.canSeePath(item.Path) {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// accessFor combines the rules of a user and the groups it belongs to. The
+// visible roots are the union of the roots the rules list; rules without roots
+// only grant capabilities. Users without groups or a rule of their own get the
+// defaultGroups, so a new account sees nothing until it is given access.
+func (s *Server) accessFor(user *User) *Access {
+	if user.Admin {
+		return fullAccess
+	}
+	groups := user.Groups
+	if len(groups) == 0 && user.Access == nil {
+		groups = s.config.DefaultGroups
+	}
+	rules := make([]AccessRule, 0, len(groups)+1)
+	if user.Access != nil {
+		rules = append(rules, *user.Access)
+	}
+	for _, group := range groups {
+		if rule, ok := s.config.Groups[group]; ok {
+			rules = append(rules, rule)
+		}
+	}
+
+	access := &Access{roots: make(map[string]bool)}
+	allRoots := false
+	for _, rule := range rules {
+		for _, rootName := range rule.Roots {
+			if rootName == "*" {
+				allRoots = true
+			}
+			access.roots[rootName] = true
+		}
+		access.Upload = access.Upload || rule.Upload
+		access.FileOperations = access.FileOperations || rule.FileOperations
+		access.Settings = access.Settings || rule.Settings
+		access.Share = access.Share || rule.Share
+		access.Collections = access.Collections || rule.Collections
+	}
+	if allRoots {
+		access.roots = nil
+	}
+	return access
+}
+
+// requestAccess returns what the authenticated user of a request may see and do
+func (s *Server) requestAccess(r *http.Request) *Access {
+	if !s.authEnabled() {
+		return fullAccess
+	}
+	user := requestUser(r)
+	if user == nil {
+		if share := requestShare(r); share != nil {
+			return share.access()
+		}
+		return &Access{roots: map[string]bool{}}
+	}
+	return s.accessFor(user)
+}
+
+// fileOperationsAllowed reports whether the config and the requester both permit file mutations
+func (s *Server) fileOperationsAllowed(r *http.Request) bool {
+	return s.config.AllowFileOperations != nil && *s.config.AllowFileOperations && s.requestAccess(r).FileOperations
+}
+
+// checkFileOperations answers 403 unless file mutations are allowed for the request
+func (s *Server) checkFileOperations(w http.ResponseWriter, r *http.Request, disabledMessage string) bool {
+	if s.config.AllowFileOperations == nil || !*s.config.AllowFileOperations {
+		respondError(w, disabledMessage, http.StatusForbidden)
+		return false
+	}
+	if !s.requestAccess(r).FileOperations {
+		respondError(w, "You do not have permission to modify files", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// requireSettings restricts a handler to users allowed to change server settings
+func (s *Server) requireSettings(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.requestAccess(r).Settings {
+			respondError(w, "You do not have permission to change settings", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
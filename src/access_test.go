@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAccessRulesLimitRootsAndCapabilities(t *testing.T) {
+	comics, adult := t.TempDir(), t.TempDir()
+	for _, path := range []string{filepath.Join(comics, "kids.cbz"), filepath.Join(adult, "secret.cbz")} {
+		if err := os.WriteFile(path, []byte("book"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	enabled := true
+	server := newAuthTestServerWithConfig(t, &Config{
+		Roots:               []RootConfig{{Path: comics, Name: "Comics"}, {Path: adult, Name: "Adult"}},
+		AllowFileOperations: &enabled,
+		Groups: map[string]AccessRule{
+			"kids":    {Roots: []string{"Comics"}},
+			"editors": {FileOperations: true},
+		},
+	})
+	admin := sessionCookie(t, authRequest(t, server, "POST", "/api/settings/users", map[string]interface{}{"name": "parent", "password": "parentpass"}))
+	for _, user := range []map[string]interface{}{
+		{"name": "kid", "password": "kidpass1", "groups": []string{"kids"}},
+		{"name": "helper", "password": "helperpass", "groups": []string{"kids", "editors"}},
+	} {
+		if response := authRequest(t, server, "POST", "/api/settings/users", user, admin); response.Code != http.StatusOK {
+			t.Fatalf("create %v = %d; body = %s", user["name"], response.Code, response.Body.String())
+		}
+	}
+	kid := sessionCookie(t, authRequest(t, server, "POST", "/api/auth/login", map[string]string{"name": "kid", "password": "kidpass1"}))
+	helper := sessionCookie(t, authRequest(t, server, "POST", "/api/auth/login", map[string]string{"name": "helper", "password": "helperpass"}))
+
+	var roots struct {
+		Files               []fileItem `json:"files"`
+		AllowFileOperations bool       `json:"allowFileOperations"`
+	}
+	response := authRequest(t, server, "GET", "/api/dir", nil, kid)
+	if err := json.Unmarshal(response.Body.Bytes(), &roots); err != nil {
+		t.Fatal(err)
+	}
+	if len(roots.Files) != 1 || roots.Files[0].Name != "Comics" || roots.AllowFileOperations {
+		t.Fatalf("kid roots = %+v", roots)
+	}
+	if response := authRequest(t, server, "GET", "/api/dir/Adult", nil, kid); response.Code != http.StatusNotFound {
+		t.Fatalf("kid hidden root = %d, want 404", response.Code)
+	}
+	if response := authRequest(t, server, "GET", "/api/file/Adult/secret.cbz", nil, kid); response.Code == http.StatusOK {
+		t.Fatal("kid downloaded a file from a hidden root")
+	}
+	if response := authRequest(t, server, "POST", "/api/command/remove", map[string]string{"path": "Comics/kids.cbz"}, kid); response.Code != http.StatusForbidden {
+		t.Fatalf("kid remove = %d, want 403", response.Code)
+	}
+	if response := authRequest(t, server, "GET", "/api/settings/config", nil, kid); response.Code != http.StatusForbidden {
+		t.Fatalf("kid settings = %d, want 403", response.Code)
+	}
+
+	// Group rules combine: kids' root list plus editors' file operations
+	if response := authRequest(t, server, "POST", "/api/command/remove", map[string]string{"path": "Adult/secret.cbz"}, helper); response.Code != http.StatusBadRequest {
+		t.Fatalf("helper remove in hidden root = %d, want 400", response.Code)
+	}
+	if response := authRequest(t, server, "POST", "/api/command/remove", map[string]string{"path": "Comics/kids.cbz"}, helper); response.Code != http.StatusOK {
+		t.Fatalf("helper remove = %d; body = %s", response.Code, response.Body.String())
+	}
+
+	response = authRequest(t, server, "GET", "/api/dir", nil, admin)
+	if err := json.Unmarshal(response.Body.Bytes(), &roots); err != nil {
+		t.Fatal(err)
+	}
+	if len(roots.Files) != 2 || !roots.AllowFileOperations {
+		t.Fatalf("admin roots = %+v", roots)
+	}
+}
+
+func TestSettingsCapabilityCannotChangeAccessControl(t *testing.T) {
+	root := t.TempDir()
+	saved := configPath
+	configPath = filepath.Join(t.TempDir(), "config.json")
+	t.Cleanup(func() { configPath = saved })
+	cfg := &Config{Port: 8539, Roots: []RootConfig{{Path: root, Name: "Root"}}, Groups: map[string]AccessRule{"staff": {Settings: true}}}
+	if err := saveConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	server := newAuthTestServerWithConfig(t, cfg)
+	admin := sessionCookie(t, authRequest(t, server, "POST", "/api/settings/users", map[string]interface{}{"name": "admin", "password": "adminpass"}))
+	authRequest(t, server, "POST", "/api/settings/users", map[string]interface{}{"name": "staff", "password": "staffpass", "groups": []string{"staff"}}, admin)
+	staff := sessionCookie(t, authRequest(t, server, "POST", "/api/auth/login", map[string]string{"name": "staff", "password": "staffpass"}))
+
+	updated := *cfg
+	updated.Port = 8540
+	if response := authRequest(t, server, "POST", "/api/settings/config", updated, staff); response.Code != http.StatusOK {
+		t.Fatalf("staff port change = %d; body = %s", response.Code, response.Body.String())
+	}
+	updated.Groups = map[string]AccessRule{"staff": {Settings: true, FileOperations: true}}
+	if response := authRequest(t, server, "POST", "/api/settings/config", updated, staff); response.Code != http.StatusForbidden {
+		t.Fatalf("staff groups change = %d, want 403", response.Code)
+	}
+	updated.Groups = cfg.Groups
+	updated.TrustedProxies = []string{"0.0.0.0/0"}
+	if response := authRequest(t, server, "POST", "/api/settings/config", updated, staff); response.Code != http.StatusForbidden {
+		t.Fatalf("staff trustedProxies change = %d, want 403", response.Code)
+	}
+	updated.TrustedProxies = nil
+	// Renaming a root could reveal one hidden from the staff group
+	updated.Roots = []RootConfig{{Path: root, Name: "Renamed"}}
+	if response := authRequest(t, server, "POST", "/api/settings/config", updated, staff); response.Code != http.StatusForbidden {
+		t.Fatalf("staff roots change = %d, want 403", response.Code)
+	}
+	updated.Roots = cfg.Roots
+	updated.TrustedProxies = []string{"0.0.0.0/0"}
+	if response := authRequest(t, server, "POST", "/api/settings/config", updated, admin); response.Code != http.StatusOK {
+		t.Fatalf("admin trustedProxies change = %d; body = %s", response.Code, response.Body.String())
+	}
+}
+
+func TestAccessDefaultsToNoRoots(t *testing.T) {
+	t.Setenv("DATA_DIR", t.TempDir())
+	server := initServer(&Config{
+		Roots:  []RootConfig{{Path: t.TempDir(), Name: "Comics"}, {Path: t.TempDir(), Name: "Adult"}},
+		Groups: map[string]AccessRule{"kids": {Roots: []string{"Comics"}}, "everyone": {Roots: []string{"*"}}, "staff": {Settings: true}},
+	})
+	tests := []struct {
+		name          string
+		user          User
+		defaultGroups []string
+		comics, adult bool
+	}{
+		{"new account", User{Name: "new"}, nil, false, false},
+		{"capability only", User{Name: "staff", Groups: []string{"staff"}}, nil, false, false},
+		{"default group", User{Name: "new"}, []string{"kids"}, true, false},
+		{"own groups replace the default", User{Name: "all", Groups: []string{"everyone"}}, []string{"kids"}, true, true},
+		{"own rule replaces the default", User{Name: "rule", Access: &AccessRule{Share: true}}, []string{"kids"}, false, false},
+	}
+	for _, tt := range tests {
+		server.config.DefaultGroups = tt.defaultGroups
+		access := server.accessFor(&tt.user)
+		if access.canSeeRoot("Comics") != tt.comics || access.canSeeRoot("Adult") != tt.adult {
+			t.Errorf("%s: Comics %v, Adult %v; want %v, %v", tt.name, access.canSeeRoot("Comics"), access.canSeeRoot("Adult"), tt.comics, tt.adult)
+		}
+	}
+}
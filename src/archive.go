@@ -223,3 +223,86 @@ func extractFileFrom7z(sevenZPath, fileName string) ([]byte, error) {
 
 	return nil, fmt.Errorf("file not found in archive: %s", fileName)
 }
+
+// errStopWalk ends walkBookEntries early without reporting an error
+var errStopWalk = fmt.Errorf("stop walking archive")
+
+// walkBookEntries calls fn for every file in a book, in archive order, with a reader
+// for its content. Returning errStopWalk from fn ends the walk successfully.
+func walkBookEntries(bookPath string, fn func(name string, r io.Reader) error) error {
+	err := walkArchiveEntries(bookPath, fn)
+	if err == errStopWalk {
+		return nil
+	}
+	return err
+}
+
+func walkArchiveEntries(bookPath string, fn func(name string, r io.Reader) error) error {
+	switch ext := strings.ToLower(filepath.Ext(bookPath)); ext {
+	case ".zip", ".cbz", ".epub":
+		r, err := zip.OpenReader(bookPath)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		for _, f := range r.File {
+			if f.FileInfo().IsDir() {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				return err
+			}
+			err = fn(f.Name, rc)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case ".rar", ".cbr":
+		r, err := rardecode.OpenReader(bookPath)
+		if err != nil {
+			return fmt.Errorf("failed to open RAR archive: %w", err)
+		}
+		defer r.Close()
+		for {
+			header, err := r.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if header.IsDir {
+				continue
+			}
+			if err := fn(header.Name, r); err != nil {
+				return err
+			}
+		}
+	case ".7z", ".cb7":
+		r, err := sevenzip.OpenReader(bookPath)
+		if err != nil {
+			return fmt.Errorf("failed to open 7Z archive: %w", err)
+		}
+		defer r.Close()
+		for _, f := range r.File {
+			if f.FileInfo().IsDir() {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				return err
+			}
+			err = fn(f.Name, rc)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported archive format: %s", ext)
+	}
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	auditFileName        = "audit.log"
+	defaultAuditMaxSize  = 10 // MB
+	defaultAuditMaxFiles = 5
+	defaultAuditLimit    = 100
+	maxAuditLimit        = 1000
+	auditContextKey      = contextKey("audit")
+)
+
+// AuditConfig controls the audit log of file operations and settings changes
+type AuditConfig struct {
+	MaxSizeMB int `json:"maxSizeMB,omitempty"` // Rotate when the log exceeds this size (default 10)
+	MaxFiles  int `json:"maxFiles,omitempty"`  // Rotated logs to keep (default 5)
+}
+
+// AuditEntry is one mutating request
+type AuditEntry struct {
+	Time   time.Time `json:"time"`
+	Action string    `json:"action"`
+	Method string    `json:"method"`
+	User   string    `json:"user,omitempty"`
+	IP     string    `json:"ip,omitempty"`
+	Target string    `json:"target,omitempty"` // User, token or share the action applies to
+	Paths  []string  `json:"paths,omitempty"`
+	Status int       `json:"status"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// AuditLog appends entries to a JSON-lines file in the data directory, rotating
+// it to audit.log.1, audit.log.2, ... when it grows too large
+type AuditLog struct {
+	mu       sync.Mutex
+	path     string
+	maxSize  int64
+	maxFiles int
+}
+
+func newAuditLog(path string, cfg *AuditConfig) *AuditLog {
+	maxSize, maxFiles := defaultAuditMaxSize, defaultAuditMaxFiles
+	if cfg != nil {
+		if cfg.MaxSizeMB > 0 {
+			maxSize = cfg.MaxSizeMB
+		}
+		if cfg.MaxFiles > 0 {
+			maxFiles = cfg.MaxFiles
+		}
+	}
+	return &AuditLog{path: path, maxSize: int64(maxSize) << 20, maxFiles: maxFiles}
+}
+
+// rotatedPath returns the path of the n-th rotated log; 0 is the current log
+func (a *AuditLog) rotatedPath(n int) string {
+	if n == 0 {
+		return a.path
+	}
+	return a.path + "." + strconv.Itoa(n)
+}
+
+// append writes an entry, rotating first when the log is full
+func (a *AuditLog) append(entry AuditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if info, err := os.Stat(a.path); err == nil && info.Size()+int64(len(line)) > a.maxSize {
+		os.Remove(a.rotatedPath(a.maxFiles))
+		for n := a.maxFiles - 1; n >= 0; n-- {
+			os.Rename(a.rotatedPath(n), a.rotatedPath(n+1))
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(a.path), 0700); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(a.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(line); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// auditQuery filters audit entries; zero fields match everything
+type auditQuery struct {
+	Action string
+	User   string
+	Path   string // Matches entries touching this path or anything below it
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+}
+
+func (q *auditQuery) matches(entry *AuditEntry) bool {
+	if q.Action != "" && entry.Action != q.Action {
+		return false
+	}
+	if q.User != "" && entry.User != q.User {
+		return false
+	}
+	if !q.Since.IsZero() && entry.Time.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !entry.Time.Before(q.Until) {
+		return false
+	}
+	if q.Path == "" {
+		return true
+	}
+	for _, p := range entry.Paths {
+		if p == q.Path || strings.HasPrefix(p, q.Path+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// query returns matching entries, newest first
+func (a *AuditLog) query(q auditQuery) ([]AuditEntry, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	result := make([]AuditEntry, 0)
+	for n := 0; n <= a.maxFiles && len(result) < q.Limit; n++ {
+		data, err := os.ReadFile(a.rotatedPath(n))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var entries []AuditEntry
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		scanner.Buffer(make([]byte, 64*1024), 1<<20)
+		for scanner.Scan() {
+			var entry AuditEntry
+			if json.Unmarshal(scanner.Bytes(), &entry) == nil && q.matches(&entry) {
+				entries = append(entries, entry)
+			}
+		}
+		for i := len(entries) - 1; i >= 0 && len(result) < q.Limit; i-- {
+			result = append(result, entries[i])
+		}
+	}
+	return result, nil
+}
+
+// auditRecord collects what a handler touched while an audited request runs
+type auditRecord struct {
+	paths []string
+}
+
+// auditPaths notes the library or file paths an audited request acts on
+func auditPaths(r *http.Request, paths ...string) {
+	record, ok := r.Context().Value(auditContextKey).(*auditRecord)
+	if !ok {
+		return
+	}
+	for _, p := range paths {
+		if p != "" {
+			record.paths = append(record.paths, filepath.Clean(filepath.FromSlash(p)))
+		}
+	}
+}
+
+// auditResponseWriter captures the status and the error message of a response
+type auditResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   []byte
+}
+
+func (w *auditResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *auditResponseWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.status >= 400 && len(w.body) < 1024 {
+		w.body = append(w.body, data...)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *auditResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying connection (upload deadlines)
+func (w *auditResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// audited records every state-changing request to a handler in the audit log
+func (s *Server) audited(action string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isStateChanging(r.Method) {
+			next(w, r)
+			return
+		}
+		record := &auditRecord{}
+		recorder := &auditResponseWriter{ResponseWriter: w}
+		start := time.Now()
+		next(recorder, r.WithContext(context.WithValue(r.Context(), auditContextKey, record)))
+
+		entry := AuditEntry{
+			Time:   start,
+			Action: action,
+			Method: r.Method,
+			User:   requestUserName(r),
+			Paths:  record.paths,
+			Status: recorder.status,
+		}
+		if ip := s.clientIP(r); ip != nil {
+			entry.IP = ip.String()
+		}
+		vars := mux.Vars(r)
+		if target := vars["name"]; target != "" {
+			entry.Target = target
+		} else if target := vars["id"]; target != "" {
+			entry.Target = target
+		}
+		if entry.Status == 0 {
+			entry.Status = http.StatusOK
+		}
+		if entry.Status >= 400 {
+			var response ErrorResponse
+			if json.Unmarshal(recorder.body, &response) == nil {
+				entry.Error = response.Error
+			} else {
+				entry.Error = strings.TrimSpace(string(recorder.body))
+			}
+			if entry.Error == "" {
+				entry.Error = http.StatusText(entry.Status)
+			}
+		}
+		if err := s.audit.append(entry); err != nil {
+			logStoreError("audit log", err)
+		}
+	}
+}
+
+// handleAudit returns audit log entries, newest first. Query parameters: action,
+// user, path, since and until (RFC 3339) and limit.
+func (s *Server) handleAudit(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	q := auditQuery{
+		Action: query.Get("action"),
+		User:   query.Get("user"),
+		Path:   filepath.Clean(filepath.FromSlash(query.Get("path"))),
+		Limit:  defaultAuditLimit,
+	}
+	if q.Path == "." {
+		q.Path = ""
+	}
+	for name, target := range map[string]*time.Time{"since": &q.Since, "until": &q.Until} {
+		if value := query.Get(name); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				respondError(w, fmt.Sprintf("%s must be an RFC 3339 time", name), http.StatusBadRequest)
+				return
+			}
+			*target = t
+		}
+	}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			respondError(w, "limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+		q.Limit = min(limit, maxAuditLimit)
+	}
+
+	entries, err := s.audit.query(q)
+	if err != nil {
+		respondError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, struct {
+		Entries []AuditEntry `json:"entries"`
+	}{entries})
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAuditLogRecordsMutations(t *testing.T) {
+	root := t.TempDir()
+	enabled := true
+	server := newAuthTestServerWithConfig(t, &Config{
+		Roots:               []RootConfig{{Path: root, Name: "Root"}},
+		AllowFileOperations: &enabled,
+	})
+
+	if response := authRequest(t, server, "POST", "/api/command/mkdir", map[string]string{"path": "Root", "name": "Series"}); response.Code != http.StatusOK {
+		t.Fatalf("mkdir = %d; body = %s", response.Code, response.Body.String())
+	}
+	if response := authRequest(t, server, "POST", "/api/command/remove", map[string]string{"path": "Root/missing"}); response.Code == http.StatusOK {
+		t.Fatal("removing a missing path succeeded")
+	}
+	// Reads are not recorded
+	authRequest(t, server, "GET", "/api/dir/Root", nil)
+
+	query := func(target string) []AuditEntry {
+		t.Helper()
+		response := authRequest(t, server, "GET", target, nil)
+		var result struct {
+			Entries []AuditEntry `json:"entries"`
+		}
+		if err := json.Unmarshal(response.Body.Bytes(), &result); err != nil {
+			t.Fatalf("%v; body = %s", err, response.Body.String())
+		}
+		return result.Entries
+	}
+
+	entries := query("/api/settings/audit")
+	if len(entries) != 2 {
+		t.Fatalf("entries = %+v", entries)
+	}
+	removed, created := entries[0], entries[1]
+	if removed.Action != "remove" || removed.Status != http.StatusNotFound || removed.Error == "" {
+		t.Fatalf("remove entry = %+v", removed)
+	}
+	if created.Action != "mkdir" || created.Status != http.StatusOK || created.IP != "192.0.2.1" ||
+		len(created.Paths) != 1 || created.Paths[0] != filepath.Join("Root", "Series") {
+		t.Fatalf("mkdir entry = %+v", created)
+	}
+
+	if entries := query("/api/settings/audit?action=mkdir"); len(entries) != 1 {
+		t.Fatalf("action filter = %+v", entries)
+	}
+	if entries := query("/api/settings/audit?path=Root/Series"); len(entries) != 1 || entries[0].Action != "mkdir" {
+		t.Fatalf("path filter = %+v", entries)
+	}
+	if entries := query("/api/settings/audit?since=" + time.Now().Add(time.Hour).Format(time.RFC3339)); len(entries) != 0 {
+		t.Fatalf("since filter = %+v", entries)
+	}
+}
+
+func TestAuditLogRotation(t *testing.T) {
+	dir := t.TempDir()
+	audit := newAuditLog(filepath.Join(dir, auditFileName), &AuditConfig{MaxFiles: 2})
+	audit.maxSize = 200
+
+	for i := 0; i < 10; i++ {
+		if err := audit.append(AuditEntry{Time: time.Now(), Action: "mkdir", Paths: []string{"Root/folder"}, Status: http.StatusOK}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{auditFileName, auditFileName + ".1", auditFileName + ".2"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, auditFileName+".3")); !os.IsNotExist(err) {
+		t.Fatal("kept more rotated logs than maxFiles")
+	}
+	entries, err := audit.query(auditQuery{Limit: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) == 0 || len(entries) >= 10 {
+		t.Fatalf("entries after rotation = %d", len(entries))
+	}
+}
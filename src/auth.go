@@ -0,0 +1,651 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/maruel/natural"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	sessionCookieName = "litecomics_session"
+	defaultSessionTTL = 30 * 24 * time.Hour
+	minPasswordLength = 8
+)
+
+// passwordHashCost is the bcrypt work factor for stored passwords (lowered in tests)
+var passwordHashCost = bcrypt.DefaultCost
+
+// AuthConfig configures login sessions. Accounts themselves live in the data directory.
+type AuthConfig struct {
+	SessionTTL        string `json:"sessionTTL,omitempty"`        // Session lifetime as a Go duration such as "720h" (default 30 days)
+	ProxyHeader       string `json:"proxyHeader,omitempty"`       // Header carrying the username from a trusted proxy, e.g. "Remote-User"
+	ProxyGroupsHeader string `json:"proxyGroupsHeader,omitempty"` // Comma-separated group names from a trusted proxy, e.g. "Remote-Groups"
+}
+
+// User is a login account
+type User struct {
+	Name         string      `json:"name"`
+	PasswordHash string      `json:"passwordHash"`
+	SyncKeyHash  string      `json:"syncKeyHash,omitempty"` // KOReader sync key (MD5 of the password), hashed
+	Admin        bool        `json:"admin,omitempty"`
+	Groups       []string    `json:"groups,omitempty"` // Names of config.json groups whose rules apply
+	Access       *AccessRule `json:"access,omitempty"` // Rule for this user only
+	Created      time.Time   `json:"created"`
+}
+
+// Session is a logged-in browser. Only a hash of the cookie token is stored.
+type Session struct {
+	User    string    `json:"user"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+}
+
+// UserStore persists accounts and sessions in the data directory.
+// Authentication is enforced once the first account exists.
+type UserStore struct {
+	mu       sync.Mutex
+	path     string
+	Users    map[string]*User     `json:"users"`
+	Sessions map[string]*Session  `json:"sessions"` // token hash -> session
+	Tokens   map[string]*APIToken `json:"tokens"`   // token hash -> API token
+}
+
+func loadUserStore(path string) *UserStore {
+	store := &UserStore{
+		path:     path,
+		Users:    make(map[string]*User),
+		Sessions: make(map[string]*Session),
+		Tokens:   make(map[string]*APIToken),
+	}
+	if err := readJSONFile(path, store); err != nil {
+		logStoreLoadError("users", err)
+	}
+	if store.Users == nil {
+		store.Users = make(map[string]*User)
+	}
+	if store.Sessions == nil {
+		store.Sessions = make(map[string]*Session)
+	}
+	if store.Tokens == nil {
+		store.Tokens = make(map[string]*APIToken)
+	}
+	return store
+}
+
+// save drops expired sessions and tokens and writes the store; callers must hold mu
+func (u *UserStore) save() error {
+	now := time.Now()
+	for hash, session := range u.Sessions {
+		if now.After(session.Expires) {
+			delete(u.Sessions, hash)
+		}
+	}
+	for hash, token := range u.Tokens {
+		if token.Expires != nil && now.After(*token.Expires) {
+			delete(u.Tokens, hash)
+		}
+	}
+	return writeJSONFile(u.path, u)
+}
+
+// enabled reports whether any account exists, i.e. whether requests must authenticate
+func (u *UserStore) enabled() bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return len(u.Users) > 0
+}
+
+// hashToken returns the stored form of a session or API token
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// newToken returns a random bearer secret
+func newToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// dummyPasswordHash is compared against when the user does not exist, so
+// unknown names take as long to reject as wrong passwords.
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("litecomics"), bcrypt.DefaultCost)
+
+// get returns a copy of an account
+func (u *UserStore) get(name string) (User, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	user, ok := u.Users[name]
+	if !ok {
+		return User{}, false
+	}
+	return *user, true
+}
+
+// authenticate checks a name and password and returns a copy of the account
+func (u *UserStore) authenticate(name, password string) (User, bool) {
+	u.mu.Lock()
+	user, ok := u.Users[name]
+	var account User
+	if ok {
+		account = *user
+	}
+	u.mu.Unlock()
+
+	if !ok {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		return User{}, false
+	}
+	if bcrypt.CompareHashAndPassword([]byte(account.PasswordHash), []byte(password)) != nil {
+		return User{}, false
+	}
+	return account, true
+}
+
+// createSession starts a session for name and returns the cookie token
+func (u *UserStore) createSession(name string, ttl time.Duration) (string, time.Time, error) {
+	token := newToken()
+	now := time.Now()
+	session := &Session{User: name, Created: now, Expires: now.Add(ttl)}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.Sessions[hashToken(token)] = session
+	return token, session.Expires, u.save()
+}
+
+// sessionUser returns the account of a valid session token. Sessions past half
+// their lifetime are extended so active users stay logged in.
+func (u *UserStore) sessionUser(token string, ttl time.Duration) (User, bool) {
+	hash := hashToken(token)
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	session, ok := u.Sessions[hash]
+	if !ok {
+		return User{}, false
+	}
+	now := time.Now()
+	user, exists := u.Users[session.User]
+	if !exists || now.After(session.Expires) {
+		delete(u.Sessions, hash)
+		if err := u.save(); err != nil {
+			logStoreError("users", err)
+		}
+		return User{}, false
+	}
+	if session.Expires.Sub(now) < ttl/2 {
+		session.Expires = now.Add(ttl)
+		if err := u.save(); err != nil {
+			logStoreError("users", err)
+		}
+	}
+	return *user, true
+}
+
+// deleteSession ends the session of a cookie token
+func (u *UserStore) deleteSession(token string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	hash := hashToken(token)
+	if _, ok := u.Sessions[hash]; !ok {
+		return
+	}
+	delete(u.Sessions, hash)
+	if err := u.save(); err != nil {
+		logStoreError("users", err)
+	}
+}
+
+// revokeSessions ends every session of name except the one with keepHash; callers must hold mu
+func (u *UserStore) revokeSessions(name, keepHash string) {
+	for hash, session := range u.Sessions {
+		if session.User == name && hash != keepHash {
+			delete(u.Sessions, hash)
+		}
+	}
+}
+
+// adminCount returns how many administrators exist; callers must hold mu
+func (u *UserStore) adminCount() int {
+	count := 0
+	for _, user := range u.Users {
+		if user.Admin {
+			count++
+		}
+	}
+	return count
+}
+
+// validateUserName returns an error message for unusable account names
+func validateUserName(name string) string {
+	if name == "" {
+		return "Name is required"
+	}
+	if name != strings.TrimSpace(name) || len(name) > 64 || strings.ContainsAny(name, "/\\:") {
+		return "Name must be at most 64 characters without surrounding spaces, slashes or colons"
+	}
+	return ""
+}
+
+// normalizeGroups trims and deduplicates group names
+func normalizeGroups(groups []string) []string {
+	seen := make(map[string]bool)
+	var result []string
+	for _, group := range groups {
+		if group = strings.TrimSpace(group); group != "" && !seen[group] {
+			seen[group] = true
+			result = append(result, group)
+		}
+	}
+	return result
+}
+
+// hashPassword validates and hashes a new password
+func hashPassword(password string) (string, string) {
+	if len([]rune(password)) < minPasswordLength {
+		return "", "Password must be at least 8 characters"
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), passwordHashCost)
+	if err != nil {
+		return "", err.Error()
+	}
+	return string(hash), ""
+}
+
+type contextKey string
+
+const userContextKey contextKey = "user"
+
+// requestUser returns the authenticated account of a request, or nil when
+// authentication is disabled
+func requestUser(r *http.Request) *User {
+	user, _ := r.Context().Value(userContextKey).(*User)
+	return user
+}
+
+// requestUserName returns the name of the authenticated account, or "" when
+// authentication is disabled
+func requestUserName(r *http.Request) string {
+	if user := requestUser(r); user != nil {
+		return user.Name
+	}
+	return ""
+}
+
+// isAdminRequest reports whether a request may change server-wide settings
+func (s *Server) isAdminRequest(r *http.Request) bool {
+	if !s.authEnabled() {
+		return true
+	}
+	user := requestUser(r)
+	return user != nil && user.Admin
+}
+
+// sessionTTL returns the configured session lifetime
+func (s *Server) sessionTTL() time.Duration {
+	if s.config.Auth != nil && s.config.Auth.SessionTTL != "" {
+		if ttl, err := time.ParseDuration(s.config.Auth.SessionTTL); err == nil && ttl > 0 {
+			return ttl
+		}
+	}
+	return defaultSessionTTL
+}
+
+// isPublicPath reports whether a path is reachable without logging in
+func isPublicPath(p string) bool {
+	switch p {
+	case "/api/auth/login", "/api/auth/me", "/login", "/favicon.svg", "/apple-touch-icon.png":
+		return true
+	}
+	return strings.HasPrefix(p, "/login/") || strings.HasPrefix(p, "/share/") ||
+		strings.HasPrefix(p, kosyncPrefix) // KOReader sends its own credentials
+}
+
+// authMiddleware requires a valid session or API token for the API and the UI once accounts exist
+func (s *Server) authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.authEnabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if s.authenticateBearer(w, r, next) {
+			return
+		}
+		if user, ok := s.proxyUser(r); ok {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userContextKey, user)))
+			return
+		}
+		if cookie, err := r.Cookie(sessionCookieName); err == nil {
+			if user, ok := s.users.sessionUser(cookie.Value, s.sessionTTL()); ok {
+				next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userContextKey, &user)))
+				return
+			}
+		}
+		if isPublicPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if s.authorizeShare(w, r, next) {
+			return
+		}
+		if s.authorizeMediaURL(w, r, next) {
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			respondError(w, "Authentication required", http.StatusUnauthorized)
+			return
+		}
+		http.Redirect(w, r, "/login/?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+	})
+}
+
+// setSessionCookie starts a session for name and hands its token to the browser
+func (s *Server) setSessionCookie(w http.ResponseWriter, r *http.Request, name string) error {
+	token, expires, err := s.users.createSession(name, s.sessionTTL())
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   s.requestScheme(r) == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// userInfo is the public view of an account
+type userInfo struct {
+	Name    string      `json:"name"`
+	Admin   bool        `json:"admin"`
+	Groups  []string    `json:"groups,omitempty"`
+	Access  *AccessRule `json:"access,omitempty"`
+	Created time.Time   `json:"created"`
+}
+
+func (u *User) info() userInfo {
+	return userInfo{Name: u.Name, Admin: u.Admin, Groups: u.Groups, Access: u.Access, Created: u.Created}
+}
+
+// handleLogin checks a name and password and issues a session cookie
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name     string `json:"name"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	client, now := s.rateLimitClient(r), time.Now()
+	if locked, wait := s.loginGuard.locked(client, req.Name, now); locked {
+		respondTooManyRequests(w, "Too many failed logins; try again later", wait)
+		return
+	}
+	user, ok := s.users.authenticate(req.Name, req.Password)
+	if !ok {
+		s.loginGuard.fail(client, req.Name, now)
+		respondError(w, "Invalid name or password", http.StatusUnauthorized)
+		return
+	}
+	s.loginGuard.succeed(client, req.Name)
+	if user.SyncKeyHash == "" {
+		// Accounts created before KOReader sync get their key on the next login
+		s.users.setSyncKey(user.Name, req.Password)
+	}
+	if err := s.setSessionCookie(w, r, user.Name); err != nil {
+		respondError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, user.info())
+}
+
+// handleLogout ends the current session
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(sessionCookieName); err == nil {
+		s.users.deleteSession(cookie.Value)
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   s.requestScheme(r) == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+	respondJSON(w, map[string]string{"status": "ok"})
+}
+
+// handleMe reports whether login is required and who is logged in
+func (s *Server) handleMe(w http.ResponseWriter, r *http.Request) {
+	response := struct {
+		AuthEnabled bool      `json:"authEnabled"`
+		User        *userInfo `json:"user,omitempty"`
+	}{AuthEnabled: s.authEnabled()}
+	if user := requestUser(r); user != nil {
+		info := user.info()
+		response.User = &info
+	}
+	respondJSON(w, response)
+}
+
+// handleUsers lists (GET) or creates (POST) accounts. While no account exists
+// anyone may create the first one, which becomes an administrator and is logged in.
+func (s *Server) handleUsers(w http.ResponseWriter, r *http.Request) {
+	bootstrap := !s.users.enabled()
+	if !bootstrap && !s.isAdminRequest(r) {
+		respondError(w, "Administrator access required", http.StatusForbidden)
+		return
+	}
+
+	if r.Method == "GET" {
+		s.users.mu.Lock()
+		users := make([]userInfo, 0, len(s.users.Users))
+		for _, user := range s.users.Users {
+			users = append(users, user.info())
+		}
+		s.users.mu.Unlock()
+		sort.Slice(users, func(i, j int) bool { return natural.Less(users[i].Name, users[j].Name) })
+		respondJSON(w, struct {
+			Users []userInfo `json:"users"`
+		}{users})
+		return
+	}
+
+	var req struct {
+		Name     string      `json:"name"`
+		Password string      `json:"password"`
+		Admin    bool        `json:"admin"`
+		Groups   []string    `json:"groups"`
+		Access   *AccessRule `json:"access"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if message := validateUserName(req.Name); message != "" {
+		respondError(w, message, http.StatusBadRequest)
+		return
+	}
+	hash, message := hashPassword(req.Password)
+	if message != "" {
+		respondError(w, message, http.StatusBadRequest)
+		return
+	}
+	syncKeyHash, err := hashSyncKey(req.Password)
+	if err != nil {
+		respondError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	store := s.users
+	store.mu.Lock()
+	if bootstrap && len(store.Users) > 0 {
+		store.mu.Unlock()
+		respondError(w, "Administrator access required", http.StatusForbidden)
+		return
+	}
+	if _, exists := store.Users[req.Name]; exists {
+		store.mu.Unlock()
+		respondError(w, "User already exists", http.StatusConflict)
+		return
+	}
+	user := &User{
+		Name: req.Name, PasswordHash: hash, SyncKeyHash: syncKeyHash, Admin: req.Admin || bootstrap,
+		Groups: normalizeGroups(req.Groups), Access: req.Access, Created: time.Now(),
+	}
+	store.Users[user.Name] = user
+	err = store.save()
+	info := user.info()
+	store.mu.Unlock()
+	if err != nil {
+		respondError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if bootstrap {
+		// The creator would otherwise be locked out by their own new account
+		if err := s.setSessionCookie(w, r, user.Name); err != nil {
+			respondError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	respondJSON(w, info)
+}
+
+// handleUser changes (PUT) or deletes (DELETE) an account. Users may change
+// their own password by giving the current one; everything else requires an
+// administrator.
+func (s *Server) handleUser(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	current := requestUser(r)
+	self := current != nil && current.Name == name
+	if !(self && r.Method == "PUT") && !s.isAdminRequest(r) {
+		respondError(w, "Administrator access required", http.StatusForbidden)
+		return
+	}
+
+	var req struct {
+		Password        *string     `json:"password"`
+		CurrentPassword string      `json:"currentPassword"`
+		Admin           *bool       `json:"admin"`
+		Groups          *[]string   `json:"groups"`
+		Access          *AccessRule `json:"access"`
+	}
+	if r.Method == "PUT" {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if (req.Admin != nil || req.Groups != nil || req.Access != nil) && !s.isAdminRequest(r) {
+			respondError(w, "Administrator access required", http.StatusForbidden)
+			return
+		}
+		if req.Password != nil && !s.isAdminRequest(r) {
+			// A stolen session must not be enough to take over the account
+			client, now := s.rateLimitClient(r), time.Now()
+			if locked, wait := s.loginGuard.locked(client, name, now); locked {
+				respondTooManyRequests(w, "Too many failed logins; try again later", wait)
+				return
+			}
+			if _, ok := s.users.authenticate(name, req.CurrentPassword); !ok {
+				s.loginGuard.fail(client, name, now)
+				respondError(w, "Current password is incorrect", http.StatusForbidden)
+				return
+			}
+			s.loginGuard.succeed(client, name)
+		}
+	}
+	var hash, syncKeyHash string
+	if req.Password != nil {
+		var message string
+		if hash, message = hashPassword(*req.Password); message != "" {
+			respondError(w, message, http.StatusBadRequest)
+			return
+		}
+		var err error
+		if syncKeyHash, err = hashSyncKey(*req.Password); err != nil {
+			respondError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	store := s.users
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	user, ok := store.Users[name]
+	if !ok {
+		respondError(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	if r.Method == "DELETE" {
+		if user.Admin && store.adminCount() == 1 {
+			respondError(w, "Cannot delete the last administrator", http.StatusConflict)
+			return
+		}
+		delete(store.Users, name)
+		store.revokeSessions(name, "")
+		store.revokeTokens(name)
+		if err := store.save(); err != nil {
+			respondError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s.onUserDeleted(name)
+		respondJSON(w, map[string]string{"status": "ok"})
+		return
+	}
+
+	if req.Admin != nil && !*req.Admin && user.Admin && store.adminCount() == 1 {
+		respondError(w, "Cannot remove the last administrator", http.StatusConflict)
+		return
+	}
+	if req.Admin != nil {
+		user.Admin = *req.Admin
+	}
+	if req.Groups != nil {
+		user.Groups = normalizeGroups(*req.Groups)
+	}
+	if req.Access != nil {
+		// An empty rule clears the per-user rule
+		if len(req.Access.Roots) == 0 && !req.Access.Upload && !req.Access.FileOperations && !req.Access.Settings && !req.Access.Share &&
+			!req.Access.Collections {
+			user.Access = nil
+		} else {
+			user.Access = req.Access
+		}
+	}
+	if req.Password != nil {
+		user.PasswordHash = hash
+		user.SyncKeyHash = syncKeyHash
+		// Other browsers logged in with the old password are signed out
+		keep := ""
+		if cookie, err := r.Cookie(sessionCookieName); err == nil && self {
+			keep = hashToken(cookie.Value)
+		}
+		store.revokeSessions(name, keep)
+	}
+	if err := store.save(); err != nil {
+		respondError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, user.info())
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func newAuthTestServer(t *testing.T) *Server {
+	t.Helper()
+	return newAuthTestServerWithConfig(t, &Config{Roots: []RootConfig{{Path: t.TempDir(), Name: "Root"}}})
+}
+
+func newAuthTestServerWithConfig(t *testing.T, cfg *Config) *Server {
+	t.Helper()
+	t.Setenv("DATA_DIR", t.TempDir())
+	passwordHashCost = bcrypt.MinCost
+	t.Cleanup(func() { passwordHashCost = bcrypt.DefaultCost })
+	server := initServer(cfg)
+	server.setupRoutes()
+	return server
+}
+
+func authRequest(t *testing.T, server *Server, method, target string, body interface{}, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	t.Helper()
+	var reader *bytes.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	request := httptest.NewRequest(method, target, reader)
+	for _, cookie := range cookies {
+		request.AddCookie(cookie)
+	}
+	response := httptest.NewRecorder()
+	server.router.ServeHTTP(response, request)
+	return response
+}
+
+func sessionCookie(t *testing.T, response *httptest.ResponseRecorder) *http.Cookie {
+	t.Helper()
+	for _, cookie := range response.Result().Cookies() {
+		if cookie.Name == sessionCookieName && cookie.Value != "" {
+			if !cookie.HttpOnly {
+				t.Fatal("session cookie is not HttpOnly")
+			}
+			return cookie
+		}
+	}
+	t.Fatalf("no session cookie; body = %s", response.Body.String())
+	return nil
+}
+
+func TestAuthBootstrapLoginAndLogout(t *testing.T) {
+	server := newAuthTestServer(t)
+
+	// Without accounts the server stays open
+	if response := authRequest(t, server, "GET", "/api/dir", nil); response.Code != http.StatusOK {
+		t.Fatalf("open dir = %d", response.Code)
+	}
+
+	created := authRequest(t, server, "POST", "/api/settings/users", map[string]interface{}{"name": "alice", "password": "correct horse"})
+	if created.Code != http.StatusOK {
+		t.Fatalf("bootstrap = %d; body = %s", created.Code, created.Body.String())
+	}
+	bootstrapCookie := sessionCookie(t, created)
+
+	if response := authRequest(t, server, "GET", "/api/dir", nil); response.Code != http.StatusUnauthorized {
+		t.Fatalf("anonymous dir = %d, want 401", response.Code)
+	}
+	if response := authRequest(t, server, "GET", "/viewer/", nil); response.Code != http.StatusFound {
+		t.Fatalf("anonymous page = %d, want redirect", response.Code)
+	}
+	if response := authRequest(t, server, "POST", "/api/settings/users", map[string]interface{}{"name": "mallory", "password": "password123"}); response.Code != http.StatusUnauthorized {
+		t.Fatalf("second anonymous user = %d, want 401", response.Code)
+	}
+	if response := authRequest(t, server, "GET", "/api/dir", nil, bootstrapCookie); response.Code != http.StatusOK {
+		t.Fatalf("bootstrap session dir = %d", response.Code)
+	}
+
+	if response := authRequest(t, server, "POST", "/api/auth/login", map[string]string{"name": "alice", "password": "wrong password"}); response.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong password = %d", response.Code)
+	}
+	login := authRequest(t, server, "POST", "/api/auth/login", map[string]string{"name": "alice", "password": "correct horse"})
+	if login.Code != http.StatusOK {
+		t.Fatalf("login = %d; body = %s", login.Code, login.Body.String())
+	}
+	cookie := sessionCookie(t, login)
+
+	authRequest(t, server, "POST", "/api/auth/logout", nil, cookie)
+	if response := authRequest(t, server, "GET", "/api/dir", nil, cookie); response.Code != http.StatusUnauthorized {
+		t.Fatalf("after logout = %d, want 401", response.Code)
+	}
+}
+
+func TestAuthSettingsRequireAdmin(t *testing.T) {
+	server := newAuthTestServer(t)
+	admin := sessionCookie(t, authRequest(t, server, "POST", "/api/settings/users", map[string]interface{}{"name": "admin", "password": "adminpass"}))
+
+	if response := authRequest(t, server, "POST", "/api/settings/users", map[string]interface{}{"name": "kid", "password": "kidpass1"}, admin); response.Code != http.StatusOK {
+		t.Fatalf("create user = %d; body = %s", response.Code, response.Body.String())
+	}
+	kid := sessionCookie(t, authRequest(t, server, "POST", "/api/auth/login", map[string]string{"name": "kid", "password": "kidpass1"}))
+
+	if response := authRequest(t, server, "GET", "/api/settings/config", nil, kid); response.Code != http.StatusForbidden {
+		t.Fatalf("user config = %d, want 403", response.Code)
+	}
+	if response := authRequest(t, server, "DELETE", "/api/settings/users/admin", nil, kid); response.Code != http.StatusForbidden {
+		t.Fatalf("user deletes admin = %d, want 403", response.Code)
+	}
+	if response := authRequest(t, server, "PUT", "/api/settings/users/kid", map[string]string{"password": "newkidpass"}, kid); response.Code != http.StatusForbidden {
+		t.Fatalf("own password without the current one = %d, want 403", response.Code)
+	}
+	if response := authRequest(t, server, "PUT", "/api/settings/users/kid", map[string]string{"password": "newkidpass", "currentPassword": "kidpass1"}, kid); response.Code != http.StatusOK {
+		t.Fatalf("own password = %d; body = %s", response.Code, response.Body.String())
+	}
+	if response := authRequest(t, server, "DELETE", "/api/settings/users/admin", nil, admin); response.Code != http.StatusConflict {
+		t.Fatalf("delete last admin = %d, want 409", response.Code)
+	}
+	if response := authRequest(t, server, "DELETE", "/api/settings/users/kid", nil, admin); response.Code != http.StatusOK {
+		t.Fatalf("delete user = %d", response.Code)
+	}
+	if response := authRequest(t, server, "GET", "/api/dir", nil, kid); response.Code != http.StatusUnauthorized {
+		t.Fatalf("deleted user session = %d, want 401", response.Code)
+	}
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// fingerprintChunk is how much of the head and tail of a book feeds its ID.
+// The tail covers the ZIP central directory; the head covers the first pages.
+const fingerprintChunk = 64 << 10
+
+const (
+	// bookIDFlushDelay batches the writes of newly fingerprinted books
+	bookIDFlushDelay = 10 * time.Second
+	// maxQueuedBookIDs bounds the listed books waiting to be fingerprinted
+	maxQueuedBookIDs = 10000
+)
+
+// bookIDRecord is the last known location and file state of a book ID
+type bookIDRecord struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// BookIDIndex persists the mapping between content-derived book IDs and current paths
+type BookIDIndex struct {
+	mu     sync.Mutex
+	path   string
+	IDs    map[string]*bookIDRecord `json:"ids"`
+	byPath map[string]string        // request path -> ID
+	dirty  bool
+	timer  *time.Timer // pending flush of new records
+
+	pending map[string]bool // listed books waiting to be fingerprinted
+	working bool
+}
+
+func loadBookIDIndex(path string) *BookIDIndex {
+	index := &BookIDIndex{path: path, IDs: make(map[string]*bookIDRecord)}
+	if err := readJSONFile(path, index); err != nil {
+		logStoreLoadError("book IDs", err)
+	}
+	if index.IDs == nil {
+		index.IDs = make(map[string]*bookIDRecord)
+	}
+	index.byPath = make(map[string]string, len(index.IDs))
+	index.pending = make(map[string]bool)
+	for id, record := range index.IDs {
+		index.byPath[record.Path] = id
+	}
+	return index
+}
+
+// computeBookID fingerprints a book from its size and the first and last bytes of the file
+func computeBookID(fullPath string) (string, error) {
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	binary.Write(hash, binary.BigEndian, info.Size())
+	if _, err := io.CopyN(hash, file, fingerprintChunk); err != nil && err != io.EOF {
+		return "", err
+	}
+	if tail := info.Size() - fingerprintChunk; tail > fingerprintChunk {
+		if _, err := file.Seek(tail, io.SeekStart); err != nil {
+			return "", err
+		}
+		if _, err := io.Copy(hash, file); err != nil {
+			return "", err
+		}
+	} else if tail > 0 {
+		// Small files: the head read above stopped at fingerprintChunk; hash the rest
+		if _, err := io.Copy(hash, file); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil)[:16]), nil
+}
+
+// copyBookID derives the ID of a byte-identical copy from the shared fingerprint
+// and the copy's path, so each copy keeps its own progress and bookmarks
+func copyBookID(id, requestPath string) string {
+	hash := sha256.Sum256([]byte(id + "\x00" + requestPath))
+	return hex.EncodeToString(hash[:16])
+}
+
+// lookup returns the known ID of a book when its size and modification time are unchanged
+func (x *BookIDIndex) lookup(requestPath string, info os.FileInfo) string {
+	return x.known(requestPath, info.Size(), info.ModTime())
+}
+
+// known is lookup for a file state taken from a listing
+func (x *BookIDIndex) known(requestPath string, size int64, modTime time.Time) string {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	id, ok := x.byPath[requestPath]
+	if !ok {
+		return ""
+	}
+	record := x.IDs[id]
+	if record.Size != size || !record.ModTime.Equal(modTime) {
+		return ""
+	}
+	return id
+}
+
+// pathOf returns the recorded location of an ID
+func (x *BookIDIndex) pathOf(id string) (string, bool) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	record, ok := x.IDs[id]
+	if !ok {
+		return "", false
+	}
+	return record.Path, true
+}
+
+// record stores the current location of an ID; the index is written after
+// bookIDFlushDelay so scanning a library does not rewrite it for every book
+func (x *BookIDIndex) record(id, requestPath string, info os.FileInfo) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.set(id, bookIDRecord{Path: requestPath, Size: info.Size(), ModTime: info.ModTime()})
+}
+
+// recordAll stores the locations of many IDs at once
+func (x *BookIDIndex) recordAll(records map[string]bookIDRecord) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for id, record := range records {
+		x.set(id, record)
+	}
+}
+
+// set stores the location of an ID and schedules a write; callers must hold mu
+func (x *BookIDIndex) set(id string, record bookIDRecord) {
+	if previous, ok := x.byPath[record.Path]; ok && previous != id {
+		delete(x.IDs, previous)
+	}
+	if current, ok := x.IDs[id]; ok && current.Path != record.Path {
+		delete(x.byPath, current.Path)
+	}
+	x.IDs[id] = &record
+	x.byPath[record.Path] = id
+	x.dirty = true
+	if x.timer == nil {
+		x.timer = time.AfterFunc(bookIDFlushDelay, x.flush)
+	}
+}
+
+// flush saves the index when it changed
+func (x *BookIDIndex) flush() {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.timer != nil {
+		x.timer.Stop()
+		x.timer = nil
+	}
+	if !x.dirty {
+		return
+	}
+	if err := writeJSONFile(x.path, x); err != nil {
+		logStoreError("book IDs", err)
+		return
+	}
+	x.dirty = false
+}
+
+// movePath follows a rename or move without re-fingerprinting
+func (x *BookIDIndex) movePath(oldPath, newPath string) {
+	x.mu.Lock()
+	changed := false
+	for id, record := range x.IDs {
+		if moved, ok := movedPath(record.Path, oldPath, newPath); ok {
+			delete(x.byPath, record.Path)
+			record.Path = moved
+			x.byPath[moved] = id
+			changed = true
+		}
+	}
+	x.dirty = x.dirty || changed
+	x.mu.Unlock()
+	if changed {
+		x.flush()
+	}
+}
+
+// bookID returns the stable ID of a book, fingerprinting it when the cached value is stale
+func (s *Server) bookID(resolved *ResolvedPath) (string, error) {
+	info, err := os.Stat(resolved.FullPath)
+	if err != nil {
+		return "", err
+	}
+	requestPath := resolved.RequestPath()
+	if id := s.bookIDs.lookup(requestPath, info); id != "" {
+		return id, nil
+	}
+	id, err := computeBookID(resolved.FullPath)
+	if err != nil {
+		return "", err
+	}
+	if s.isCopyOf(id, requestPath) {
+		id = copyBookID(id, requestPath)
+	}
+	s.bookIDs.record(id, requestPath, info)
+	return id, nil
+}
+
+// isCopyOf reports whether the book recorded under id is still in place at another
+// path, so that a book with the same fingerprint is a copy rather than a move
+func (s *Server) isCopyOf(id, requestPath string) bool {
+	recorded, ok := s.bookIDs.pathOf(id)
+	if !ok || recorded == requestPath {
+		return false
+	}
+	resolved, err := s.resolvePath(recorded)
+	if err != nil {
+		return false
+	}
+	info, err := os.Stat(resolved.FullPath)
+	if err != nil || info.IsDir() {
+		return false
+	}
+	if s.bookIDs.lookup(recorded, info) == id {
+		return true
+	}
+	current, err := computeBookID(resolved.FullPath)
+	return err == nil && current == id
+}
+
+// queueBookID fingerprints a listed book in the background, so that later
+// listings include its ID without the listing itself reading the file
+func (s *Server) queueBookID(requestPath string) {
+	x := s.bookIDs
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.pending[requestPath] || len(x.pending) >= maxQueuedBookIDs {
+		return
+	}
+	x.pending[requestPath] = true
+	if !x.working {
+		x.working = true
+		go s.fingerprintQueued()
+	}
+}
+
+// fingerprintQueued works through the queued books one at a time
+func (s *Server) fingerprintQueued() {
+	x := s.bookIDs
+	for {
+		x.mu.Lock()
+		next, ok := "", false
+		for requestPath := range x.pending {
+			next, ok = requestPath, true
+			break
+		}
+		if !ok {
+			x.working = false
+			x.mu.Unlock()
+			return
+		}
+		delete(x.pending, next)
+		x.mu.Unlock()
+
+		if resolved, err := s.resolvePath(next); err == nil {
+			s.bookID(resolved)
+		}
+	}
+}
+
+// findBookByID returns the current request path of a book ID. When the recorded
+// location is stale, books of the recorded size are fingerprinted to find it again.
+func (s *Server) findBookByID(id string) (string, bool) {
+	s.bookIDs.mu.Lock()
+	record, ok := s.bookIDs.IDs[id]
+	var recorded bookIDRecord
+	if ok {
+		recorded = *record
+	}
+	s.bookIDs.mu.Unlock()
+	if !ok {
+		return "", false
+	}
+
+	if resolved, err := s.resolvePath(recorded.Path); err == nil {
+		if current, err := s.bookID(resolved); err == nil && current == id {
+			return recorded.Path, true
+		}
+	}
+
+	found := ""
+	s.walkLibrary(func(item fileItem, fullPath string) error {
+		if item.Type != "book" || item.Size != recorded.Size {
+			return nil
+		}
+		resolved, err := s.resolvePath(item.Path)
+		if err != nil {
+			return nil
+		}
+		if current, err := s.bookID(resolved); err == nil && current == id {
+			found = item.Path
+			return errStopWalk
+		}
+		return nil
+	})
+	return found, found != ""
+}
+
+// handleBookByID resolves a stable book ID to the book's current listing entry
+func (s *Server) handleBookByID(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	path, ok := s.findBookByID(id)
+	if !ok || !s.requestAccess(r).canSeePath(path) {
+		respondError(w, "book not found", http.StatusNotFound)
+		return
+	}
+	item, err := s.fileItemFor(path)
+	if err != nil {
+		respondError(w, "book not found", http.StatusNotFound)
+		return
+	}
+	respondJSON(w, item)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"archive/zip"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBookIDSurvivesMoveAndExternalRename(t *testing.T) {
+	t.Setenv("DATA_DIR", t.TempDir())
+	root := t.TempDir()
+	writeTestZip(t, filepath.Join(root, "vol1.cbz"), zip.Store, map[string]string{"01.jpg": "page"}, []string{"01.jpg"})
+	writeTestZip(t, filepath.Join(root, "vol2.cbz"), zip.Store, map[string]string{"01.jpg": "other"}, []string{"01.jpg"})
+	if err := os.Mkdir(filepath.Join(root, "target"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	enabled := true
+	server := initServer(&Config{
+		Roots:               []RootConfig{{Path: root, Name: "Root"}},
+		AllowFileOperations: &enabled,
+	})
+
+	idFor := func(requestPath string) string {
+		t.Helper()
+		resolved, err := server.resolvePath(requestPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, err := server.bookID(resolved)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	id := idFor("Root/vol1.cbz")
+	if other := idFor("Root/vol2.cbz"); other == id {
+		t.Fatalf("different books share ID %s", id)
+	}
+
+	requestTransfer(t, server, "Root/vol1.cbz", "Root/target", "move", http.StatusOK)
+	moved := filepath.Join("Root", "target", "vol1.cbz")
+	if path, ok := server.findBookByID(id); !ok || path != moved {
+		t.Fatalf("after move = %q, %v; want %q", path, ok, moved)
+	}
+
+	// Renamed outside the server: the index is stale and the library is rescanned
+	if err := os.Rename(filepath.Join(root, "target", "vol1.cbz"), filepath.Join(root, "Volume 01.cbz")); err != nil {
+		t.Fatal(err)
+	}
+	renamed := filepath.Join("Root", "Volume 01.cbz")
+	if path, ok := server.findBookByID(id); !ok || path != renamed {
+		t.Fatalf("after external rename = %q, %v; want %q", path, ok, renamed)
+	}
+	if got := idFor("Root/Volume 01.cbz"); got != id {
+		t.Fatalf("ID changed to %s, want %s", got, id)
+	}
+}
+
+func TestBookIDCopiesAndDeferredFlush(t *testing.T) {
+	dataDir := t.TempDir()
+	t.Setenv("DATA_DIR", dataDir)
+	root := t.TempDir()
+	pages := map[string]string{"01.jpg": "page"}
+	writeTestZip(t, filepath.Join(root, "original.cbz"), zip.Store, pages, []string{"01.jpg"})
+	writeTestZip(t, filepath.Join(root, "copy.cbz"), zip.Store, pages, []string{"01.jpg"})
+	server := initServer(&Config{Roots: []RootConfig{{Path: root, Name: "Root"}}})
+
+	idFor := func(requestPath string) string {
+		t.Helper()
+		resolved, err := server.resolvePath(requestPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, err := server.bookID(resolved)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	original, copied := idFor("Root/original.cbz"), idFor("Root/copy.cbz")
+	if original == copied {
+		t.Fatalf("identical copies share ID %s", original)
+	}
+	for requestPath, id := range map[string]string{"Root/original.cbz": original, "Root/copy.cbz": copied} {
+		if path, ok := server.findBookByID(id); !ok || path != requestPath {
+			t.Fatalf("ID of %s found at %q, %v", requestPath, path, ok)
+		}
+	}
+
+	indexPath := filepath.Join(dataDir, "bookids.json")
+	if _, err := os.Stat(indexPath); !os.IsNotExist(err) {
+		t.Fatalf("index written before the flush delay: %v", err)
+	}
+	server.flush()
+	if reloaded := loadBookIDIndex(indexPath); len(reloaded.IDs) != 2 {
+		t.Fatalf("flushed index has %d IDs, want 2", len(reloaded.IDs))
+	}
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	maxBookmarkNoteLength = 1000
+	// bookmarkThumbnailWidth is the width of the page previews linked from bookmarks
+	bookmarkThumbnailWidth = 300
+)
+
+// Bookmark is a marked page of a book
+type Bookmark struct {
+	ID      string    `json:"id"`
+	Page    int       `json:"page"` // Page index, starting at 0
+	Note    string    `json:"note,omitempty"`
+	Created time.Time `json:"created"`
+}
+
+// bookBookmarks are the bookmarks of one user in one book with the last known location of the book
+type bookBookmarks struct {
+	Path      string      `json:"path"`
+	Bookmarks []*Bookmark `json:"bookmarks"`
+}
+
+// BookmarkStore persists bookmarks per user and book ID in the data directory.
+// Without accounts every client shares the "" user.
+type BookmarkStore struct {
+	mu    sync.Mutex
+	path  string
+	Users map[string]map[string]*bookBookmarks `json:"users"` // user -> book ID -> bookmarks
+}
+
+func loadBookmarkStore(path string) *BookmarkStore {
+	store := &BookmarkStore{path: path, Users: make(map[string]map[string]*bookBookmarks)}
+	if err := readJSONFile(path, store); err != nil {
+		logStoreLoadError("bookmarks", err)
+	}
+	if store.Users == nil {
+		store.Users = make(map[string]map[string]*bookBookmarks)
+	}
+	return store
+}
+
+// save writes the store; callers must hold mu
+func (b *BookmarkStore) save() error {
+	return writeJSONFile(b.path, b)
+}
+
+// list copies the bookmarks of a user in a book, ordered by page
+func (b *BookmarkStore) list(user, id string) []Bookmark {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	result := make([]Bookmark, 0)
+	if book, ok := b.Users[user][id]; ok {
+		for _, bookmark := range book.Bookmarks {
+			result = append(result, *bookmark)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Page < result[j].Page })
+	return result
+}
+
+// find returns a bookmark of a user in a book; callers must hold mu
+func (b *BookmarkStore) find(user, id, bookmarkID string) (*bookBookmarks, int) {
+	book, ok := b.Users[user][id]
+	if !ok {
+		return nil, -1
+	}
+	for i, bookmark := range book.Bookmarks {
+		if bookmark.ID == bookmarkID {
+			return book, i
+		}
+	}
+	return book, -1
+}
+
+// merge adds bookmarks to a user's books (book ID -> path and bookmarks) with one
+// write, skipping those already there with the same ID or the same page and note.
+// It returns how many were added.
+func (b *BookmarkStore) merge(user string, imported map[string]bookBookmarks) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	books, ok := b.Users[user]
+	if !ok {
+		books = make(map[string]*bookBookmarks)
+		b.Users[user] = books
+	}
+	added := 0
+	for id, source := range imported {
+		book, ok := books[id]
+		if !ok {
+			book = &bookBookmarks{}
+		}
+		bookAdded := 0
+		for _, bookmark := range source.Bookmarks {
+			duplicate := false
+			for _, existing := range book.Bookmarks {
+				if existing.ID == bookmark.ID || (existing.Page == bookmark.Page && existing.Note == bookmark.Note) {
+					duplicate = true
+					break
+				}
+			}
+			if !duplicate {
+				copied := *bookmark
+				book.Bookmarks = append(book.Bookmarks, &copied)
+				bookAdded++
+			}
+		}
+		if bookAdded > 0 {
+			book.Path = source.Path
+			books[id] = book
+			added += bookAdded
+		}
+	}
+	if added == 0 {
+		return 0, nil
+	}
+	return added, b.save()
+}
+
+// removeUser deletes all bookmarks of a deleted account
+func (b *BookmarkStore) removeUser(user string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.Users[user]; !ok {
+		return
+	}
+	delete(b.Users, user)
+	if err := b.save(); err != nil {
+		logStoreError("bookmarks", err)
+	}
+}
+
+// movePath keeps the recorded locations of books current after a rename or move
+func (b *BookmarkStore) movePath(oldPath, newPath string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	changed := false
+	for _, books := range b.Users {
+		for _, book := range books {
+			if moved, ok := movedPath(book.Path, oldPath, newPath); ok {
+				book.Path, changed = moved, true
+			}
+		}
+	}
+	if changed {
+		if err := b.save(); err != nil {
+			logStoreError("bookmarks", err)
+		}
+	}
+}
+
+// bookmarkRequest is the body of a bookmark creation or change
+type bookmarkRequest struct {
+	Page *int    `json:"page"`
+	Note *string `json:"note"`
+}
+
+// validate checks the page against the page count of the book and the note length
+func (req *bookmarkRequest) validate(pages int) string {
+	if req.Page != nil && (*req.Page < 0 || *req.Page >= pages) {
+		return fmt.Sprintf("Page must be between 0 and %d", pages-1)
+	}
+	if req.Note != nil && len([]rune(*req.Note)) > maxBookmarkNoteLength {
+		return fmt.Sprintf("Note must be at most %d characters", maxBookmarkNoteLength)
+	}
+	return ""
+}
+
+// bookmarkThumbnail returns the URL of a resized preview of a bookmarked page
+func bookmarkThumbnail(bookPath string, page int) string {
+	return fmt.Sprintf("/api/book/%s/image/%d?width=%d", url.PathEscape(filepath.ToSlash(bookPath)), page, bookmarkThumbnailWidth)
+}
+
+// bookmarkInfo is a bookmark with the link to its page preview
+type bookmarkInfo struct {
+	Bookmark
+	Thumbnail string `json:"thumbnail"`
+}
+
+// handleBookBookmarks lists (GET) or adds (POST) the requester's bookmarks in a book
+func (s *Server) handleBookBookmarks(w http.ResponseWriter, r *http.Request) {
+	requestPath, _ := url.PathUnescape(mux.Vars(r)["path"])
+	resolved, err := s.resolveRequestPath(r, requestPath)
+	if err != nil {
+		respondError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	id, err := s.bookID(resolved)
+	if err != nil {
+		respondError(w, "file not found", http.StatusNotFound)
+		return
+	}
+	user := requestUserName(r)
+	bookPath := resolved.RequestPath()
+
+	if r.Method == "GET" {
+		bookmarks := make([]bookmarkInfo, 0)
+		for _, bookmark := range s.bookmarks.list(user, id) {
+			bookmarks = append(bookmarks, bookmarkInfo{bookmark, bookmarkThumbnail(bookPath, bookmark.Page)})
+		}
+		respondJSON(w, struct {
+			Bookmarks []bookmarkInfo `json:"bookmarks"`
+		}{bookmarks})
+		return
+	}
+
+	var req bookmarkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Page == nil {
+		respondError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if message := req.validate(s.bookPageCount(resolved)); message != "" {
+		respondError(w, message, http.StatusBadRequest)
+		return
+	}
+	bookmark := &Bookmark{ID: newID(), Page: *req.Page, Created: time.Now()}
+	if req.Note != nil {
+		bookmark.Note = strings.TrimSpace(*req.Note)
+	}
+
+	store := s.bookmarks
+	store.mu.Lock()
+	books, ok := store.Users[user]
+	if !ok {
+		books = make(map[string]*bookBookmarks)
+		store.Users[user] = books
+	}
+	book, ok := books[id]
+	if !ok {
+		book = &bookBookmarks{}
+		books[id] = book
+	}
+	book.Path = bookPath
+	book.Bookmarks = append(book.Bookmarks, bookmark)
+	err = store.save()
+	result := bookmarkInfo{*bookmark, bookmarkThumbnail(bookPath, bookmark.Page)}
+	store.mu.Unlock()
+	if err != nil {
+		respondError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, result)
+}
+
+// handleBookBookmark changes the page or note (PUT) or deletes (DELETE) a bookmark
+func (s *Server) handleBookBookmark(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	requestPath, _ := url.PathUnescape(vars["path"])
+	resolved, err := s.resolveRequestPath(r, requestPath)
+	if err != nil {
+		respondError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	id, err := s.bookID(resolved)
+	if err != nil {
+		respondError(w, "file not found", http.StatusNotFound)
+		return
+	}
+	user := requestUserName(r)
+
+	var req bookmarkRequest
+	if r.Method == "PUT" {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if message := req.validate(s.bookPageCount(resolved)); message != "" {
+			respondError(w, message, http.StatusBadRequest)
+			return
+		}
+	}
+
+	store := s.bookmarks
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	book, index := store.find(user, id, vars["id"])
+	if index < 0 {
+		respondError(w, "Bookmark not found", http.StatusNotFound)
+		return
+	}
+
+	if r.Method == "DELETE" {
+		book.Bookmarks = append(book.Bookmarks[:index], book.Bookmarks[index+1:]...)
+		if len(book.Bookmarks) == 0 {
+			delete(store.Users[user], id)
+		}
+		if err := store.save(); err != nil {
+			respondError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		respondJSON(w, map[string]string{"status": "ok"})
+		return
+	}
+
+	bookmark := book.Bookmarks[index]
+	if req.Page != nil {
+		bookmark.Page = *req.Page
+	}
+	if req.Note != nil {
+		bookmark.Note = strings.TrimSpace(*req.Note)
+	}
+	if err := store.save(); err != nil {
+		respondError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, bookmarkInfo{*bookmark, bookmarkThumbnail(book.Path, bookmark.Page)})
+}
+
+// libraryBookmark is a bookmark in the library-wide list
+type libraryBookmark struct {
+	bookmarkInfo
+	BookID string `json:"bookId"`
+	Path   string `json:"path"`
+}
+
+// handleBookmarks lists the requester's bookmarks in every visible book, newest first
+func (s *Server) handleBookmarks(w http.ResponseWriter, r *http.Request) {
+	access := s.requestAccess(r)
+	user := requestUserName(r)
+
+	store := s.bookmarks
+	store.mu.Lock()
+	bookmarks := make([]libraryBookmark, 0)
+	for id, book := range store.Users[user] {
+		if !access.canSeePath(book.Path) {
+			continue
+		}
+		for _, bookmark := range book.Bookmarks {
+			info := bookmarkInfo{*bookmark, bookmarkThumbnail(book.Path, bookmark.Page)}
+			bookmarks = append(bookmarks, libraryBookmark{info, id, book.Path})
+		}
+	}
+	store.mu.Unlock()
+	sort.Slice(bookmarks, func(i, j int) bool { return bookmarks[i].Created.After(bookmarks[j].Created) })
+	respondJSON(w, struct {
+		Bookmarks []libraryBookmark `json:"bookmarks"`
+	}{bookmarks})
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+func testPNG(t *testing.T, width, height int) string {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 128, 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestBookmarks(t *testing.T) {
+	root := t.TempDir()
+	writeTestZip(t, filepath.Join(root, "vol1.cbz"), zip.Store,
+		map[string]string{"01.png": testPNG(t, 600, 400), "02.png": testPNG(t, 600, 800)}, []string{"01.png", "02.png"})
+	server := newAuthTestServerWithConfig(t, &Config{Roots: []RootConfig{{Path: root, Name: "Root"}}})
+
+	response := authRequest(t, server, "POST", "/api/book/Root/vol1.cbz/bookmarks", map[string]interface{}{"page": 1, "note": " spread "})
+	var created bookmarkInfo
+	if err := json.Unmarshal(response.Body.Bytes(), &created); err != nil || response.Code != http.StatusOK || created.Note != "spread" {
+		t.Fatalf("create = %d; body = %s", response.Code, response.Body.String())
+	}
+	if response := authRequest(t, server, "POST", "/api/book/Root/vol1.cbz/bookmarks", map[string]interface{}{"page": 2}); response.Code != http.StatusBadRequest {
+		t.Fatalf("page out of range = %d", response.Code)
+	}
+
+	response = authRequest(t, server, "PUT", "/api/book/Root/vol1.cbz/bookmarks/"+created.ID, map[string]interface{}{"note": "favourite"})
+	if response.Code != http.StatusOK {
+		t.Fatalf("update = %d; body = %s", response.Code, response.Body.String())
+	}
+
+	var library struct {
+		Bookmarks []libraryBookmark `json:"bookmarks"`
+	}
+	response = authRequest(t, server, "GET", "/api/bookmarks", nil)
+	if err := json.Unmarshal(response.Body.Bytes(), &library); err != nil || len(library.Bookmarks) != 1 ||
+		library.Bookmarks[0].Note != "favourite" || library.Bookmarks[0].Path != filepath.Join("Root", "vol1.cbz") {
+		t.Fatalf("library bookmarks = %s", response.Body.String())
+	}
+
+	// The thumbnail is the bookmarked page scaled down
+	response = authRequest(t, server, "GET", library.Bookmarks[0].Thumbnail, nil)
+	if response.Code != http.StatusOK || response.Header().Get("Content-Type") != "image/jpeg" {
+		t.Fatalf("thumbnail = %d %s", response.Code, response.Header().Get("Content-Type"))
+	}
+	thumbnail, err := jpeg.Decode(response.Body)
+	if err != nil || thumbnail.Bounds().Dx() != bookmarkThumbnailWidth || thumbnail.Bounds().Dy() != 400 {
+		t.Fatalf("thumbnail bounds = %v, %v", thumbnail, err)
+	}
+	if response := authRequest(t, server, "GET", "/api/book/Root/vol1.cbz/image/0?width=0", nil); response.Code != http.StatusBadRequest {
+		t.Fatalf("width 0 = %d", response.Code)
+	}
+
+	if response := authRequest(t, server, "DELETE", "/api/book/Root/vol1.cbz/bookmarks/"+created.ID, nil); response.Code != http.StatusOK {
+		t.Fatalf("delete = %d", response.Code)
+	}
+	var book struct {
+		Bookmarks []bookmarkInfo `json:"bookmarks"`
+	}
+	response = authRequest(t, server, "GET", "/api/book/Root/vol1.cbz/bookmarks", nil)
+	if err := json.Unmarshal(response.Body.Bytes(), &book); err != nil || len(book.Bookmarks) != 0 {
+		t.Fatalf("after delete = %s", response.Body.String())
+	}
+}
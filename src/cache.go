@@ -21,6 +21,13 @@ type ImageListEntry struct {
 	LastAccess int64
 }
 
+// ComicInfoEntry represents cached ComicInfo metadata for one book
+type ComicInfoEntry struct {
+	Info       *ComicInfo
+	ModTime    time.Time
+	LastAccess int64
+}
+
 // ThumbnailCache manages thumbnail caching
 type ThumbnailCache struct {
 	dir      string
@@ -36,6 +43,13 @@ type ImageListCache struct {
 	maxSize int
 }
 
+// ComicInfoCache manages ComicInfo.xml caching, keyed by book path and modification time
+type ComicInfoCache struct {
+	cache   map[string]*ComicInfoEntry
+	mu      sync.Mutex
+	maxSize int
+}
+
 // ThumbnailCache methods
 func (c *ThumbnailCache) loadExisting() {
 	c.mu.Lock()
@@ -165,3 +179,45 @@ func (c *ImageListCache) cleanup() {
 		delete(c.cache, e.key)
 	}
 }
+
+// ComicInfoCache methods
+func (c *ComicInfoCache) Get(path string, modTime time.Time) (*ComicInfo, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.cache[path]
+	if !ok || !entry.ModTime.Equal(modTime) {
+		return nil, false
+	}
+
+	entry.LastAccess = time.Now().UnixMilli()
+	return entry.Info, true
+}
+
+func (c *ComicInfoCache) Set(path string, modTime time.Time, info *ComicInfo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.cache[path] = &ComicInfoEntry{
+		Info:       info,
+		ModTime:    modTime,
+		LastAccess: time.Now().UnixMilli(),
+	}
+
+	if len(c.cache) <= c.maxSize {
+		return
+	}
+	type kv struct {
+		key  string
+		time int64
+	}
+	entries := make([]kv, 0, len(c.cache))
+	for k, v := range c.cache {
+		entries = append(entries, kv{k, v.LastAccess})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].time < entries[j].time })
+
+	for _, e := range entries[:len(entries)-c.maxSize] {
+		delete(c.cache, e.key)
+	}
+}
@@ -0,0 +1,460 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/maruel/natural"
+)
+
+// Collection is a user-defined group of books that may span roots
+type Collection struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	Books       []string  `json:"books"`
+	Created     time.Time `json:"created"`
+	Updated     time.Time `json:"updated"`
+}
+
+// CollectionStore persists collections and book tags in the data directory
+type CollectionStore struct {
+	mu          sync.Mutex
+	path        string
+	Collections map[string]*Collection `json:"collections"`
+	Tags        map[string][]string    `json:"tags"` // book path -> tags
+}
+
+func loadCollectionStore(path string) *CollectionStore {
+	store := &CollectionStore{
+		path:        path,
+		Collections: make(map[string]*Collection),
+		Tags:        make(map[string][]string),
+	}
+	if err := readJSONFile(path, store); err != nil {
+		logStoreLoadError("collections", err)
+	}
+	if store.Collections == nil {
+		store.Collections = make(map[string]*Collection)
+	}
+	if store.Tags == nil {
+		store.Tags = make(map[string][]string)
+	}
+	return store
+}
+
+// snapshot copies a collection so it can be encoded after the store lock is released
+func (c *Collection) snapshot() Collection {
+	result := *c
+	result.Books = append(make([]string, 0, len(c.Books)), c.Books...)
+	return result
+}
+
+// save writes the store; callers must hold mu
+func (c *CollectionStore) save() error {
+	return writeJSONFile(c.path, c)
+}
+
+// movePath rewrites collection entries and tags after a rename or move
+func (c *CollectionStore) movePath(oldPath, newPath string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	changed := false
+	for _, collection := range c.Collections {
+		for i, book := range collection.Books {
+			if moved, ok := movedPath(book, oldPath, newPath); ok {
+				collection.Books[i], changed = moved, true
+			}
+		}
+	}
+	for book, tags := range c.Tags {
+		if moved, ok := movedPath(book, oldPath, newPath); ok {
+			delete(c.Tags, book)
+			c.Tags[moved], changed = tags, true
+		}
+	}
+	if changed {
+		if err := c.save(); err != nil {
+			logStoreError("collections", err)
+		}
+	}
+}
+
+// normalizeTags trims, deduplicates and sorts free-form tags
+func normalizeTags(tags []string) []string {
+	seen := make(map[string]bool)
+	result := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.Join(strings.Fields(tag), " ")
+		if tag == "" || seen[strings.ToLower(tag)] {
+			continue
+		}
+		seen[strings.ToLower(tag)] = true
+		result = append(result, tag)
+	}
+	sort.Slice(result, func(i, j int) bool { return natural.Less(result[i], result[j]) })
+	return result
+}
+
+// canonicalBookPath validates a request path and returns its canonical form
+func (s *Server) canonicalBookPath(r *http.Request, requestPath string) (string, error) {
+	resolved, err := s.resolveRequestPath(r, requestPath)
+	if err != nil {
+		return "", err
+	}
+	return resolved.RequestPath(), nil
+}
+
+// checkCollections answers 403 unless the requester may change collections and tags
+func (s *Server) checkCollections(w http.ResponseWriter, r *http.Request) bool {
+	if !s.requestAccess(r).Collections {
+		respondError(w, "You do not have permission to edit collections", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// collectionItems returns fileItems for stored paths, plus the paths that no longer exist.
+// Paths in roots the requester cannot see are left out of both.
+func (s *Server) collectionItems(r *http.Request, paths []string) ([]fileItem, []string) {
+	access := s.requestAccess(r)
+	items := make([]fileItem, 0, len(paths))
+	missing := make([]string, 0)
+	for _, p := range paths {
+		if !access.canSeePath(p) {
+			continue
+		}
+		item, err := s.fileItemFor(p)
+		if err != nil {
+			missing = append(missing, p)
+			continue
+		}
+		items = append(items, *item)
+	}
+	return items, missing
+}
+
+// handleCollections lists (GET) or creates (POST) collections. Counts only
+// include books the requester can see.
+func (s *Server) handleCollections(w http.ResponseWriter, r *http.Request) {
+	store := s.collections
+
+	if r.Method == "GET" {
+		type summary struct {
+			ID          string    `json:"id"`
+			Name        string    `json:"name"`
+			Description string    `json:"description,omitempty"`
+			Count       int       `json:"count"`
+			Updated     time.Time `json:"updated"`
+		}
+		access := s.requestAccess(r)
+		store.mu.Lock()
+		list := make([]summary, 0, len(store.Collections))
+		for _, c := range store.Collections {
+			count := 0
+			for _, book := range c.Books {
+				if access.canSeePath(book) {
+					count++
+				}
+			}
+			list = append(list, summary{c.ID, c.Name, c.Description, count, c.Updated})
+		}
+		store.mu.Unlock()
+		sort.Slice(list, func(i, j int) bool { return natural.Less(list[i].Name, list[j].Name) })
+		respondJSON(w, struct {
+			Collections []summary `json:"collections"`
+		}{list})
+		return
+	}
+
+	if !s.checkCollections(w, r) {
+		return
+	}
+	var req struct {
+		Name        string   `json:"name"`
+		Description string   `json:"description"`
+		Books       []string `json:"books"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		respondError(w, "Name is required", http.StatusBadRequest)
+		return
+	}
+	books := make([]string, 0, len(req.Books))
+	for _, book := range req.Books {
+		canonical, err := s.canonicalBookPath(r, book)
+		if err != nil {
+			respondError(w, "Invalid book path: "+book, http.StatusBadRequest)
+			return
+		}
+		books = append(books, canonical)
+	}
+
+	now := time.Now()
+	collection := &Collection{
+		ID: newID(), Name: req.Name, Description: req.Description,
+		Books: books, Created: now, Updated: now,
+	}
+	store.mu.Lock()
+	store.Collections[collection.ID] = collection
+	err := store.save()
+	result := collection.snapshot()
+	store.mu.Unlock()
+	if err != nil {
+		respondError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, result)
+}
+
+// handleCollection returns (GET), updates (PUT) or deletes (DELETE) one collection
+func (s *Server) handleCollection(w http.ResponseWriter, r *http.Request) {
+	store := s.collections
+	id := mux.Vars(r)["id"]
+
+	if r.Method != "GET" && !s.checkCollections(w, r) {
+		return
+	}
+	var req struct {
+		Name        *string `json:"name"`
+		Description *string `json:"description"`
+	}
+	if r.Method == "PUT" {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	store.mu.Lock()
+	collection, ok := store.Collections[id]
+	if !ok {
+		store.mu.Unlock()
+		respondError(w, "Collection not found", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		result := collection.snapshot()
+		store.mu.Unlock()
+
+		items, missing := s.collectionItems(r, result.Books)
+		respondJSON(w, struct {
+			Collection
+			Books   []fileItem `json:"books"`
+			Missing []string   `json:"missing"`
+		}{result, items, missing})
+		return
+
+	case "PUT":
+		if req.Name != nil {
+			if name := strings.TrimSpace(*req.Name); name != "" {
+				collection.Name = name
+			}
+		}
+		if req.Description != nil {
+			collection.Description = *req.Description
+		}
+		collection.Updated = time.Now()
+
+	case "DELETE":
+		delete(store.Collections, id)
+		err := store.save()
+		store.mu.Unlock()
+		if err != nil {
+			respondError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		respondJSON(w, struct {
+			Success bool `json:"success"`
+		}{true})
+		return
+	}
+
+	err := store.save()
+	result := collection.snapshot()
+	store.mu.Unlock()
+	if err != nil {
+		respondError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, result)
+}
+
+// handleCollectionBooks adds and removes books of a collection
+func (s *Server) handleCollectionBooks(w http.ResponseWriter, r *http.Request) {
+	store := s.collections
+	id := mux.Vars(r)["id"]
+	if !s.checkCollections(w, r) {
+		return
+	}
+
+	var req struct {
+		Add    []string `json:"add"`
+		Remove []string `json:"remove"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	add := make([]string, 0, len(req.Add))
+	for _, book := range req.Add {
+		canonical, err := s.canonicalBookPath(r, book)
+		if err != nil {
+			respondError(w, "Invalid book path: "+book, http.StatusBadRequest)
+			return
+		}
+		add = append(add, canonical)
+	}
+	remove := make(map[string]bool, len(req.Remove))
+	for _, book := range req.Remove {
+		if canonical, err := s.canonicalBookPath(r, book); err == nil {
+			remove[canonical] = true
+		}
+		remove[book] = true
+	}
+
+	store.mu.Lock()
+	collection, ok := store.Collections[id]
+	if !ok {
+		store.mu.Unlock()
+		respondError(w, "Collection not found", http.StatusNotFound)
+		return
+	}
+	books := collection.Books[:0]
+	present := make(map[string]bool)
+	for _, book := range collection.Books {
+		if !remove[book] {
+			books = append(books, book)
+			present[book] = true
+		}
+	}
+	for _, book := range add {
+		if !present[book] {
+			books = append(books, book)
+			present[book] = true
+		}
+	}
+	collection.Books = books
+	collection.Updated = time.Now()
+	err := store.save()
+	result := collection.snapshot()
+	store.mu.Unlock()
+	if err != nil {
+		respondError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, result)
+}
+
+// handleBookTags returns (GET) or replaces (POST) the tags of a book
+func (s *Server) handleBookTags(w http.ResponseWriter, r *http.Request) {
+	requestPath, _ := url.PathUnescape(mux.Vars(r)["path"])
+	book, err := s.canonicalBookPath(r, requestPath)
+	if err != nil {
+		respondError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	store := s.collections
+
+	if r.Method == "POST" {
+		if !s.checkCollections(w, r) {
+			return
+		}
+		var req struct {
+			Tags []string `json:"tags"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		tags := normalizeTags(req.Tags)
+		store.mu.Lock()
+		if len(tags) == 0 {
+			delete(store.Tags, book)
+		} else {
+			store.Tags[book] = tags
+		}
+		err := store.save()
+		store.mu.Unlock()
+		if err != nil {
+			respondError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	store.mu.Lock()
+	tags := append(make([]string, 0), store.Tags[book]...)
+	store.mu.Unlock()
+	respondJSON(w, struct {
+		Path string   `json:"path"`
+		Tags []string `json:"tags"`
+	}{book, tags})
+}
+
+// handleTags lists all tags with their book counts
+func (s *Server) handleTags(w http.ResponseWriter, r *http.Request) {
+	type tagCount struct {
+		Name  string `json:"name"`
+		Count int    `json:"count"`
+	}
+	access := s.requestAccess(r)
+	counts := make(map[string]int)
+	store := s.collections
+	store.mu.Lock()
+	for book, tags := range store.Tags {
+		if !access.canSeePath(book) {
+			continue
+		}
+		for _, tag := range tags {
+			counts[tag]++
+		}
+	}
+	store.mu.Unlock()
+
+	list := make([]tagCount, 0, len(counts))
+	for name, count := range counts {
+		list = append(list, tagCount{name, count})
+	}
+	sort.Slice(list, func(i, j int) bool { return natural.Less(list[i].Name, list[j].Name) })
+	respondJSON(w, struct {
+		Tags []tagCount `json:"tags"`
+	}{list})
+}
+
+// handleTagBooks lists the books carrying a tag (case-insensitive)
+func (s *Server) handleTagBooks(w http.ResponseWriter, r *http.Request) {
+	tag, _ := url.PathUnescape(mux.Vars(r)["tag"])
+	store := s.collections
+	var paths []string
+	store.mu.Lock()
+	for book, tags := range store.Tags {
+		for _, t := range tags {
+			if strings.EqualFold(t, tag) {
+				paths = append(paths, book)
+				break
+			}
+		}
+	}
+	store.mu.Unlock()
+	sort.Slice(paths, func(i, j int) bool { return natural.Less(paths[i], paths[j]) })
+
+	items, missing := s.collectionItems(r, paths)
+	respondJSON(w, struct {
+		Tag     string     `json:"tag"`
+		Books   []fileItem `json:"books"`
+		Missing []string   `json:"missing"`
+	}{tag, items, missing})
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestCollectionsFollowRenamesAndMoves(t *testing.T) {
+	t.Setenv("DATA_DIR", t.TempDir())
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "shelf"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.cbz", "b.cbz"} {
+		if err := os.WriteFile(filepath.Join(root, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	enabled := true
+	server := initServer(&Config{
+		Roots:               []RootConfig{{Path: root, Name: "Root"}},
+		AllowFileOperations: &enabled,
+	})
+
+	response := httptest.NewRecorder()
+	server.handleCollections(response, httptest.NewRequest(http.MethodPost, "/api/collections",
+		bytes.NewBufferString(`{"name":"Favourites","books":["Root/a.cbz"]}`)))
+	if response.Code != http.StatusOK {
+		t.Fatalf("create status = %d; body = %s", response.Code, response.Body.String())
+	}
+	var created Collection
+	if err := json.Unmarshal(response.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+
+	request := mux.SetURLVars(httptest.NewRequest(http.MethodPost, "/api/collections/"+created.ID+"/books",
+		bytes.NewBufferString(`{"add":["Root/b.cbz","Root/a.cbz"]}`)), map[string]string{"id": created.ID})
+	response = httptest.NewRecorder()
+	server.handleCollectionBooks(response, request)
+	if response.Code != http.StatusOK {
+		t.Fatalf("add status = %d; body = %s", response.Code, response.Body.String())
+	}
+
+	request = mux.SetURLVars(httptest.NewRequest(http.MethodPost, "/api/book/Root/a.cbz/tags",
+		bytes.NewBufferString(`{"tags":["SF"," sf ","Classic"]}`)), map[string]string{"path": "Root/a.cbz"})
+	response = httptest.NewRecorder()
+	server.handleBookTags(response, request)
+	if response.Code != http.StatusOK {
+		t.Fatalf("tag status = %d; body = %s", response.Code, response.Body.String())
+	}
+
+	response = httptest.NewRecorder()
+	server.handleRename(response, httptest.NewRequest(http.MethodPost, "/api/command/rename",
+		bytes.NewBufferString(`{"path":"Root/a.cbz","newName":"renamed.cbz"}`)))
+	if response.Code != http.StatusOK {
+		t.Fatalf("rename status = %d; body = %s", response.Code, response.Body.String())
+	}
+	requestTransfer(t, server, "Root/b.cbz", "Root/shelf", "move", http.StatusOK)
+
+	// Reload from disk to check the persisted state
+	server = initServer(server.config)
+	want := []string{filepath.Join("Root", "renamed.cbz"), filepath.Join("Root", "shelf", "b.cbz")}
+	books := server.collections.Collections[created.ID].Books
+	if len(books) != 2 || books[0] != want[0] || books[1] != want[1] {
+		t.Fatalf("collection books = %v, want %v", books, want)
+	}
+	if tags := server.collections.Tags[want[0]]; len(tags) != 2 || tags[0] != "Classic" || tags[1] != "SF" {
+		t.Fatalf("tags = %v", tags)
+	}
+
+	request = mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/api/collections/"+created.ID, nil), map[string]string{"id": created.ID})
+	response = httptest.NewRecorder()
+	server.handleCollection(response, request)
+	var listed struct {
+		Books   []fileItem `json:"books"`
+		Missing []string   `json:"missing"`
+	}
+	if err := json.Unmarshal(response.Body.Bytes(), &listed); err != nil {
+		t.Fatal(err)
+	}
+	if len(listed.Books) != 2 || len(listed.Missing) != 0 || listed.Books[0].Type != "book" {
+		t.Fatalf("listed collection = %+v", listed)
+	}
+}
+
+func TestCollectionsRequireCapability(t *testing.T) {
+	comics, adult := t.TempDir(), t.TempDir()
+	for _, path := range []string{filepath.Join(comics, "kids.cbz"), filepath.Join(adult, "secret.cbz")} {
+		if err := os.WriteFile(path, []byte("book"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	server := newAuthTestServerWithConfig(t, &Config{
+		Roots:  []RootConfig{{Path: comics, Name: "Comics"}, {Path: adult, Name: "Adult"}},
+		Groups: map[string]AccessRule{"kids": {Roots: []string{"Comics"}}, "curators": {Collections: true}},
+	})
+	admin := sessionCookie(t, authRequest(t, server, "POST", "/api/settings/users", map[string]interface{}{"name": "parent", "password": "parentpass"}))
+	authRequest(t, server, "POST", "/api/settings/users", map[string]interface{}{"name": "kid", "password": "kidpass1", "groups": []string{"kids"}}, admin)
+	authRequest(t, server, "POST", "/api/settings/users", map[string]interface{}{"name": "curator", "password": "curatorpass", "groups": []string{"kids", "curators"}}, admin)
+	kid := sessionCookie(t, authRequest(t, server, "POST", "/api/auth/login", map[string]string{"name": "kid", "password": "kidpass1"}))
+	curator := sessionCookie(t, authRequest(t, server, "POST", "/api/auth/login", map[string]string{"name": "curator", "password": "curatorpass"}))
+
+	response := authRequest(t, server, "POST", "/api/collections", map[string]interface{}{"name": "Mixed", "books": []string{"Comics/kids.cbz", "Adult/secret.cbz"}}, admin)
+	var created Collection
+	if err := json.Unmarshal(response.Body.Bytes(), &created); err != nil || response.Code != http.StatusOK {
+		t.Fatalf("admin create = %d; body = %s", response.Code, response.Body.String())
+	}
+	for _, request := range []struct{ method, target string }{
+		{"POST", "/api/collections"},
+		{"PUT", "/api/collections/" + created.ID},
+		{"DELETE", "/api/collections/" + created.ID},
+		{"POST", "/api/collections/" + created.ID + "/books"},
+		{"POST", "/api/book/Comics/kids.cbz/tags"},
+	} {
+		if response := authRequest(t, server, request.method, request.target, map[string]interface{}{"name": "x"}, kid); response.Code != http.StatusForbidden {
+			t.Fatalf("kid %s %s = %d, want 403", request.method, request.target, response.Code)
+		}
+	}
+	if response := authRequest(t, server, "POST", "/api/book/Comics/kids.cbz/tags", map[string]interface{}{"tags": []string{"fun"}}, curator); response.Code != http.StatusOK {
+		t.Fatalf("curator tags = %d; body = %s", response.Code, response.Body.String())
+	}
+
+	// Counts leave out books in roots the requester cannot see
+	var list struct {
+		Collections []struct {
+			Count int `json:"count"`
+		} `json:"collections"`
+	}
+	response = authRequest(t, server, "GET", "/api/collections", nil, kid)
+	if err := json.Unmarshal(response.Body.Bytes(), &list); err != nil || len(list.Collections) != 1 || list.Collections[0].Count != 1 {
+		t.Fatalf("kid collections = %s", response.Body.String())
+	}
+}
+
+func TestCorruptStoreIsMovedAside(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "collections.json")
+	if err := os.WriteFile(path, []byte(`{"collections": {`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	store := loadCollectionStore(path)
+	store.mu.Lock()
+	err := store.save()
+	store.mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(path + ".corrupt"); err != nil || string(data) != `{"collections": {` {
+		t.Fatalf("corrupt copy = %q, %v", data, err)
+	}
+}
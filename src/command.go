@@ -13,8 +13,7 @@ import (
 
 // handleTransfer copies or moves a file or directory into another configured directory.
 func (s *Server) handleTransfer(w http.ResponseWriter, r *http.Request) {
-	if s.config.AllowFileOperations == nil || !*s.config.AllowFileOperations {
-		respondError(w, "File transfer is disabled", http.StatusForbidden)
+	if !s.checkFileOperations(w, r, "File transfer is disabled") {
 		return
 	}
 	s.transferMutex.Lock()
@@ -29,17 +28,18 @@ func (s *Server) handleTransfer(w http.ResponseWriter, r *http.Request) {
 		respondError(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
+	auditPaths(r, req.Source, req.Destination)
 	if req.Source == "" || req.Destination == "" || (req.Operation != "copy" && req.Operation != "move") {
 		respondError(w, "Source, destination, and a valid operation are required", http.StatusBadRequest)
 		return
 	}
 
-	source, err := s.resolveRequestPath(req.Source)
+	source, err := s.resolveRequestPath(r, req.Source)
 	if err != nil {
 		respondError(w, "Invalid source path", http.StatusBadRequest)
 		return
 	}
-	destination, err := s.resolveRequestPath(req.Destination)
+	destination, err := s.resolveRequestPath(r, req.Destination)
 	if err != nil {
 		respondError(w, "Invalid destination path", http.StatusBadRequest)
 		return
@@ -116,11 +116,17 @@ func (s *Server) handleTransfer(w http.ResponseWriter, r *http.Request) {
 	if destination.RelativePath != "" {
 		targetRelative = filepath.Join(destination.RelativePath, targetRelative)
 	}
+	newPath := filepath.Join(destination.RootName, targetRelative)
+	if req.Operation == "move" {
+		s.onPathMoved(source.RequestPath(), newPath)
+	} else {
+		s.onLibraryChanged()
+	}
 	respondJSON(w, struct {
 		Success   bool   `json:"success"`
 		Operation string `json:"operation"`
 		NewPath   string `json:"newPath"`
-	}{true, req.Operation, filepath.Join(destination.RootName, targetRelative)})
+	}{true, req.Operation, newPath})
 }
 
 func samePath(a, b string) bool {
@@ -215,13 +221,14 @@ func (s *Server) decodePathRequest(w http.ResponseWriter, r *http.Request) (*Res
 		respondError(w, "Invalid request body", http.StatusBadRequest)
 		return nil, false
 	}
+	auditPaths(r, req.Path)
 
 	if req.Path == "" {
 		respondError(w, "Path is required", http.StatusBadRequest)
 		return nil, false
 	}
 
-	resolved, err := s.resolveRequestPath(req.Path)
+	resolved, err := s.resolveRequestPath(r, req.Path)
 	if err != nil {
 		respondError(w, err.Error(), http.StatusBadRequest)
 		return nil, false
@@ -272,8 +279,7 @@ func validateItemName(name string) string {
 
 // handleMkdir creates a directory inside the requested configured directory.
 func (s *Server) handleMkdir(w http.ResponseWriter, r *http.Request) {
-	if s.config.AllowFileOperations == nil || !*s.config.AllowFileOperations {
-		respondError(w, "Folder creation is disabled", http.StatusForbidden)
+	if !s.checkFileOperations(w, r, "Folder creation is disabled") {
 		return
 	}
 
@@ -285,6 +291,7 @@ func (s *Server) handleMkdir(w http.ResponseWriter, r *http.Request) {
 		respondError(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
+	auditPaths(r, filepath.Join(req.Path, req.Name))
 	if req.Path == "" {
 		respondError(w, "Path is required", http.StatusBadRequest)
 		return
@@ -294,7 +301,7 @@ func (s *Server) handleMkdir(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	destination, err := s.resolveRequestPath(req.Path)
+	destination, err := s.resolveRequestPath(r, req.Path)
 	if err != nil {
 		respondError(w, "Invalid destination path", http.StatusBadRequest)
 		return
@@ -337,8 +344,7 @@ func (s *Server) handleMkdir(w http.ResponseWriter, r *http.Request) {
 // handleRename handles POST requests for /api/rename
 // Renames files or directories
 func (s *Server) handleRename(w http.ResponseWriter, r *http.Request) {
-	if s.config.AllowFileOperations == nil || !*s.config.AllowFileOperations {
-		respondError(w, "File renaming is disabled", http.StatusForbidden)
+	if !s.checkFileOperations(w, r, "File renaming is disabled") {
 		return
 	}
 
@@ -351,6 +357,7 @@ func (s *Server) handleRename(w http.ResponseWriter, r *http.Request) {
 		respondError(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
+	auditPaths(r, req.Path, filepath.Join(filepath.Dir(req.Path), req.NewName))
 
 	if req.Path == "" {
 		respondError(w, "Path is required", http.StatusBadRequest)
@@ -361,7 +368,7 @@ func (s *Server) handleRename(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	resolved, err := s.resolveRequestPath(req.Path)
+	resolved, err := s.resolveRequestPath(r, req.Path)
 	if err != nil {
 		respondError(w, err.Error(), http.StatusBadRequest)
 		return
@@ -400,6 +407,8 @@ func (s *Server) handleRename(w http.ResponseWriter, r *http.Request) {
 		newRelativePath = req.NewName
 	}
 
+	s.onPathMoved(resolved.RequestPath(), filepath.Join(resolved.RootName, newRelativePath))
+
 	respondJSON(w, struct {
 		Success         bool   `json:"success"`
 		NewName         string `json:"newName"`
@@ -414,10 +423,9 @@ func (s *Server) handleRename(w http.ResponseWriter, r *http.Request) {
 }
 
 // handleRemove handles POST requests for /api/remove
-// Deletes files or directories based on the AllowFileOperations config setting
+// Deletes files or directories when file operations are allowed for the requester
 func (s *Server) handleRemove(w http.ResponseWriter, r *http.Request) {
-	if s.config.AllowFileOperations == nil || !*s.config.AllowFileOperations {
-		respondError(w, "File deletion is disabled", http.StatusForbidden)
+	if !s.checkFileOperations(w, r, "File deletion is disabled") {
 		return
 	}
 
@@ -443,6 +451,7 @@ func (s *Server) handleRemove(w http.ResponseWriter, r *http.Request) {
 		respondError(w, fmt.Sprintf("Failed to delete: %v", err), http.StatusInternalServerError)
 		return
 	}
+	s.onLibraryChanged()
 
 	respondJSON(w, struct {
 		Success      bool   `json:"success"`
@@ -458,8 +467,7 @@ func (s *Server) handleRemove(w http.ResponseWriter, r *http.Request) {
 // handleArchive handles POST requests for /api/archive
 // Creates a ZIP archive of the specified directory
 func (s *Server) handleArchive(w http.ResponseWriter, r *http.Request) {
-	if s.config.AllowFileOperations == nil || !*s.config.AllowFileOperations {
-		respondError(w, "Folder archiving is disabled", http.StatusForbidden)
+	if !s.checkFileOperations(w, r, "Folder archiving is disabled") {
 		return
 	}
 
@@ -492,10 +500,16 @@ func (s *Server) handleArchive(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Create the archive
-	if err := createZipArchive(resolved.FullPath, zipPath); err != nil {
+	matcher := s.ignoreMatcherFor(resolved.RootName)
+	ignored := func(path string, info os.FileInfo) bool {
+		relativePath, err := filepath.Rel(resolved.RootPath, path)
+		return err == nil && matcher.ignored(relativePath, info.IsDir())
+	}
+	if err := createZipArchive(resolved.FullPath, zipPath, ignored); err != nil {
 		respondError(w, fmt.Sprintf("Failed to create archive: %v", err), http.StatusInternalServerError)
 		return
 	}
+	s.onLibraryChanged()
 
 	respondJSON(w, struct {
 		Success     bool   `json:"success"`
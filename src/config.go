@@ -27,6 +27,15 @@ type Config struct {
 	AllowUpload         *bool                               `json:"allowUpload,omitempty"`         // Allow browser uploads
 	DefaultLTR          *bool                               `json:"defaultLTR,omitempty"`          // Default to left-to-right reading mode (instead of right-to-left)
 	TLS                 *TLSConfig                          `json:"tls,omitempty"`                 // TLS/HTTPS configuration
+	Auth                *AuthConfig                         `json:"auth,omitempty"`                // Login session settings
+	Groups              map[string]AccessRule               `json:"groups,omitempty"`              // Root visibility and capabilities shared by users
+	DefaultGroups       []string                            `json:"defaultGroups,omitempty"`       // Groups of users that have no groups or rule of their own
+	TrustedProxies      []string                            `json:"trustedProxies,omitempty"`      // CIDRs of reverse proxies whose forwarded headers are honoured
+	IPAccess            *IPAccessConfig                     `json:"ipAccess,omitempty"`            // Client address allow/deny lists per route group
+	CORS                *CORSConfig                         `json:"cors,omitempty"`                // Cross-origin access for external web clients
+	AllowedHosts        []string                            `json:"allowedHosts,omitempty"`        // Host names the server may be reached under besides addresses and local names
+	Audit               *AuditConfig                        `json:"audit,omitempty"`               // Audit log rotation
+	RateLimit           *RateLimitConfig                    `json:"rateLimit,omitempty"`           // Per-client request limits and login lockout
 	Handlers            map[string]map[string]HandlerConfig `json:"handlers,omitempty"`
 }
 
@@ -38,9 +47,11 @@ type TLSConfig struct {
 
 // RootConfig represents a root directory configuration
 type RootConfig struct {
-	Path           string `json:"path"`
-	Name           string `json:"name,omitempty"`
-	UploadDisabled bool   `json:"uploadDisabled,omitempty"`
+	Path           string   `json:"path"`
+	Name           string   `json:"name,omitempty"`
+	UploadDisabled bool     `json:"uploadDisabled,omitempty"`
+	Ignore         []string `json:"ignore,omitempty"`       // Extra gitignore-style patterns hidden from listings, scans and archives
+	HideDotFiles   bool     `json:"hideDotFiles,omitempty"` // Hide files and folders whose names start with a dot
 }
 
 // HandlerConfig represents external player handler configuration
@@ -187,6 +198,40 @@ func parseArgsAndLoadConfig() *Config {
 	return loadConfig()
 }
 
+// changedSecurityField returns the name of the first admin-only setting that
+// differs between two configs, or "" when they match
+func changedSecurityField(current, updated *Config) string {
+	fields := []struct {
+		name             string
+		current, updated interface{}
+	}{
+		{"groups", current.Groups, updated.Groups},
+		{"defaultGroups", current.DefaultGroups, updated.DefaultGroups},
+		{"auth", current.Auth, updated.Auth},
+		{"trustedProxies", current.TrustedProxies, updated.TrustedProxies},
+		{"ipAccess", current.IPAccess, updated.IPAccess},
+		{"cors", current.CORS, updated.CORS},
+		{"allowedHosts", current.AllowedHosts, updated.AllowedHosts},
+		{"roots", current.Roots, updated.Roots},
+		{"rateLimit", current.RateLimit, updated.RateLimit},
+		{"tls", current.TLS, updated.TLS},
+	}
+	// Empty lists and objects are the same as omitted ones
+	normalize := func(value interface{}) string {
+		data, _ := json.Marshal(value)
+		if text := string(data); text != "[]" && text != "{}" {
+			return text
+		}
+		return "null"
+	}
+	for _, field := range fields {
+		if normalize(field.current) != normalize(field.updated) {
+			return field.name
+		}
+	}
+	return ""
+}
+
 // handleConfig handles GET and POST requests for /api/config
 func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
@@ -224,6 +269,7 @@ func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
 
 	case "POST":
 		// Save new config
+		auditPaths(r, configPath)
 		var newConfig Config
 		if err := json.NewDecoder(r.Body).Decode(&newConfig); err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
@@ -269,6 +315,14 @@ func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
 		currentConfig := loadConfig()
 		newConfig.Handlers = currentConfig.Handlers
 
+		// Users with the settings capability must not be able to raise their own access
+		if !s.isAdminRequest(r) {
+			if field := changedSecurityField(currentConfig, &newConfig); field != "" {
+				http.Error(w, fmt.Sprintf("Only administrators can change %s", field), http.StatusForbidden)
+				return
+			}
+		}
+
 		if err := saveConfig(&newConfig); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/maruel/natural"
+)
+
+// DuplicateItem is one book in a duplicate group
+type DuplicateItem struct {
+	Path     string    `json:"path"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+// DuplicateGroup is a set of books with identical content.
+// Kind is "file" for byte-identical archives and "pages" for the same pages in different containers.
+type DuplicateGroup struct {
+	Kind        string          `json:"kind"`
+	Hash        string          `json:"hash"`
+	Items       []DuplicateItem `json:"items"`
+	Reclaimable int64           `json:"reclaimable"` // bytes freed by keeping only the largest copy
+}
+
+// pageHashEntry caches the page-list hash of a book by size and modification time
+type pageHashEntry struct {
+	size    int64
+	modTime time.Time
+	hash    string
+}
+
+// DuplicateScanner runs duplicate scans in the background and keeps the last result
+type DuplicateScanner struct {
+	mu         sync.Mutex
+	running    bool
+	started    time.Time
+	finished   time.Time
+	scanned    int
+	total      int
+	lastError  string
+	groups     []DuplicateGroup
+	pageHashes map[string]*pageHashEntry
+}
+
+// duplicateStatus is the API view of the scanner
+type duplicateStatus struct {
+	Running  bool             `json:"running"`
+	Started  *time.Time       `json:"started,omitempty"`
+	Finished *time.Time       `json:"finished,omitempty"`
+	Scanned  int              `json:"scanned"`
+	Total    int              `json:"total"`
+	Error    string           `json:"error,omitempty"`
+	Groups   []DuplicateGroup `json:"groups"`
+}
+
+func (d *DuplicateScanner) status() duplicateStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	status := duplicateStatus{
+		Running: d.running, Scanned: d.scanned, Total: d.total,
+		Error: d.lastError, Groups: d.groups,
+	}
+	if status.Groups == nil {
+		status.Groups = []DuplicateGroup{}
+	}
+	if !d.started.IsZero() {
+		started := d.started
+		status.Started = &started
+	}
+	if !d.finished.IsZero() {
+		finished := d.finished
+		status.Finished = &finished
+	}
+	return status
+}
+
+// hashFile returns the SHA-256 of a file's content
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// hashBookPages hashes the decoded page images of a book in reading order,
+// so the same pages packed in a different container produce the same hash.
+// Books without pages return "".
+func hashBookPages(bookPath string) (string, error) {
+	pages := make(map[string][sha256.Size]byte)
+	err := walkBookEntries(bookPath, func(name string, r io.Reader) error {
+		if isMacOSMetaFile(name) || !isImageFile(name) {
+			return nil
+		}
+		hash := sha256.New()
+		if _, err := io.Copy(hash, r); err != nil {
+			return err
+		}
+		var sum [sha256.Size]byte
+		copy(sum[:], hash.Sum(nil))
+		pages[name] = sum
+		return nil
+	})
+	if err != nil || len(pages) == 0 {
+		return "", err
+	}
+
+	names := make([]string, 0, len(pages))
+	for name := range pages {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return natural.Less(names[i], names[j]) })
+	combined := sha256.New()
+	for _, name := range names {
+		sum := pages[name]
+		combined.Write(sum[:])
+	}
+	return hex.EncodeToString(combined.Sum(nil)), nil
+}
+
+// startDuplicateScan starts a background scan unless one is already running
+func (s *Server) startDuplicateScan() bool {
+	d := s.duplicates
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.running {
+		return false
+	}
+	d.running, d.started, d.finished = true, time.Now(), time.Time{}
+	d.scanned, d.total, d.lastError = 0, 0, ""
+	go s.runDuplicateScan()
+	return true
+}
+
+func (s *Server) runDuplicateScan() {
+	d := s.duplicates
+	groups, err := s.findDuplicates()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.running, d.finished = false, time.Now()
+	if err != nil {
+		log.Printf("Duplicate scan failed: %v", err)
+		d.lastError = err.Error()
+		return
+	}
+	d.groups = groups
+}
+
+// findDuplicates compares archive content hashes, then page-list hashes
+func (s *Server) findDuplicates() ([]DuplicateGroup, error) {
+	d := s.duplicates
+	type book struct {
+		item     DuplicateItem
+		fullPath string
+	}
+	var books []book
+	err := s.walkLibrary(func(item fileItem, fullPath string) error {
+		if item.Type == "book" {
+			books = append(books, book{DuplicateItem{item.Path, item.Size, item.Modified}, fullPath})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	d.total = len(books)
+	// Forget the page hashes of books that left the library
+	inLibrary := make(map[string]bool, len(books))
+	for i := range books {
+		inLibrary[books[i].fullPath] = true
+	}
+	for fullPath := range d.pageHashes {
+		if !inLibrary[fullPath] {
+			delete(d.pageHashes, fullPath)
+		}
+	}
+	d.mu.Unlock()
+
+	// Byte-identical archives: only files sharing a size can match, so hash just those
+	bySize := make(map[int64][]int)
+	for i := range books {
+		bySize[books[i].item.Size] = append(bySize[books[i].item.Size], i)
+	}
+	fileGroups := make(map[string][]int)
+	for _, indexes := range bySize {
+		if len(indexes) < 2 {
+			continue
+		}
+		for _, i := range indexes {
+			if hash, err := hashFile(books[i].fullPath); err == nil {
+				fileGroups[hash] = append(fileGroups[hash], i)
+			}
+		}
+	}
+
+	// Same pages in a different container
+	pageGroups := make(map[string][]int)
+	for i := range books {
+		if hash := s.cachedPageHash(books[i].fullPath, books[i].item); hash != "" {
+			pageGroups[hash] = append(pageGroups[hash], i)
+		}
+		d.mu.Lock()
+		d.scanned = i + 1
+		d.mu.Unlock()
+	}
+
+	inFileGroup := make(map[int]string)
+	var groups []DuplicateGroup
+	collect := func(kind, hash string, indexes []int) {
+		group := DuplicateGroup{Kind: kind, Hash: hash}
+		var largest int64
+		for _, i := range indexes {
+			group.Items = append(group.Items, books[i].item)
+			group.Reclaimable += books[i].item.Size
+			if books[i].item.Size > largest {
+				largest = books[i].item.Size
+			}
+		}
+		group.Reclaimable -= largest
+		sort.Slice(group.Items, func(a, b int) bool { return natural.Less(group.Items[a].Path, group.Items[b].Path) })
+		groups = append(groups, group)
+	}
+	for hash, indexes := range fileGroups {
+		if len(indexes) < 2 {
+			continue
+		}
+		for _, i := range indexes {
+			inFileGroup[i] = hash
+		}
+		collect("file", hash, indexes)
+	}
+	for hash, indexes := range pageGroups {
+		if len(indexes) < 2 {
+			continue
+		}
+		// Skip page groups that are entirely one byte-identical group
+		distinct := make(map[string]bool)
+		for _, i := range indexes {
+			if fileHash, ok := inFileGroup[i]; ok {
+				distinct[fileHash] = true
+			} else {
+				distinct[books[i].item.Path] = true
+			}
+		}
+		if len(distinct) > 1 {
+			collect("pages", hash, indexes)
+		}
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Reclaimable != groups[j].Reclaimable {
+			return groups[i].Reclaimable > groups[j].Reclaimable
+		}
+		return natural.Less(groups[i].Items[0].Path, groups[j].Items[0].Path)
+	})
+	return groups, nil
+}
+
+// cachedPageHash returns the page-list hash of a book, reusing the previous scan's
+// result while the file's size and modification time are unchanged
+func (s *Server) cachedPageHash(fullPath string, item DuplicateItem) string {
+	d := s.duplicates
+	d.mu.Lock()
+	entry, ok := d.pageHashes[fullPath]
+	d.mu.Unlock()
+	if ok && entry.size == item.Size && entry.modTime.Equal(item.Modified) {
+		return entry.hash
+	}
+
+	hash, err := hashBookPages(fullPath)
+	if err != nil {
+		return ""
+	}
+	d.mu.Lock()
+	d.pageHashes[fullPath] = &pageHashEntry{item.Size, item.Modified, hash}
+	d.mu.Unlock()
+	return hash
+}
+
+// handleDuplicates returns the state and result of the last duplicate scan
+func (s *Server) handleDuplicates(w http.ResponseWriter, r *http.Request) {
+	status := s.duplicates.status()
+	status.Groups = visibleDuplicateGroups(status.Groups, s.requestAccess(r))
+	respondJSON(w, status)
+}
+
+// visibleDuplicateGroups keeps the items the requester can see, dropping groups left without a duplicate
+func visibleDuplicateGroups(groups []DuplicateGroup, access *Access) []DuplicateGroup {
+	if access.roots == nil {
+		return groups
+	}
+	result := make([]DuplicateGroup, 0, len(groups))
+	for _, group := range groups {
+		items := make([]DuplicateItem, 0, len(group.Items))
+		for _, item := range group.Items {
+			if access.canSeePath(item.Path) {
+				items = append(items, item)
+			}
+		}
+		if len(items) > 1 {
+			var total, largest int64
+			for _, item := range items {
+				total += item.Size
+				if item.Size > largest {
+					largest = item.Size
+				}
+			}
+			group.Items, group.Reclaimable = items, total-largest
+			result = append(result, group)
+		}
+	}
+	return result
+}
+
+// handleDuplicateScan starts a duplicate scan in the background. Scans read every
+// book in the library, so they need the file operations or settings capability.
+func (s *Server) handleDuplicateScan(w http.ResponseWriter, r *http.Request) {
+	if access := s.requestAccess(r); !access.FileOperations && !access.Settings {
+		respondError(w, "You do not have permission to scan for duplicates", http.StatusForbidden)
+		return
+	}
+	if !s.startDuplicateScan() {
+		respondError(w, "A duplicate scan is already running", http.StatusConflict)
+		return
+	}
+	respondJSONStatus(w, s.duplicates.status(), http.StatusAccepted)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"archive/zip"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeTestZip(t *testing.T, path string, method uint16, pages map[string]string, order []string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	writer := zip.NewWriter(file)
+	for _, name := range order {
+		entry, err := writer.CreateHeader(&zip.FileHeader{Name: name, Method: method})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := entry.Write([]byte(pages[name])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFindDuplicatesByFileAndPages(t *testing.T) {
+	rootA, rootB := t.TempDir(), t.TempDir()
+	pages := map[string]string{"01.jpg": "first page", "02.jpg": "second page"}
+	writeTestZip(t, filepath.Join(rootA, "vol1.cbz"), zip.Store, pages, []string{"01.jpg", "02.jpg"})
+	writeTestZip(t, filepath.Join(rootA, "other.cbz"), zip.Store, map[string]string{"01.jpg": "different"}, []string{"01.jpg"})
+	// Same pages, different container layout and compression
+	writeTestZip(t, filepath.Join(rootB, "nested", "Volume 1.zip"), zip.Deflate, pages, []string{"02.jpg", "01.jpg"})
+	data, err := os.ReadFile(filepath.Join(rootA, "vol1.cbz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(rootB, "copy.cbz"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	server := initServer(&Config{Roots: []RootConfig{{Path: rootA, Name: "A"}, {Path: rootB, Name: "B"}}})
+	groups, err := server.findDuplicates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 2 {
+		t.Fatalf("groups = %+v, want one file group and one pages group", groups)
+	}
+
+	kinds := map[string]DuplicateGroup{}
+	for _, group := range groups {
+		kinds[group.Kind] = group
+	}
+	fileGroup, pagesGroup := kinds["file"], kinds["pages"]
+	if len(fileGroup.Items) != 2 || fileGroup.Items[0].Path != filepath.Join("A", "vol1.cbz") ||
+		fileGroup.Items[1].Path != filepath.Join("B", "copy.cbz") {
+		t.Fatalf("file group = %+v", fileGroup)
+	}
+	if fileGroup.Reclaimable != int64(len(data)) {
+		t.Fatalf("reclaimable = %d, want %d", fileGroup.Reclaimable, len(data))
+	}
+	if len(pagesGroup.Items) != 3 {
+		t.Fatalf("pages group = %+v", pagesGroup)
+	}
+
+	// Removed books leave the page hash cache on the next scan
+	if err := os.Remove(filepath.Join(rootA, "other.cbz")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.findDuplicates(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := server.duplicates.pageHashes[filepath.Join(rootA, "other.cbz")]; ok || len(server.duplicates.pageHashes) != 3 {
+		t.Fatalf("page hashes after removal = %v", server.duplicates.pageHashes)
+	}
+}
+
+func TestDuplicateScanRequiresCapability(t *testing.T) {
+	server := newAuthTestServerWithConfig(t, &Config{
+		Roots:  []RootConfig{{Path: t.TempDir(), Name: "Root"}},
+		Groups: map[string]AccessRule{"readers": {}, "staff": {Settings: true}},
+	})
+	admin := sessionCookie(t, authRequest(t, server, "POST", "/api/settings/users", map[string]interface{}{"name": "admin", "password": "adminpass"}))
+	authRequest(t, server, "POST", "/api/settings/users", map[string]interface{}{"name": "reader", "password": "readerpass", "groups": []string{"readers"}}, admin)
+	authRequest(t, server, "POST", "/api/settings/users", map[string]interface{}{"name": "staff", "password": "staffpass", "groups": []string{"staff"}}, admin)
+	reader := sessionCookie(t, authRequest(t, server, "POST", "/api/auth/login", map[string]string{"name": "reader", "password": "readerpass"}))
+	staff := sessionCookie(t, authRequest(t, server, "POST", "/api/auth/login", map[string]string{"name": "staff", "password": "staffpass"}))
+
+	if response := authRequest(t, server, "POST", "/api/duplicates/scan", nil, reader); response.Code != http.StatusForbidden {
+		t.Fatalf("reader scan = %d, want 403", response.Code)
+	}
+	response := authRequest(t, server, "POST", "/api/duplicates/scan", nil, staff)
+	if response.Code != http.StatusAccepted || response.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("staff scan = %d %s", response.Code, response.Header().Get("Content-Type"))
+	}
+	for deadline := time.Now().Add(5 * time.Second); server.duplicates.status().Running && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
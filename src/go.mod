@@ -10,7 +10,7 @@ require (
 	github.com/nwaples/rardecode/v2 v2.0.0-beta.4
 )
 
-require github.com/maruel/natural v1.2.1 // indirect
+require github.com/maruel/natural v1.2.1
 
 require (
 	github.com/andybalholm/brotli v1.1.0 // indirect
@@ -31,6 +31,7 @@ require (
 	github.com/pierrec/lz4/v4 v4.1.21 // indirect
 	github.com/ulikunitz/xz v0.5.12 // indirect
 	go4.org v0.0.0-20200411211856-f5505b9728dd // indirect
-	golang.org/x/sys v0.5.0 // indirect
+	golang.org/x/crypto v0.18.0
+	golang.org/x/sys v0.16.0 // indirect
 	golang.org/x/text v0.14.0
 )
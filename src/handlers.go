@@ -6,7 +6,6 @@ import (
 	"os"
 	"path/filepath"
 	"slices"
-	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -14,24 +13,118 @@ import (
 	"net/http"
 
 	"github.com/gorilla/mux"
-	"github.com/maruel/natural"
 )
 
-func (s *Server) handleDir(w http.ResponseWriter, r *http.Request) {
-	type fileItem struct {
-		Name     string    `json:"name"`
-		Path     string    `json:"path"`
-		Type     string    `json:"type"`
-		Size     int64     `json:"size"`
-		Modified time.Time `json:"modified"`
+// fileItem is a single directory listing entry
+type fileItem struct {
+	Name     string      `json:"name"`
+	Path     string      `json:"path"`
+	Type     string      `json:"type"`
+	Size     int64       `json:"size"`
+	Modified time.Time   `json:"modified"`
+	ID       string      `json:"id,omitempty"`
+	Series   string      `json:"series,omitempty"`
+	Number   *float64    `json:"number,omitempty"`
+	Progress *Progress   `json:"progress,omitempty"` // Reading progress of the requester (directory listings)
+	Books    *bookCounts `json:"books,omitempty"`    // Books below a directory and how many the requester read (directory listings)
+}
+
+// fileTypeOf classifies a directory entry the same way the file list UI does
+func fileTypeOf(name string, isDir bool) string {
+	switch {
+	case isDir:
+		return "directory"
+	case isArchiveFile(name):
+		return "book"
+	case isVideoFile(name):
+		return "video"
+	case isAudioFile(name):
+		return "audio"
+	}
+	return "file"
+}
+
+// readDirItems lists a resolved directory as fileItems in display order
+func (s *Server) readDirItems(resolved *ResolvedPath) ([]fileItem, error) {
+	entries, err := os.ReadDir(resolved.FullPath)
+	if err != nil {
+		return nil, err
+	}
+
+	matcher := s.ignoreMatcherFor(resolved.RootName)
+	files := make([]fileItem, 0, len(entries))
+	for _, entry := range entries {
+		itemRelativePath := entry.Name()
+		if resolved.RelativePath != "" {
+			itemRelativePath = filepath.Join(resolved.RelativePath, entry.Name())
+		}
+		if matcher.ignored(itemRelativePath, entry.IsDir()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		item := s.newFileItem(filepath.Join(resolved.RootName, itemRelativePath), info)
+		if item.Type == "book" && item.ID == "" {
+			// Fingerprinted in the background; later listings carry the ID
+			s.queueBookID(item.Path)
+		}
+		files = append(files, item)
 	}
 
+	sortFileItems(files)
+	return files, nil
+}
+
+// newFileItem builds the listing entry for an item at a request path.
+// Book IDs are included when already known; listings never fingerprint files.
+// The series comes from the file name; see applyComicInfoSeries for ComicInfo.xml.
+func (s *Server) newFileItem(itemPath string, info os.FileInfo) fileItem {
+	name := filepath.Base(itemPath)
+	item := fileItem{
+		Name: name, Path: itemPath, Type: fileTypeOf(name, info.IsDir()),
+		Size: info.Size(), Modified: info.ModTime(),
+	}
+	if item.Type == "book" {
+		item.ID = s.bookIDs.lookup(itemPath, info)
+		if series, number, ok := parseSeriesName(name); ok {
+			item.Series, item.Number = series, &number
+		}
+	}
+	return item
+}
+
+// fileItemFor builds the listing entry for a single request path
+func (s *Server) fileItemFor(requestPath string) (*fileItem, error) {
+	resolved, err := s.resolvePath(requestPath)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(resolved.FullPath)
+	if err != nil {
+		return nil, err
+	}
+	item := s.newFileItem(resolved.RequestPath(), info)
+	return &item, nil
+}
+
+// sortFileItems orders directories first, then by natural name
+func sortFileItems(files []fileItem) {
+	sortFileItemsBy(files, "name", false)
+}
+
+func (s *Server) handleDir(w http.ResponseWriter, r *http.Request) {
 	requestPath := mux.Vars(r)["path"]
 
 	// If path is empty, return roots list
 	if requestPath == "" {
+		access := s.requestAccess(r)
 		items := make([]fileItem, 0, len(s.config.Roots))
 		for i := range s.config.Roots {
+			if !access.canSeeRoot(s.config.Roots[i].Name) {
+				continue
+			}
 			if info, err := os.Stat(s.config.Roots[i].Path); err == nil {
 				items = append(items, fileItem{
 					Name: s.config.Roots[i].Name, Path: s.config.Roots[i].Name, Type: "directory",
@@ -39,21 +132,28 @@ func (s *Server) handleDir(w http.ResponseWriter, r *http.Request) {
 				})
 			}
 		}
-		respondJSON(w, struct {
+		s.attachBookCounts(r, items)
+		respondJSONWithETag(w, r, struct {
 			Files               []fileItem `json:"files"`
 			AllowFileOperations bool       `json:"allowFileOperations"`
 			AllowUpload         bool       `json:"allowUpload"`
 			DisableGUI          bool       `json:"disableGUI"`
+			AllowSettings       bool       `json:"allowSettings"`
+			AllowShare          bool       `json:"allowShare"`
+			User                string     `json:"user,omitempty"`
 		}{
 			Files:               items,
-			AllowFileOperations: s.config.AllowFileOperations != nil && *s.config.AllowFileOperations,
-			AllowUpload:         s.config.AllowUpload != nil && *s.config.AllowUpload,
+			AllowFileOperations: s.fileOperationsAllowed(r),
+			AllowUpload:         s.config.AllowUpload != nil && *s.config.AllowUpload && access.Upload,
 			DisableGUI:          s.config.DisableGUI != nil && *s.config.DisableGUI,
+			AllowSettings:       access.Settings,
+			AllowShare:          access.Share,
+			User:                requestUserName(r),
 		})
 		return
 	}
 
-	resolved, err := s.resolveRequestPath(requestPath)
+	resolved, err := s.resolveRequestPath(r, requestPath)
 	if err != nil {
 		respondError(w, err.Error(), http.StatusNotFound)
 		return
@@ -65,71 +165,51 @@ func (s *Server) handleDir(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	entries, err := os.ReadDir(resolved.FullPath)
+	opts, err := parseListOptions(r.URL.Query())
 	if err != nil {
-		respondError(w, err.Error(), http.StatusInternalServerError)
+		respondError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	files := make([]fileItem, 0, len(entries))
-	for _, entry := range entries {
-		if strings.HasPrefix(entry.Name(), ".litecomics-upload-") {
-			continue
-		}
-		info, _ := entry.Info()
-		itemRelativePath := entry.Name()
-		if resolved.RelativePath != "" {
-			itemRelativePath = filepath.Join(resolved.RelativePath, entry.Name())
-		}
-		itemPath := filepath.Join(resolved.RootName, itemRelativePath)
-
-		fileType := "file"
-		if entry.IsDir() {
-			fileType = "directory"
-		} else if isArchiveFile(entry.Name()) {
-			fileType = "book"
-		} else if isVideoFile(entry.Name()) {
-			fileType = "video"
-		} else if isAudioFile(entry.Name()) {
-			fileType = "audio"
-		}
-
-		files = append(files, fileItem{
-			Name: entry.Name(), Path: itemPath, Type: fileType,
-			Size: info.Size(), Modified: info.ModTime(),
-		})
+	files, err := s.readDirItems(resolved)
+	if err != nil {
+		respondError(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
+	files, total, nextCursor := opts.apply(files)
+	s.attachProgress(r, files)
+	s.attachBookCounts(r, files)
 
-	sort.Slice(files, func(i, j int) bool {
-		if files[i].Type == "directory" && files[j].Type != "directory" {
-			return true
-		}
-		if files[i].Type != "directory" && files[j].Type == "directory" {
-			return false
-		}
-		return natural.Less(files[i].Name, files[j].Name)
-	})
-
-	respondJSON(w, struct {
+	respondJSONWithETag(w, r, struct {
 		RootName            string     `json:"rootName"`
 		RelativePath        string     `json:"relativePath"`
 		Files               []fileItem `json:"files"`
+		Total               int        `json:"total"`
+		NextCursor          string     `json:"nextCursor,omitempty"`
 		AllowFileOperations bool       `json:"allowFileOperations"`
 		AllowUpload         bool       `json:"allowUpload"`
 		DisableGUI          bool       `json:"disableGUI"`
+		AllowSettings       bool       `json:"allowSettings"`
+		AllowShare          bool       `json:"allowShare"`
+		User                string     `json:"user,omitempty"`
 	}{
 		RootName:            resolved.RootName,
 		RelativePath:        resolved.RelativePath,
 		Files:               files,
-		AllowFileOperations: s.config.AllowFileOperations != nil && *s.config.AllowFileOperations,
-		AllowUpload:         s.isUploadAllowed(resolved.RootName),
+		Total:               total,
+		NextCursor:          nextCursor,
+		AllowFileOperations: s.fileOperationsAllowed(r),
+		AllowUpload:         s.isUploadAllowed(resolved.RootName) && s.requestAccess(r).Upload,
 		DisableGUI:          s.config.DisableGUI != nil && *s.config.DisableGUI,
+		AllowSettings:       s.requestAccess(r).Settings,
+		AllowShare:          s.requestAccess(r).Share,
+		User:                requestUserName(r),
 	})
 }
 
 func (s *Server) handleBookList(w http.ResponseWriter, r *http.Request) {
 	requestPath, _ := url.PathUnescape(mux.Vars(r)["path"])
-	resolved, err := s.resolveRequestPath(requestPath)
+	resolved, err := s.resolveRequestPath(r, requestPath)
 	if err != nil {
 		respondError(w, err.Error(), http.StatusNotFound)
 		return
@@ -148,17 +228,26 @@ func (s *Server) handleBookList(w http.ResponseWriter, r *http.Request) {
 
 	// Convert to UTF-8 display names for safe JSON transmission
 	displayNames := getDisplayNames(images)
+	id, _ := s.bookID(resolved)
+	var progress *Progress
+	if record, ok := s.progress.get(requestUserName(r), id); ok && id != "" {
+		progress = &record.Progress
+	}
 
 	respondJSON(w, struct {
-		Filename   string   `json:"filename"`
-		Images     []string `json:"images"`
-		Count      int      `json:"count"`
-		DefaultLTR bool     `json:"defaultLTR"`
+		ID         string    `json:"id,omitempty"`
+		Filename   string    `json:"filename"`
+		Images     []string  `json:"images"`
+		Count      int       `json:"count"`
+		DefaultLTR bool      `json:"defaultLTR"`
+		Progress   *Progress `json:"progress,omitempty"`
 	}{
+		ID:         id,
 		Filename:   filepath.Base(resolved.FullPath),
 		Images:     displayNames,
 		Count:      len(displayNames),
 		DefaultLTR: s.config.DefaultLTR != nil && *s.config.DefaultLTR,
+		Progress:   progress,
 	})
 }
 
@@ -166,8 +255,13 @@ func (s *Server) handleBookImage(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	requestPath, _ := url.PathUnescape(vars["path"])
 	index, _ := strconv.Atoi(vars["index"])
+	width, ok := parseImageWidth(r.URL.Query().Get("width"))
+	if !ok {
+		respondError(w, fmt.Sprintf("width must be between 1 and %d", maxImageWidth), http.StatusBadRequest)
+		return
+	}
 
-	resolved, err := s.resolveRequestPath(requestPath)
+	resolved, err := s.resolveRequestPath(r, requestPath)
 	if err != nil {
 		respondError(w, err.Error(), http.StatusNotFound)
 		return
@@ -185,26 +279,74 @@ func (s *Server) handleBookImage(w http.ResponseWriter, r *http.Request) {
 	}
 
 	imageName := images[index]
+	if width > 0 {
+		s.serveResizedPage(w, resolved, index, imageName, width)
+		return
+	}
 	data, err := s.extractFileFromBook(resolved.FullPath, imageName)
 	if err != nil {
 		respondError(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if prefetch, _ := strconv.ParseBool(r.URL.Query().Get("prefetch")); !prefetch {
+		s.recordPageView(r, resolved, index, len(images))
+	}
 
 	ext := strings.ToLower(filepath.Ext(imageName))
 	w.Header().Set("Content-Type", getMimeType(ext))
 	w.Write(data)
 }
 
+// serveResizedPage sends a page scaled down to width, cached like thumbnails.
+// Resized pages are previews, so they do not count as page views.
+func (s *Server) serveResizedPage(w http.ResponseWriter, resolved *ResolvedPath, index int, imageName string, width int) {
+	id, err := s.bookID(resolved)
+	if err != nil {
+		id = generateCacheKey(resolved.FullPath)
+	}
+	cacheKey := fmt.Sprintf("%s-p%d-w%d", id, index, width)
+
+	data, cacheHit := s.thumbnailCache.Get(cacheKey)
+	if !cacheHit {
+		original, err := s.extractFileFromBook(resolved.FullPath, imageName)
+		if err != nil {
+			respondError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		resized, ok := resizeImage(original, width)
+		if !ok {
+			// Already small enough or not decodable: serve the page as is
+			w.Header().Set("Content-Type", getMimeType(strings.ToLower(filepath.Ext(imageName))))
+			w.Write(original)
+			return
+		}
+		data = resized
+		s.thumbnailCache.Set(cacheKey, data)
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	if cacheHit {
+		w.Header().Set("X-Cache", "HIT")
+	} else {
+		w.Header().Set("X-Cache", "MISS")
+	}
+	w.Write(data)
+}
+
 func (s *Server) handleThumbnail(w http.ResponseWriter, r *http.Request) {
 	requestPath, _ := url.PathUnescape(mux.Vars(r)["path"])
-	resolved, err := s.resolveRequestPath(requestPath)
+	resolved, err := s.resolveRequestPath(r, requestPath)
 	if err != nil {
 		respondError(w, err.Error(), http.StatusNotFound)
 		return
 	}
 
-	cacheKey := generateCacheKey(resolved.FullPath)
+	// Key thumbnails by book ID so they survive renames and moves
+	cacheKey, err := s.bookID(resolved)
+	if err != nil {
+		cacheKey = generateCacheKey(resolved.FullPath)
+	}
 
 	// Get first image name for MIME type detection
 	images, err := s.getImagesFromBook(resolved.FullPath)
@@ -241,7 +383,7 @@ func (s *Server) handleThumbnail(w http.ResponseWriter, r *http.Request) {
 
 func (s *Server) handleFile(w http.ResponseWriter, r *http.Request) {
 	requestPath, _ := url.PathUnescape(mux.Vars(r)["path"])
-	resolved, err := s.resolveRequestPath(requestPath)
+	resolved, err := s.resolveRequestPath(r, requestPath)
 	if err != nil {
 		respondError(w, err.Error(), http.StatusNotFound)
 		return
@@ -266,7 +408,7 @@ func (s *Server) handleFile(w http.ResponseWriter, r *http.Request) {
 
 func (s *Server) handleMediaURL(w http.ResponseWriter, r *http.Request) {
 	requestPath, _ := url.PathUnescape(mux.Vars(r)["path"])
-	resolved, err := s.resolveRequestPath(requestPath)
+	resolved, err := s.resolveRequestPath(r, requestPath)
 	if err != nil {
 		respondError(w, err.Error(), http.StatusNotFound)
 		return
@@ -308,11 +450,14 @@ func (s *Server) handleMediaURL(w http.ResponseWriter, r *http.Request) {
 	}
 
 	filePath := "/api/file/" + url.PathEscape(requestPath)
-	scheme := "http"
-	if r.TLS != nil {
-		scheme = "https"
+	fullURL := fmt.Sprintf("%s://%s%s", s.requestScheme(r), s.requestHost(r), filePath)
+	// External players have no session cookie, so the URL carries its own credentials
+	if share := requestShare(r); share != nil {
+		fullURL += "?" + url.Values{shareQueryParameter: {shareToken(r)}}.Encode()
+	} else if user := requestUser(r); user != nil && s.users.enabled() {
+		token := s.shares.mediaToken(user.Name, requestPath, time.Now().Add(mediaURLTTL))
+		fullURL += "?" + url.Values{mediaQueryParameter: {token}}.Encode()
 	}
-	fullURL := fmt.Sprintf("%s://%s%s", scheme, r.Host, filePath)
 
 	if customURL != "" {
 		finalURL := strings.ReplaceAll(customURL, "{url}", url.QueryEscape(fullURL))
@@ -0,0 +1,460 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// historySessionGap is how long a book may rest before further reading starts a new session
+	historySessionGap = 15 * time.Minute
+	// historySaveInterval limits how often page views within a session are written to disk
+	historySaveInterval = time.Minute
+	// maxHistorySessions is how many sessions are kept per user; older ones are dropped
+	maxHistorySessions  = 10000
+	defaultHistoryLimit = 100
+	defaultStatsSeries  = 10
+)
+
+// readingSession is a stretch of reading in one book without a long pause
+type readingSession struct {
+	BookID    string    `json:"bookId"`
+	Path      string    `json:"path"`
+	Series    string    `json:"series,omitempty"`
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`                 // Time of the last page view
+	Pages     []int     `json:"pages"`               // Distinct pages viewed, in the order first viewed
+	Completed bool      `json:"completed,omitempty"` // The last page of the book was viewed
+}
+
+// seconds is the time spent in the session
+func (r *readingSession) seconds() int64 {
+	return int64(r.End.Sub(r.Start) / time.Second)
+}
+
+// HistoryStore persists the reading sessions of each user in the data directory,
+// oldest first. Without accounts every client shares the "" user.
+type HistoryStore struct {
+	mu       sync.Mutex
+	path     string
+	Users    map[string][]*readingSession `json:"users"`
+	lastSave time.Time
+	dirty    bool        // page views not yet written
+	timer    *time.Timer // pending write of page views
+}
+
+func loadHistoryStore(path string) *HistoryStore {
+	store := &HistoryStore{path: path, Users: make(map[string][]*readingSession)}
+	if err := readJSONFile(path, store); err != nil {
+		logStoreLoadError("history", err)
+	}
+	if store.Users == nil {
+		store.Users = make(map[string][]*readingSession)
+	}
+	return store
+}
+
+// save writes the store; callers must hold mu
+func (h *HistoryStore) save() error {
+	h.lastSave, h.dirty = time.Now(), false
+	return writeJSONFile(h.path, h)
+}
+
+// flush writes page views that were held back by historySaveInterval
+func (h *HistoryStore) flush() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.timer != nil {
+		h.timer.Stop()
+		h.timer = nil
+	}
+	if !h.dirty {
+		return
+	}
+	if err := h.save(); err != nil {
+		logStoreError("history", err)
+	}
+}
+
+// view adds a page view to the latest session of a user in a book, or starts a
+// session when the book was not read within historySessionGap. New sessions are
+// written at once; further views at most once per historySaveInterval, with
+// skipped writes made once the interval has passed.
+func (h *HistoryStore) view(user, id, path, series string, page, pages int, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	sessions := h.Users[user]
+	var session *readingSession
+	for i := len(sessions) - 1; i >= 0; i-- {
+		if sessions[i].BookID == id {
+			if now.Sub(sessions[i].End) <= historySessionGap {
+				session = sessions[i]
+			}
+			break
+		}
+	}
+
+	started := session == nil
+	if started {
+		session = &readingSession{BookID: id, Start: now, Pages: []int{}}
+		sessions = append(sessions, session)
+		if len(sessions) > maxHistorySessions {
+			sessions = sessions[len(sessions)-maxHistorySessions:]
+		}
+		h.Users[user] = sessions
+	}
+	session.Path, session.Series = path, series
+	if now.After(session.End) {
+		session.End = now
+	}
+	seen := false
+	for _, p := range session.Pages {
+		if p == page {
+			seen = true
+			break
+		}
+	}
+	if !seen {
+		session.Pages = append(session.Pages, page)
+	}
+	session.Completed = session.Completed || page == pages-1
+
+	if started || now.Sub(h.lastSave) >= historySaveInterval {
+		if err := h.save(); err != nil {
+			logStoreError("history", err)
+		}
+		return
+	}
+	// Written later, so the end of the last session is not lost when views stop
+	h.dirty = true
+	if h.timer == nil {
+		h.timer = time.AfterFunc(historySaveInterval, h.flush)
+	}
+}
+
+// merge adds sessions to a user's history, skipping those already there (same
+// book and start). It returns how many were added.
+func (h *HistoryStore) merge(user string, sessions []readingSession) (int, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	existing := h.Users[user]
+	known := make(map[string]bool, len(existing))
+	for _, session := range existing {
+		known[session.BookID+"@"+session.Start.UTC().Format(time.RFC3339Nano)] = true
+	}
+	added := 0
+	for _, session := range sessions {
+		key := session.BookID + "@" + session.Start.UTC().Format(time.RFC3339Nano)
+		if known[key] {
+			continue
+		}
+		known[key] = true
+		copied := session
+		existing = append(existing, &copied)
+		added++
+	}
+	if added == 0 {
+		return 0, nil
+	}
+	sort.SliceStable(existing, func(i, j int) bool { return existing[i].Start.Before(existing[j].Start) })
+	if len(existing) > maxHistorySessions {
+		existing = existing[len(existing)-maxHistorySessions:]
+	}
+	h.Users[user] = existing
+	return added, h.save()
+}
+
+// forUser copies the sessions of a user
+func (h *HistoryStore) forUser(user string) []readingSession {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	result := make([]readingSession, 0, len(h.Users[user]))
+	for _, session := range h.Users[user] {
+		copied := *session
+		copied.Pages = append([]int{}, session.Pages...)
+		result = append(result, copied)
+	}
+	return result
+}
+
+// removeUser deletes the history of a deleted account
+func (h *HistoryStore) removeUser(user string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.Users[user]; !ok {
+		return
+	}
+	delete(h.Users, user)
+	if err := h.save(); err != nil {
+		logStoreError("history", err)
+	}
+}
+
+// movePath keeps the recorded locations of books current after a rename or move
+func (h *HistoryStore) movePath(oldPath, newPath string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	changed := false
+	for _, sessions := range h.Users {
+		for _, session := range sessions {
+			if moved, ok := movedPath(session.Path, oldPath, newPath); ok {
+				session.Path, changed = moved, true
+			}
+		}
+	}
+	if changed {
+		if err := h.save(); err != nil {
+			logStoreError("history", err)
+		}
+	}
+}
+
+// recordHistory adds a served or reported page to the reading history of a user
+func (s *Server) recordHistory(user, id string, resolved *ResolvedPath, page, pages int, now time.Time) {
+	series, _, _ := s.seriesInfoFor(resolved.FullPath)
+	s.history.view(user, id, resolved.RequestPath(), series, page, pages, now)
+}
+
+// historyRange is the time range and output format of a history or stats request
+type historyRange struct {
+	since, until time.Time
+	csv          bool
+}
+
+// parseHistoryRange reads the since and until (RFC 3339) and format parameters.
+// On failure the response has been written.
+func parseHistoryRange(w http.ResponseWriter, r *http.Request) (historyRange, bool) {
+	query := r.URL.Query()
+	var q historyRange
+	for name, target := range map[string]*time.Time{"since": &q.since, "until": &q.until} {
+		if value := query.Get(name); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				respondError(w, fmt.Sprintf("%s must be an RFC 3339 time", name), http.StatusBadRequest)
+				return q, false
+			}
+			*target = t
+		}
+	}
+	switch query.Get("format") {
+	case "", "json":
+	case "csv":
+		q.csv = true
+	default:
+		respondError(w, "format must be json or csv", http.StatusBadRequest)
+		return q, false
+	}
+	return q, true
+}
+
+// visibleSessions returns the requester's sessions in visible books that started within the range
+func (s *Server) visibleSessions(r *http.Request, q historyRange) []readingSession {
+	access := s.requestAccess(r)
+	result := make([]readingSession, 0)
+	for _, session := range s.history.forUser(requestUserName(r)) {
+		if !q.since.IsZero() && session.Start.Before(q.since) {
+			continue
+		}
+		if !q.until.IsZero() && !session.Start.Before(q.until) {
+			continue
+		}
+		if access.canSeePath(session.Path) {
+			result = append(result, session)
+		}
+	}
+	return result
+}
+
+// respondCSV sends rows as a CSV download
+func respondCSV(w http.ResponseWriter, name string, rows [][]string) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	writer := csv.NewWriter(w)
+	writer.WriteAll(rows)
+}
+
+// historyItem is a session in the history list
+type historyItem struct {
+	readingSession
+	Seconds int64 `json:"seconds"`
+}
+
+// handleHistory lists the requester's reading sessions, newest first. Query
+// parameters: since and until (RFC 3339), limit and format (json or csv).
+func (s *Server) handleHistory(w http.ResponseWriter, r *http.Request) {
+	q, ok := parseHistoryRange(w, r)
+	if !ok {
+		return
+	}
+	limit := defaultHistoryLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			respondError(w, "limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxHistorySessions)
+	}
+
+	sessions := s.visibleSessions(r, q)
+	items := make([]historyItem, 0, min(limit, len(sessions)))
+	for i := len(sessions) - 1; i >= 0 && len(items) < limit; i-- {
+		items = append(items, historyItem{sessions[i], sessions[i].seconds()})
+	}
+
+	if q.csv {
+		rows := [][]string{{"start", "end", "seconds", "pages", "completed", "book_id", "path", "series"}}
+		for _, item := range items {
+			rows = append(rows, []string{
+				item.Start.Format(time.RFC3339), item.End.Format(time.RFC3339),
+				strconv.FormatInt(item.Seconds, 10), strconv.Itoa(len(item.Pages)), strconv.FormatBool(item.Completed),
+				item.BookID, filepath.ToSlash(item.Path), item.Series,
+			})
+		}
+		respondCSV(w, "reading-history.csv", rows)
+		return
+	}
+	respondJSON(w, struct {
+		Sessions []historyItem `json:"sessions"`
+	}{items})
+}
+
+// readingStats sums up reading sessions
+type readingStats struct {
+	Pages     int   `json:"pages"`     // Pages viewed
+	Books     int   `json:"books"`     // Distinct books opened
+	Completed int   `json:"completed"` // Distinct books read to the last page
+	Sessions  int   `json:"sessions"`
+	Seconds   int64 `json:"seconds"` // Time spent reading
+	books     map[string]bool
+	completed map[string]bool
+}
+
+func (st *readingStats) add(session *readingSession) {
+	if st.books == nil {
+		st.books, st.completed = make(map[string]bool), make(map[string]bool)
+	}
+	st.Pages += len(session.Pages)
+	st.Sessions++
+	st.Seconds += session.seconds()
+	st.books[session.BookID] = true
+	if session.Completed {
+		st.completed[session.BookID] = true
+	}
+	st.Books, st.Completed = len(st.books), len(st.completed)
+}
+
+// periodStats is the reading in one day or week
+type periodStats struct {
+	Start string `json:"start"` // First day of the period (YYYY-MM-DD)
+	readingStats
+}
+
+// seriesStats is the reading in one series
+type seriesStats struct {
+	Name string `json:"name"`
+	readingStats
+}
+
+// periodStart returns the day, or the Monday of the week, a time falls in
+func periodStart(t time.Time, weekly bool) time.Time {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	if weekly {
+		day = day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	}
+	return day
+}
+
+// handleStats sums up the requester's reading per day or week and per series.
+// Sessions count toward the period they started in, in server local time. Query
+// parameters: period (day or week), since and until (RFC 3339), series (how many
+// series to list) and format (json or csv; CSV has one row per period).
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	q, ok := parseHistoryRange(w, r)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	period := query.Get("period")
+	if period == "" {
+		period = "day"
+	}
+	if period != "day" && period != "week" {
+		respondError(w, "period must be day or week", http.StatusBadRequest)
+		return
+	}
+	seriesLimit := defaultStatsSeries
+	if value := query.Get("series"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			respondError(w, "series must be a number", http.StatusBadRequest)
+			return
+		}
+		seriesLimit = n
+	}
+
+	var total readingStats
+	periods := make(map[time.Time]*periodStats)
+	series := make(map[string]*seriesStats)
+	for _, session := range s.visibleSessions(r, q) {
+		total.add(&session)
+		start := periodStart(session.Start.Local(), period == "week")
+		stats, ok := periods[start]
+		if !ok {
+			stats = &periodStats{Start: start.Format("2006-01-02")}
+			periods[start] = stats
+		}
+		stats.add(&session)
+		if session.Series != "" {
+			key := seriesKey(session.Series)
+			stats, ok := series[key]
+			if !ok {
+				stats = &seriesStats{Name: session.Series}
+				series[key] = stats
+			}
+			stats.add(&session)
+		}
+	}
+
+	periodList := make([]periodStats, 0, len(periods))
+	for _, stats := range periods {
+		periodList = append(periodList, *stats)
+	}
+	sort.Slice(periodList, func(i, j int) bool { return periodList[i].Start < periodList[j].Start })
+	seriesList := make([]seriesStats, 0, len(series))
+	for _, stats := range series {
+		seriesList = append(seriesList, *stats)
+	}
+	sort.Slice(seriesList, func(i, j int) bool {
+		if seriesList[i].Pages != seriesList[j].Pages {
+			return seriesList[i].Pages > seriesList[j].Pages
+		}
+		return seriesList[i].Seconds > seriesList[j].Seconds
+	})
+	seriesList = seriesList[:min(seriesLimit, len(seriesList))]
+
+	if q.csv {
+		rows := [][]string{{period, "pages", "books", "completed", "sessions", "seconds"}}
+		for _, stats := range periodList {
+			rows = append(rows, []string{
+				stats.Start, strconv.Itoa(stats.Pages), strconv.Itoa(stats.Books), strconv.Itoa(stats.Completed),
+				strconv.Itoa(stats.Sessions), strconv.FormatInt(stats.Seconds, 10),
+			})
+		}
+		respondCSV(w, "reading-stats.csv", rows)
+		return
+	}
+	respondJSON(w, struct {
+		Period  string        `json:"period"`
+		Total   readingStats  `json:"total"`
+		Periods []periodStats `json:"periods"`
+		Series  []seriesStats `json:"series"`
+	}{period, total, periodList, seriesList})
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadingHistory(t *testing.T) {
+	root := t.TempDir()
+	order := []string{"01.jpg", "02.jpg", "03.jpg"}
+	for _, name := range []string{"Saga_v01.cbz", "Other.cbz"} {
+		pages := map[string]string{"01.jpg": name, "02.jpg": "b", "03.jpg": "c"}
+		writeTestZip(t, filepath.Join(root, name), zip.Store, pages, order)
+	}
+	server := newAuthTestServerWithConfig(t, &Config{Roots: []RootConfig{{Path: root, Name: "Root"}}})
+
+	// A session from the day before, and one that a long pause split from it
+	resolved, err := server.resolvePath(filepath.Join("Root", "Other.cbz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := server.bookID(resolved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	yesterday := periodStart(time.Now(), false).Add(-12 * time.Hour)
+	server.recordHistory("", id, resolved, 0, 3, yesterday)
+	server.recordHistory("", id, resolved, 1, 3, yesterday.Add(5*time.Minute))
+	server.recordHistory("", id, resolved, 1, 3, yesterday.Add(6*time.Minute))
+	server.recordHistory("", id, resolved, 2, 3, yesterday.Add(2*time.Hour))
+
+	// The viewer reads ahead; pages shown from its cache are reported as progress
+	for _, page := range []string{"0", "1", "1", "2?prefetch=1"} {
+		if response := authRequest(t, server, "GET", "/api/book/Root/Saga_v01.cbz/image/"+page, nil); response.Code != http.StatusOK {
+			t.Fatalf("image = %d", response.Code)
+		}
+	}
+	if sessions := server.history.forUser(""); len(sessions[len(sessions)-1].Pages) != 2 {
+		t.Fatalf("prefetch recorded: %+v", sessions[len(sessions)-1])
+	}
+	authRequest(t, server, "PUT", "/api/book/Root/Saga_v01.cbz/progress", map[string]interface{}{"page": 1, "offset": 1})
+
+	var history struct {
+		Sessions []historyItem `json:"sessions"`
+	}
+	response := authRequest(t, server, "GET", "/api/history", nil)
+	if err := json.Unmarshal(response.Body.Bytes(), &history); err != nil || len(history.Sessions) != 3 {
+		t.Fatalf("history = %s", response.Body.String())
+	}
+	if latest := history.Sessions[0]; latest.Series != "Saga" || len(latest.Pages) != 3 || !latest.Completed {
+		t.Fatalf("latest session = %+v", latest)
+	}
+	if first := history.Sessions[2]; len(first.Pages) != 2 || first.Seconds != 360 || first.Completed {
+		t.Fatalf("first session = %+v", first)
+	}
+
+	var stats struct {
+		Total   readingStats  `json:"total"`
+		Periods []periodStats `json:"periods"`
+		Series  []seriesStats `json:"series"`
+	}
+	response = authRequest(t, server, "GET", "/api/stats?period=day", nil)
+	if err := json.Unmarshal(response.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("stats = %s", response.Body.String())
+	}
+	if stats.Total.Pages != 6 || stats.Total.Books != 2 || stats.Total.Completed != 2 || stats.Total.Sessions != 3 || stats.Total.Seconds < 360 {
+		t.Fatalf("total = %+v", stats.Total)
+	}
+	if len(stats.Periods) != 2 || stats.Periods[0].Start != yesterday.Format("2006-01-02") || stats.Periods[0].Pages != 3 || stats.Periods[1].Books != 1 {
+		t.Fatalf("periods = %+v", stats.Periods)
+	}
+	if len(stats.Series) != 1 || stats.Series[0].Name != "Saga" || stats.Series[0].Pages != 3 {
+		t.Fatalf("series = %+v", stats.Series)
+	}
+
+	response = authRequest(t, server, "GET", "/api/stats?period=week&format=csv", nil)
+	if lines := strings.Split(strings.TrimSpace(response.Body.String()), "\n"); !strings.HasPrefix(response.Header().Get("Content-Type"), "text/csv") ||
+		lines[0] != "week,pages,books,completed,sessions,seconds" || len(lines) < 2 {
+		t.Fatalf("stats csv = %q", response.Body.String())
+	}
+	if response := authRequest(t, server, "GET", "/api/stats?period=month", nil); response.Code != http.StatusBadRequest {
+		t.Fatalf("bad period = %d", response.Code)
+	}
+}
+
+func TestHistoryViewsAreFlushed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.json")
+	store := loadHistoryStore(path)
+	now := time.Now()
+	store.view("", "book", "Root/vol1.cbz", "", 0, 10, now)
+	store.view("", "book", "Root/vol1.cbz", "", 1, 10, now.Add(time.Second))
+	if sessions := loadHistoryStore(path).forUser(""); len(sessions) != 1 || len(sessions[0].Pages) != 1 {
+		t.Fatalf("throttled view saved at once: %+v", sessions)
+	}
+	// Shutdown writes what the throttle held back
+	store.flush()
+	if sessions := loadHistoryStore(path).forUser(""); len(sessions) != 1 || len(sessions[0].Pages) != 2 || !sessions[0].End.Equal(now.Add(time.Second)) {
+		t.Fatalf("flushed sessions = %+v", sessions)
+	}
+}
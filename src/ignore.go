@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ignoreFileName is the per-directory ignore file, using gitignore syntax
+const ignoreFileName = ".litecomicsignore"
+
+// defaultIgnorePatterns hides OS/NAS metadata and LiteComics' own staging folders in every root
+var defaultIgnorePatterns = []string{
+	".DS_Store",
+	"._*",
+	"Thumbs.db",
+	"desktop.ini",
+	"@eaDir/",
+	"#recycle/",
+	"#snapshot/",
+	"$RECYCLE.BIN/",
+	"System Volume Information/",
+	ignoreFileName,
+	".litecomics-upload-*/",
+}
+
+// ignorePattern is one compiled gitignore-style line
+type ignorePattern struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+	base    string // slash-separated directory the pattern is relative to ("" for the root)
+}
+
+// ignoreFileEntry is a cached, compiled .litecomicsignore file
+type ignoreFileEntry struct {
+	modTime  time.Time
+	patterns []ignorePattern
+}
+
+// IgnoreFileCache caches compiled .litecomicsignore files keyed by path and modification time
+type IgnoreFileCache struct {
+	files map[string]*ignoreFileEntry
+	mu    sync.Mutex
+}
+
+// ignoreMatcher decides which entries of one root are hidden
+type ignoreMatcher struct {
+	rootPath     string
+	base         []ignorePattern
+	hideDotFiles bool
+	files        *IgnoreFileCache
+	dirs         map[string][]ignorePattern // collected patterns per directory, memoized for one operation
+}
+
+// compileIgnorePatterns compiles gitignore-style lines relative to base
+func compileIgnorePatterns(lines []string, base string) []ignorePattern {
+	patterns := make([]ignorePattern, 0, len(lines))
+	for _, line := range lines {
+		line = strings.TrimRight(line, "\r")
+		if !strings.HasSuffix(line, `\ `) {
+			line = strings.TrimRight(line, " ")
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		pattern := ignorePattern{base: base}
+		if strings.HasPrefix(line, "!") {
+			pattern.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			pattern.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+
+		// A slash at the start or middle anchors the pattern to its directory;
+		// otherwise it matches a name at any depth.
+		anchored := strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+		expr := globToRegexp(line)
+		if anchored {
+			expr = "^" + expr + "$"
+		} else {
+			expr = "(?:^|/)" + expr + "$"
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			continue
+		}
+		pattern.re = re
+		patterns = append(patterns, pattern)
+	}
+	return patterns
+}
+
+// globToRegexp converts a gitignore glob (with ** support) to a regular expression
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "/**") && i+3 == len(glob):
+			b.WriteString("/.*")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(glob):
+			i++
+			b.WriteString(regexp.QuoteMeta(string(glob[i])))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
+
+// load returns the compiled patterns of an ignore file, or nil when it does not exist
+func (c *IgnoreFileCache) load(filePath, base string) []ignorePattern {
+	info, err := os.Stat(filePath)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		delete(c.files, filePath)
+		return nil
+	}
+	if entry, ok := c.files[filePath]; ok && entry.modTime.Equal(info.ModTime()) {
+		return entry.patterns
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil
+	}
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	entry := &ignoreFileEntry{modTime: info.ModTime(), patterns: compileIgnorePatterns(lines, base)}
+	c.files[filePath] = entry
+	return entry.patterns
+}
+
+// ignoreMatcherFor returns the matcher for a root, or nil for an unknown root
+func (s *Server) ignoreMatcherFor(rootName string) *ignoreMatcher {
+	// Match initServer's nameToPath behavior when duplicate root names exist:
+	// the last configured entry wins.
+	for i := len(s.config.Roots) - 1; i >= 0; i-- {
+		root := s.config.Roots[i]
+		if root.Name != rootName {
+			continue
+		}
+		return &ignoreMatcher{
+			rootPath:     s.nameToPath[rootName],
+			base:         append(compileIgnorePatterns(defaultIgnorePatterns, ""), compileIgnorePatterns(root.Ignore, "")...),
+			hideDotFiles: root.HideDotFiles,
+			files:        s.ignoreFileCache,
+			dirs:         make(map[string][]ignorePattern),
+		}
+	}
+	return nil
+}
+
+// patternsFor collects the root patterns plus every .litecomicsignore from the root down to dir
+func (m *ignoreMatcher) patternsFor(dir string) []ignorePattern {
+	if patterns, ok := m.dirs[dir]; ok {
+		return patterns
+	}
+	var patterns []ignorePattern
+	if dir == "" {
+		patterns = m.base
+	} else {
+		patterns = m.patternsFor(parentDir(dir))
+	}
+	if own := m.files.load(filepath.Join(m.rootPath, filepath.FromSlash(dir), ignoreFileName), dir); len(own) > 0 {
+		patterns = append(append([]ignorePattern(nil), patterns...), own...)
+	}
+	m.dirs[dir] = patterns
+	return patterns
+}
+
+// ignored reports whether the item at relativePath (relative to the root) is hidden
+func (m *ignoreMatcher) ignored(relativePath string, isDir bool) bool {
+	if m == nil {
+		return false
+	}
+	relativePath = filepath.ToSlash(relativePath)
+	name := path.Base(relativePath)
+	if m.hideDotFiles && strings.HasPrefix(name, ".") {
+		return true
+	}
+
+	ignored := false
+	for _, pattern := range m.patternsFor(parentDir(relativePath)) {
+		if pattern.dirOnly && !isDir {
+			continue
+		}
+		target := relativePath
+		if pattern.base != "" {
+			target = strings.TrimPrefix(relativePath, pattern.base+"/")
+		}
+		if pattern.re.MatchString(target) {
+			ignored = !pattern.negate
+		}
+	}
+	return ignored
+}
+
+// hidden reports whether the item at relativePath or one of its parent directories is ignored
+func (m *ignoreMatcher) hidden(relativePath string, isDir bool) bool {
+	if m == nil || relativePath == "" {
+		return false
+	}
+	relativePath = filepath.ToSlash(relativePath)
+	for dir := parentDir(relativePath); dir != ""; dir = parentDir(dir) {
+		if m.ignored(dir, true) {
+			return true
+		}
+	}
+	return m.ignored(relativePath, isDir)
+}
+
+// parentDir returns the slash-separated parent of a relative path ("" for top-level items)
+func parentDir(relativePath string) string {
+	dir := path.Dir(relativePath)
+	if dir == "." || dir == "/" {
+		return ""
+	}
+	return dir
+}
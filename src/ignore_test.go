@@ -0,0 +1,106 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestIgnoreRulesApplyToListingsAndArchives(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		".DS_Store":                  "",
+		"Thumbs.db":                  "",
+		"@eaDir/thumb.jpg":           "",
+		"download.cbz.part":          "",
+		"series/vol1.cbz":            "",
+		"series/scans/raw.png":       "",
+		"series/keep.part":           "",
+		"series/" + ignoreFileName:   "scans/\n!keep.part\n",
+		".litecomics-upload-1/x.cbz": "",
+		"visible.cbz":                "",
+	}
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	server := newAuthTestServerWithConfig(t, &Config{Roots: []RootConfig{{Path: root, Name: "Root", Ignore: []string{"*.part"}}}})
+
+	list := func(requestPath string) string {
+		resolved, err := server.resolvePath(requestPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		items, err := server.readDirItems(resolved)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := make([]string, len(items))
+		for i := range items {
+			names[i] = items[i].Name
+		}
+		return fmt.Sprint(names)
+	}
+	if got := list("Root"); got != "[series visible.cbz]" {
+		t.Fatalf("root listing = %s", got)
+	}
+	if got := list("Root/series"); got != "[keep.part vol1.cbz]" {
+		t.Fatalf("series listing = %s", got)
+	}
+
+	// Direct requests for ignored items and anything below them are refused
+	for target, want := range map[string]int{
+		"/api/dir/Root/series/scans":          http.StatusNotFound,
+		"/api/file/Root/series/scans/raw.png": http.StatusNotFound,
+		"/api/file/Root/download.cbz.part":    http.StatusNotFound,
+		"/api/file/Root/series/keep.part":     http.StatusOK,
+		"/api/dir/Root/series":                http.StatusOK,
+	} {
+		if response := authRequest(t, server, "GET", target, nil); response.Code != want {
+			t.Errorf("%s = %d, want %d: %s", target, response.Code, want, response.Body.String())
+		}
+	}
+
+	matcher := server.ignoreMatcherFor("Root")
+	zipPath := filepath.Join(t.TempDir(), "series.zip")
+	err := createZipArchive(filepath.Join(root, "series"), zipPath, func(path string, info os.FileInfo) bool {
+		relativePath, err := filepath.Rel(root, path)
+		return err == nil && matcher.ignored(relativePath, info.IsDir())
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := zip.OpenReader(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	var names []string
+	for _, f := range reader.File {
+		names = append(names, f.Name)
+	}
+	sort.Strings(names)
+	if got := fmt.Sprint(names); got != "[series/ series/keep.part series/vol1.cbz]" {
+		t.Fatalf("archive entries = %s", got)
+	}
+}
+
+func TestHideDotFiles(t *testing.T) {
+	server := initServer(&Config{Roots: []RootConfig{{Path: t.TempDir(), Name: "Root", HideDotFiles: true}}})
+	matcher := server.ignoreMatcherFor("Root")
+	if !matcher.ignored(".hidden", false) || !matcher.ignored(filepath.Join("dir", ".config"), true) {
+		t.Fatal("dot files are not hidden")
+	}
+	if matcher.ignored("visible.cbz", false) {
+		t.Fatal("regular file is hidden")
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// IPFilter allows or denies clients by address. Deny entries win over allow
+// entries; an empty allow list admits every address that is not denied.
+type IPFilter struct {
+	Allow []string `json:"allow,omitempty"` // Addresses or CIDR ranges that may connect
+	Deny  []string `json:"deny,omitempty"`  // Addresses or CIDR ranges that are always rejected
+}
+
+// IPAccessConfig holds the address filters of each route group. The command and
+// settings filters fall back to the read filter when omitted.
+type IPAccessConfig struct {
+	Read     *IPFilter `json:"read,omitempty"`     // Browsing, reading and every other request
+	Command  *IPFilter `json:"command,omitempty"`  // /api/command/* (file operations and uploads)
+	Settings *IPFilter `json:"settings,omitempty"` // /api/settings/* (config, restart, users, tokens)
+}
+
+// ipRule is a parsed IPFilter
+type ipRule struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// ipRules are the parsed filters of each route group; nil entries admit everyone
+type ipRules struct {
+	read     *ipRule
+	command  *ipRule
+	settings *ipRule
+}
+
+// denyAll replaces filters that fail to parse so a typo never opens access
+var denyAll = &ipRule{deny: []*net.IPNet{
+	{IP: net.IPv4zero, Mask: net.CIDRMask(0, 32)},
+	{IP: net.IPv6zero, Mask: net.CIDRMask(0, 128)},
+}}
+
+// compileIPFilter parses a filter, rejecting every client when it is invalid
+func compileIPFilter(name string, filter *IPFilter) *ipRule {
+	if filter == nil {
+		return nil
+	}
+	allow, err := parseCIDRs(filter.Allow)
+	if err == nil {
+		var deny []*net.IPNet
+		if deny, err = parseCIDRs(filter.Deny); err == nil {
+			return &ipRule{allow: allow, deny: deny}
+		}
+	}
+	log.Printf("Warning: %s: %v; denying all clients", name, err)
+	return denyAll
+}
+
+// compileIPAccess parses the configured filters of every route group
+func compileIPAccess(cfg *IPAccessConfig) ipRules {
+	if cfg == nil {
+		return ipRules{}
+	}
+	rules := ipRules{read: compileIPFilter("ipAccess.read", cfg.Read)}
+	rules.command, rules.settings = rules.read, rules.read
+	if cfg.Command != nil {
+		rules.command = compileIPFilter("ipAccess.command", cfg.Command)
+	}
+	if cfg.Settings != nil {
+		rules.settings = compileIPFilter("ipAccess.settings", cfg.Settings)
+	}
+	return rules
+}
+
+// permits reports whether a client address passes the filter
+func (f *ipRule) permits(ip net.IP) bool {
+	if f == nil {
+		return true
+	}
+	if ip == nil || containsIP(f.deny, ip) {
+		return false
+	}
+	return len(f.allow) == 0 || containsIP(f.allow, ip)
+}
+
+// forPath returns the filter of the route group a request path belongs to
+func (r ipRules) forPath(p string) *ipRule {
+	switch {
+	case strings.HasPrefix(p, "/api/command/"):
+		return r.command
+	case strings.HasPrefix(p, "/api/settings/"):
+		return r.settings
+	default:
+		return r.read
+	}
+}
+
+// ipFilterMiddleware rejects clients outside the configured address ranges before routing
+func (s *Server) ipFilterMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.ipRules.forPath(r.URL.Path).permits(s.clientIP(r)) {
+			if strings.HasPrefix(r.URL.Path, "/api/") {
+				respondError(w, "Access from this address is not allowed", http.StatusForbidden)
+			} else {
+				http.Error(w, "Access from this address is not allowed", http.StatusForbidden)
+			}
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
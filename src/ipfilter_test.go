@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIPFilterPerRouteGroup(t *testing.T) {
+	server := newAuthTestServerWithConfig(t, &Config{
+		Roots:          []RootConfig{{Path: t.TempDir(), Name: "Root"}},
+		TrustedProxies: []string{"10.0.0.1"},
+		IPAccess: &IPAccessConfig{
+			Read:     &IPFilter{Allow: []string{"192.168.0.0/16", "10.0.0.1"}, Deny: []string{"192.168.0.99"}},
+			Settings: &IPFilter{Allow: []string{"192.168.0.10"}},
+		},
+	})
+	handler := server.handler()
+
+	request := func(remoteAddr, forwardedFor, target string) int {
+		r := httptest.NewRequest("GET", "http://localhost"+target, nil)
+		r.RemoteAddr = remoteAddr
+		if forwardedFor != "" {
+			r.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, r)
+		return response.Code
+	}
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor string
+		target       string
+		forbidden    bool
+	}{
+		{"lan read", "192.168.0.20:4000", "", "/api/dir", false},
+		{"denied host", "192.168.0.99:4000", "", "/api/dir", true},
+		{"outside read", "203.0.113.5:4000", "", "/api/dir", true},
+		{"outside page", "203.0.113.5:4000", "", "/viewer/", true},
+		{"lan settings", "192.168.0.20:4000", "", "/api/settings/users", true},
+		{"admin settings", "192.168.0.10:4000", "", "/api/settings/users", false},
+		{"command falls back to read", "192.168.0.20:4000", "", "/api/command/mkdir", false},
+		{"command from outside", "203.0.113.5:4000", "", "/api/command/mkdir", true},
+		{"via proxy", "10.0.0.1:4000", "192.168.0.20", "/api/dir", false},
+		{"via proxy from outside", "10.0.0.1:4000", "203.0.113.5", "/api/dir", true},
+		{"spoofed through proxy", "10.0.0.1:4000", "192.168.0.20, 203.0.113.5", "/api/dir", true},
+		{"untrusted forwarder", "203.0.113.5:4000", "192.168.0.20", "/api/dir", true},
+	}
+	for _, tt := range tests {
+		if got := request(tt.remoteAddr, tt.forwardedFor, tt.target); (got == http.StatusForbidden) != tt.forbidden {
+			t.Errorf("%s: %s = %d, forbidden %v", tt.name, tt.target, got, tt.forbidden)
+		}
+	}
+}
+
+func TestIPFilterInvalidEntryDeniesAll(t *testing.T) {
+	rule := compileIPFilter("ipAccess.read", &IPFilter{Allow: []string{"192.168.0.0/33"}})
+	if rule.permits(remoteIP(&http.Request{RemoteAddr: "192.168.0.1:80"})) {
+		t.Fatal("invalid filter admitted a client")
+	}
+}
@@ -0,0 +1,524 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// kosyncPrefix is where the KOReader progress sync protocol is served. KOReader's
+	// custom sync server setting takes this URL, e.g. https://host/api/kosync
+	kosyncPrefix = "/api/kosync/"
+	// kosyncDocumentPrefix keys progress of documents that are not in the library
+	kosyncDocumentPrefix = "kosync:"
+)
+
+// Error codes of the KOReader sync protocol
+const (
+	kosyncErrorUnauthorized       = 2001
+	kosyncErrorUserExists         = 2002
+	kosyncErrorInvalidFields      = 2003
+	kosyncErrorRegistrationClosed = 2005
+)
+
+// koreaderPosition is progress as KOReader reported it, returned unchanged to KOReader
+type koreaderPosition struct {
+	Progress   string  `json:"progress"` // Page number or XPointer
+	Percentage float64 `json:"percentage"`
+	DeviceID   string  `json:"deviceId,omitempty"`
+}
+
+// koreaderDocument is the digest of a book with the file state it was computed from
+type koreaderDocument struct {
+	Digest  string    `json:"digest"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+const (
+	// koreaderFlushDelay batches the writes of newly computed digests
+	koreaderFlushDelay = 10 * time.Second
+	// koreaderRescanInterval is how often an unknown digest may trigger a library
+	// walk, so documents outside the library do not walk it on every sync
+	koreaderRescanInterval = time.Minute
+)
+
+// KOReaderIndex persists the KOReader digests of library books by request path,
+// so synced documents are found without hashing the library again
+type KOReaderIndex struct {
+	mu       sync.Mutex
+	path     string
+	Docs     map[string]*koreaderDocument `json:"documents"`
+	byDigest map[string][]string          // content and file name digests -> request paths
+	scanned  time.Time                    // last complete walk
+	dirty    bool
+	timer    *time.Timer // pending flush of new digests
+}
+
+func loadKOReaderIndex(path string) *KOReaderIndex {
+	index := &KOReaderIndex{path: path, Docs: make(map[string]*koreaderDocument)}
+	if err := readJSONFile(path, index); err != nil {
+		logStoreLoadError("KOReader documents", err)
+	}
+	if index.Docs == nil {
+		index.Docs = make(map[string]*koreaderDocument)
+	}
+	index.byDigest = make(map[string][]string, 2*len(index.Docs))
+	for requestPath, document := range index.Docs {
+		index.link(requestPath, document)
+	}
+	return index
+}
+
+// koreaderDigest returns KOReader's partial MD5 of a file: 1 KiB samples at offset 0
+// and at 1 KiB << 2i for i = 0..10, stopping at the end of the file
+func koreaderDigest(fullPath string) (string, error) {
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := md5.New()
+	sample := make([]byte, 1024)
+	for i := -1; i <= 10; i++ {
+		// KOReader's lshift(1024, -2) wraps to 0 in LuaJIT
+		offset := int64(0)
+		if i >= 0 {
+			offset = 1024 << (2 * i)
+		}
+		n, err := file.ReadAt(sample, offset)
+		if n == 0 {
+			break
+		}
+		hash.Write(sample[:n])
+		if err != nil && err != io.EOF {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// koreaderFileNameDigest is the digest of KOReader's "filename" document matching
+func koreaderFileNameDigest(name string) string {
+	sum := md5.Sum([]byte(filepath.Base(name)))
+	return hex.EncodeToString(sum[:])
+}
+
+// link adds a document to the digest lookup; callers must hold mu
+func (x *KOReaderIndex) link(requestPath string, document *koreaderDocument) {
+	for _, digest := range []string{document.Digest, koreaderFileNameDigest(requestPath)} {
+		x.byDigest[digest] = append(x.byDigest[digest], requestPath)
+	}
+}
+
+// unlink removes a document from the index; callers must hold mu
+func (x *KOReaderIndex) unlink(requestPath string) {
+	document, ok := x.Docs[requestPath]
+	if !ok {
+		return
+	}
+	for _, digest := range []string{document.Digest, koreaderFileNameDigest(requestPath)} {
+		paths := x.byDigest[digest]
+		for i, path := range paths {
+			if path == requestPath {
+				paths = append(paths[:i:i], paths[i+1:]...)
+				break
+			}
+		}
+		if len(paths) == 0 {
+			delete(x.byDigest, digest)
+		} else {
+			x.byDigest[digest] = paths
+		}
+	}
+	delete(x.Docs, requestPath)
+	x.changed()
+}
+
+// changed schedules a write of the index; callers must hold mu
+func (x *KOReaderIndex) changed() {
+	x.dirty = true
+	if x.timer == nil {
+		x.timer = time.AfterFunc(koreaderFlushDelay, x.flush)
+	}
+}
+
+// flush saves the index when it changed
+func (x *KOReaderIndex) flush() {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.timer != nil {
+		x.timer.Stop()
+		x.timer = nil
+	}
+	if !x.dirty {
+		return
+	}
+	if err := writeJSONFile(x.path, x); err != nil {
+		logStoreError("KOReader documents", err)
+		return
+	}
+	x.dirty = false
+}
+
+// candidates returns the request paths recorded under a content or file name digest
+func (x *KOReaderIndex) candidates(digest string) []string {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return append([]string(nil), x.byDigest[digest]...)
+}
+
+// digest returns the recorded digest of a book, computing it when the file changed
+func (x *KOReaderIndex) digest(requestPath, fullPath string, size int64, modTime time.Time) string {
+	x.mu.Lock()
+	document, ok := x.Docs[requestPath]
+	x.mu.Unlock()
+	if ok && document.Size == size && document.ModTime.Equal(modTime) {
+		return document.Digest
+	}
+	digest, err := koreaderDigest(fullPath)
+	if err != nil {
+		return ""
+	}
+	x.mu.Lock()
+	x.unlink(requestPath)
+	document = &koreaderDocument{Digest: digest, Size: size, ModTime: modTime}
+	x.Docs[requestPath] = document
+	x.link(requestPath, document)
+	x.changed()
+	x.mu.Unlock()
+	return digest
+}
+
+// remove forgets a book that is no longer in the library
+func (x *KOReaderIndex) remove(requestPath string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.unlink(requestPath)
+}
+
+// rescanDue reports whether an unknown digest may walk the library now
+func (x *KOReaderIndex) rescanDue(now time.Time) bool {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return now.Sub(x.scanned) >= koreaderRescanInterval
+}
+
+// scannedLibrary records a complete walk and drops the books it did not see
+func (x *KOReaderIndex) scannedLibrary(seen map[string]bool, now time.Time) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for requestPath := range x.Docs {
+		if !seen[requestPath] {
+			x.unlink(requestPath)
+		}
+	}
+	x.scanned = now
+}
+
+// invalidate lets the next unknown digest walk the library again
+func (x *KOReaderIndex) invalidate() {
+	x.mu.Lock()
+	x.scanned = time.Time{}
+	x.mu.Unlock()
+}
+
+// movePath follows a rename or move without hashing the books again
+func (x *KOReaderIndex) movePath(oldPath, newPath string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	moves := make(map[string]string)
+	for requestPath := range x.Docs {
+		if moved, ok := movedPath(requestPath, oldPath, newPath); ok {
+			moves[requestPath] = moved
+		}
+	}
+	for requestPath, moved := range moves {
+		document := x.Docs[requestPath]
+		x.unlink(requestPath)
+		x.Docs[moved] = document
+		x.link(moved, document)
+	}
+}
+
+// koreaderDocumentMatches reports whether the book at a request path still has a
+// KOReader digest, updating the index when the file changed or disappeared
+func (s *Server) koreaderDocumentMatches(requestPath, digest string) bool {
+	resolved, err := s.resolvePath(requestPath)
+	if err != nil {
+		s.koreaderDocs.remove(requestPath)
+		return false
+	}
+	info, err := os.Stat(resolved.FullPath)
+	if err != nil || info.IsDir() {
+		s.koreaderDocs.remove(requestPath)
+		return false
+	}
+	return koreaderFileNameDigest(requestPath) == digest ||
+		s.koreaderDocs.digest(requestPath, resolved.FullPath, info.Size(), info.ModTime()) == digest
+}
+
+// findKOReaderDocument returns the request path of the visible book with a KOReader
+// digest, matching either the partial MD5 of the file or the MD5 of its name. Known
+// digests are looked up in the index; unknown ones walk the library at most once
+// per koreaderRescanInterval, hashing only books that are new or changed.
+func (s *Server) findKOReaderDocument(digest string, access *Access) (string, bool) {
+	digest = strings.ToLower(digest)
+	for _, requestPath := range s.koreaderDocs.candidates(digest) {
+		if access.canSeePath(requestPath) && s.koreaderDocumentMatches(requestPath, digest) {
+			return requestPath, true
+		}
+	}
+
+	now := time.Now()
+	if !s.koreaderDocs.rescanDue(now) {
+		return "", false
+	}
+	found := ""
+	seen := make(map[string]bool)
+	s.walkLibrary(func(item fileItem, fullPath string) error {
+		if item.Type != "book" {
+			return nil
+		}
+		seen[item.Path] = true
+		if s.koreaderDocs.digest(item.Path, fullPath, item.Size, item.Modified) == digest || koreaderFileNameDigest(item.Name) == digest {
+			if access.canSeePath(item.Path) {
+				found = item.Path
+				return errStopWalk
+			}
+		}
+		return nil
+	})
+	if found == "" {
+		s.koreaderDocs.scannedLibrary(seen, now)
+	}
+	return found, found != ""
+}
+
+// hashSyncKey hashes the key KOReader authenticates with: the MD5 of the password
+func hashSyncKey(password string) (string, error) {
+	sum := md5.Sum([]byte(password))
+	hash, err := bcrypt.GenerateFromPassword([]byte(hex.EncodeToString(sum[:])), passwordHashCost)
+	return string(hash), err
+}
+
+// setSyncKey stores the KOReader key of an account that predates it
+func (u *UserStore) setSyncKey(name, password string) {
+	hash, err := hashSyncKey(password)
+	if err != nil {
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	user, ok := u.Users[name]
+	if !ok || user.SyncKeyHash != "" {
+		return
+	}
+	user.SyncKeyHash = hash
+	if err := u.save(); err != nil {
+		logStoreError("users", err)
+	}
+}
+
+// authenticateSyncKey checks a name and KOReader key and returns a copy of the account
+func (u *UserStore) authenticateSyncKey(name, key string) (User, bool) {
+	u.mu.Lock()
+	user, ok := u.Users[name]
+	var account User
+	if ok {
+		account = *user
+	}
+	u.mu.Unlock()
+
+	if !ok || account.SyncKeyHash == "" {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(key))
+		return User{}, false
+	}
+	if bcrypt.CompareHashAndPassword([]byte(account.SyncKeyHash), []byte(strings.ToLower(key))) != nil {
+		return User{}, false
+	}
+	return account, true
+}
+
+// respondKOSync sends a KOReader sync response with a status code
+func respondKOSync(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
+
+// respondKOSyncError sends an error in the format KOReader shows to the user
+func respondKOSyncError(w http.ResponseWriter, status, code int, message string) {
+	respondKOSync(w, status, struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}{code, message})
+}
+
+// kosyncUser authenticates the x-auth-user and x-auth-key headers KOReader sends and
+// returns the progress user and what it may see. Without accounts every client
+// syncs as the "" user. On failure the response has been written.
+func (s *Server) kosyncUser(w http.ResponseWriter, r *http.Request) (string, *Access, bool) {
+	if !s.authEnabled() {
+		return "", fullAccess, true
+	}
+	name, key := r.Header.Get("x-auth-user"), r.Header.Get("x-auth-key")
+	client, now := s.rateLimitClient(r), time.Now()
+	if locked, wait := s.loginGuard.locked(client, name, now); locked {
+		respondTooManyRequests(w, "Too many failed logins; try again later", wait)
+		return "", nil, false
+	}
+	user, ok := s.users.authenticateSyncKey(name, key)
+	if !ok {
+		s.loginGuard.fail(client, name, now)
+		respondKOSyncError(w, http.StatusUnauthorized, kosyncErrorUnauthorized, "Unauthorized")
+		return "", nil, false
+	}
+	s.loginGuard.succeed(client, name)
+	return user.Name, s.accessFor(&user), true
+}
+
+// handleKOSyncCreateUser answers KOReader's registration. Accounts are managed in
+// LiteComics, so existing names are reported as registered and others are refused.
+func (s *Server) handleKOSyncCreateUser(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Username == "" || req.Password == "" {
+		respondKOSyncError(w, http.StatusForbidden, kosyncErrorInvalidFields, "Invalid request")
+		return
+	}
+	if !s.authEnabled() {
+		respondKOSync(w, http.StatusCreated, map[string]string{"username": req.Username})
+		return
+	}
+	s.users.mu.Lock()
+	_, exists := s.users.Users[req.Username]
+	s.users.mu.Unlock()
+	if exists {
+		respondKOSyncError(w, http.StatusPaymentRequired, kosyncErrorUserExists, "Username is already registered; log in with your LiteComics password")
+		return
+	}
+	respondKOSyncError(w, http.StatusPaymentRequired, kosyncErrorRegistrationClosed, "User registration is disabled; ask an administrator for a LiteComics account")
+}
+
+// handleKOSyncAuth lets KOReader check its credentials
+func (s *Server) handleKOSyncAuth(w http.ResponseWriter, r *http.Request) {
+	if _, _, ok := s.kosyncUser(w, r); !ok {
+		return
+	}
+	respondKOSync(w, http.StatusOK, map[string]string{"authorized": "OK"})
+}
+
+// kosyncProgress is a position in the KOReader sync protocol
+type kosyncProgress struct {
+	Document   string  `json:"document"`
+	Progress   string  `json:"progress"`
+	Percentage float64 `json:"percentage"`
+	Device     string  `json:"device"`
+	DeviceID   string  `json:"device_id"`
+	Timestamp  int64   `json:"timestamp,omitempty"`
+}
+
+// kosyncProgressID returns the progress key of a KOReader document: the book ID of
+// the matching library book, or the digest for documents outside the library
+func (s *Server) kosyncProgressID(digest string, access *Access) (string, *ResolvedPath) {
+	if requestPath, ok := s.findKOReaderDocument(digest, access); ok {
+		if resolved, err := s.resolvePath(requestPath); err == nil {
+			if id, err := s.bookID(resolved); err == nil {
+				return id, resolved
+			}
+		}
+	}
+	return kosyncDocumentPrefix + strings.ToLower(digest), nil
+}
+
+// handleKOSyncPutProgress saves progress from KOReader. Page numbers map onto the
+// book's pages; other positions (XPointers) use the percentage.
+func (s *Server) handleKOSyncPutProgress(w http.ResponseWriter, r *http.Request) {
+	user, access, ok := s.kosyncUser(w, r)
+	if !ok {
+		return
+	}
+	var req kosyncProgress
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Document == "" || req.Progress == "" || req.Device == "" ||
+		req.Percentage < 0 || req.Percentage > 1 {
+		respondKOSyncError(w, http.StatusForbidden, kosyncErrorInvalidFields, "Invalid request")
+		return
+	}
+
+	id, resolved := s.kosyncProgressID(req.Document, access)
+	now := time.Now()
+	record := progressRecord{
+		Progress: Progress{Completed: req.Percentage >= 1, Device: req.Device, Updated: now},
+		KOReader: &koreaderPosition{Progress: req.Progress, Percentage: req.Percentage, DeviceID: req.DeviceID},
+	}
+	if resolved != nil {
+		record.Path = resolved.RequestPath()
+		record.Pages = s.bookPageCount(resolved)
+		if page, err := strconv.Atoi(req.Progress); err == nil && page > 0 {
+			record.Page = page - 1
+		} else if record.Pages > 0 {
+			record.Page = min(int(req.Percentage*float64(record.Pages)), record.Pages-1)
+		}
+	}
+	if _, _, err := s.progress.put(user, id, record); err != nil {
+		respondKOSyncError(w, http.StatusInternalServerError, 0, err.Error())
+		return
+	}
+	if resolved != nil {
+		s.recordHistory(user, id, resolved, record.Page, record.Pages, now)
+	}
+	respondKOSync(w, http.StatusOK, struct {
+		Document  string `json:"document"`
+		Timestamp int64  `json:"timestamp"`
+	}{req.Document, now.Unix()})
+}
+
+// handleKOSyncGetProgress returns the latest progress in a document. Progress saved
+// by the LiteComics viewer is converted to a page number and percentage.
+func (s *Server) handleKOSyncGetProgress(w http.ResponseWriter, r *http.Request) {
+	user, access, ok := s.kosyncUser(w, r)
+	if !ok {
+		return
+	}
+	digest := mux.Vars(r)["document"]
+	id, resolved := s.kosyncProgressID(digest, access)
+	record, ok := s.progress.get(user, id)
+	if !ok {
+		respondKOSync(w, http.StatusOK, struct{}{})
+		return
+	}
+
+	result := kosyncProgress{Document: digest, Device: record.Device, Timestamp: record.Updated.Unix()}
+	if record.KOReader != nil {
+		result.Progress = record.KOReader.Progress
+		result.Percentage = record.KOReader.Percentage
+		result.DeviceID = record.KOReader.DeviceID
+	} else {
+		result.Progress = strconv.Itoa(max(record.Page, 0) + 1)
+		if record.Completed {
+			result.Percentage = 1
+		} else if resolved != nil {
+			if count := s.bookPageCount(resolved); count > 0 {
+				result.Percentage = math.Min(1, float64(max(record.Page, 0)+1)/float64(count))
+			}
+		}
+	}
+	respondKOSync(w, http.StatusOK, result)
+}
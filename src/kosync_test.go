@@ -0,0 +1,164 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestKOReaderDigest(t *testing.T) {
+	data := make([]byte, 17000)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	path := filepath.Join(t.TempDir(), "book.cbz")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Samples at 0, 1 KiB, 4 KiB and a partial one at 16 KiB; 64 KiB is past the end
+	var sampled []byte
+	sampled = append(sampled, data[0:2048]...)
+	sampled = append(sampled, data[4096:5120]...)
+	sampled = append(sampled, data[16384:]...)
+	sum := md5.Sum(sampled)
+
+	digest, err := koreaderDigest(path)
+	if err != nil || digest != hex.EncodeToString(sum[:]) {
+		t.Fatalf("digest = %q, %v; want %x", digest, err, sum)
+	}
+}
+
+func kosyncRequest(t *testing.T, server *Server, method, target, user, password string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	request := httptest.NewRequest(method, "http://localhost"+target, bytes.NewReader(data))
+	request.Header.Set("Accept", "application/vnd.koreader.v1+json")
+	request.Header.Set("Content-Type", "application/json")
+	if user != "" {
+		key := md5.Sum([]byte(password))
+		request.Header.Set("x-auth-user", user)
+		request.Header.Set("x-auth-key", hex.EncodeToString(key[:]))
+	}
+	response := httptest.NewRecorder()
+	server.handler().ServeHTTP(response, request)
+	return response
+}
+
+func TestKOSyncProgress(t *testing.T) {
+	root := t.TempDir()
+	bookPath := filepath.Join(root, "vol1.cbz")
+	writeTestZip(t, bookPath, zip.Store,
+		map[string]string{"01.jpg": "a", "02.jpg": "b", "03.jpg": "c", "04.jpg": "d"},
+		[]string{"01.jpg", "02.jpg", "03.jpg", "04.jpg"})
+	server := newAuthTestServerWithConfig(t, &Config{Roots: []RootConfig{{Path: root, Name: "Root"}}})
+	admin := sessionCookie(t, authRequest(t, server, "POST", "/api/settings/users", map[string]interface{}{"name": "alice", "password": "correct horse"}))
+
+	if response := kosyncRequest(t, server, "GET", "/api/kosync/users/auth", "alice", "correct horse", nil); response.Code != http.StatusOK {
+		t.Fatalf("auth = %d; body = %s", response.Code, response.Body.String())
+	}
+	if response := kosyncRequest(t, server, "GET", "/api/kosync/users/auth", "alice", "wrong", nil); response.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong key = %d", response.Code)
+	}
+	if response := kosyncRequest(t, server, "POST", "/api/kosync/users/create", "", "", map[string]string{"username": "alice", "password": "x"}); response.Code != http.StatusPaymentRequired {
+		t.Fatalf("register existing = %d", response.Code)
+	}
+
+	digest, err := koreaderDigest(bookPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	put := map[string]interface{}{"document": digest, "progress": "3", "percentage": 0.75, "device": "Kobo", "device_id": "K1"}
+	if response := kosyncRequest(t, server, "PUT", "/api/kosync/syncs/progress", "alice", "correct horse", put); response.Code != http.StatusOK {
+		t.Fatalf("put = %d; body = %s", response.Code, response.Body.String())
+	}
+
+	// KOReader's page lands in the viewer's progress
+	var progress Progress
+	response := authRequest(t, server, "GET", "/api/book/Root/vol1.cbz/progress", nil, admin)
+	if err := json.Unmarshal(response.Body.Bytes(), &progress); err != nil || progress.Page != 2 || progress.Device != "Kobo" {
+		t.Fatalf("viewer progress = %s", response.Body.String())
+	}
+
+	var synced kosyncProgress
+	response = kosyncRequest(t, server, "GET", "/api/kosync/syncs/progress/"+digest, "alice", "correct horse", nil)
+	if err := json.Unmarshal(response.Body.Bytes(), &synced); err != nil || synced.Progress != "3" || synced.Percentage != 0.75 || synced.DeviceID != "K1" {
+		t.Fatalf("kosync progress = %s", response.Body.String())
+	}
+
+	// Progress saved in the viewer is converted for KOReader
+	authRequest(t, server, "PUT", "/api/book/Root/vol1.cbz/progress", map[string]interface{}{"page": 1, "device": "Browser"}, admin)
+	synced = kosyncProgress{}
+	response = kosyncRequest(t, server, "GET", "/api/kosync/syncs/progress/"+digest, "alice", "correct horse", nil)
+	if err := json.Unmarshal(response.Body.Bytes(), &synced); err != nil || synced.Progress != "2" || synced.Percentage != 0.5 || synced.Device != "Browser" {
+		t.Fatalf("converted progress = %s", response.Body.String())
+	}
+
+	// Documents outside the library still sync between KOReader devices
+	other := map[string]interface{}{"document": "0123456789abcdef0123456789abcdef", "progress": "/body/DocFragment[3]", "percentage": 0.2, "device": "Kobo"}
+	kosyncRequest(t, server, "PUT", "/api/kosync/syncs/progress", "alice", "correct horse", other)
+	synced = kosyncProgress{}
+	response = kosyncRequest(t, server, "GET", "/api/kosync/syncs/progress/0123456789abcdef0123456789abcdef", "alice", "correct horse", nil)
+	if err := json.Unmarshal(response.Body.Bytes(), &synced); err != nil || synced.Progress != "/body/DocFragment[3]" {
+		t.Fatalf("unknown document progress = %s", response.Body.String())
+	}
+	var list struct {
+		Books []progressItem `json:"books"`
+	}
+	response = authRequest(t, server, "GET", "/api/progress", nil, admin)
+	if err := json.Unmarshal(response.Body.Bytes(), &list); err != nil || len(list.Books) != 1 {
+		t.Fatalf("progress list = %s", response.Body.String())
+	}
+
+	if response := kosyncRequest(t, server, "GET", "/api/kosync/syncs/progress/ffffffffffffffffffffffffffffffff", "alice", "correct horse", nil); response.Code != http.StatusOK || response.Body.String() != "{}\n" {
+		t.Fatalf("missing progress = %d %q", response.Code, response.Body.String())
+	}
+}
+
+func TestKOReaderIndexPersistsDigests(t *testing.T) {
+	t.Setenv("DATA_DIR", t.TempDir())
+	root := t.TempDir()
+	bookPath := filepath.Join(root, "vol1.cbz")
+	writeTestZip(t, bookPath, zip.Store, map[string]string{"01.jpg": "a"}, []string{"01.jpg"})
+	digest, err := koreaderDigest(bookPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{Roots: []RootConfig{{Path: root, Name: "Root"}}}
+	server := initServer(cfg)
+	if path, ok := server.findKOReaderDocument(digest, fullAccess); !ok || path != filepath.Join("Root", "vol1.cbz") {
+		t.Fatalf("found %q, %v", path, ok)
+	}
+	server.flush()
+
+	// A restarted server finds the book from the index without walking the library
+	server = initServer(cfg)
+	server.koreaderDocs.scanned = time.Now()
+	if path, ok := server.findKOReaderDocument(digest, fullAccess); !ok || path != filepath.Join("Root", "vol1.cbz") {
+		t.Fatalf("from index = %q, %v", path, ok)
+	}
+	if _, ok := server.findKOReaderDocument("0123456789abcdef0123456789abcdef", fullAccess); ok {
+		t.Fatal("unknown digest found")
+	}
+
+	if err := os.Rename(bookPath, filepath.Join(root, "renamed.cbz")); err != nil {
+		t.Fatal(err)
+	}
+	server.onPathMoved(filepath.Join("Root", "vol1.cbz"), filepath.Join("Root", "renamed.cbz"))
+	if path, ok := server.findKOReaderDocument(digest, fullAccess); !ok || path != filepath.Join("Root", "renamed.cbz") {
+		t.Fatalf("after move = %q, %v", path, ok)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"io/fs"
+	"path/filepath"
+)
+
+// libraryRoots returns the configured root names in config order, skipping
+// names shadowed by a later entry (initServer's nameToPath keeps the last one).
+func (s *Server) libraryRoots() []string {
+	names := make([]string, 0, len(s.config.Roots))
+	seen := make(map[string]bool)
+	for i := len(s.config.Roots) - 1; i >= 0; i-- {
+		if name := s.config.Roots[i].Name; !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	for i, j := 0, len(names)-1; i < j; i, j = i+1, j-1 {
+		names[i], names[j] = names[j], names[i]
+	}
+	return names
+}
+
+// walkLibrary calls fn for every file that is not ignored in every root.
+// Unreadable directories are skipped; an error returned by fn stops the walk.
+func (s *Server) walkLibrary(fn func(item fileItem, fullPath string) error) error {
+	for _, rootName := range s.libraryRoots() {
+		rootPath := s.nameToPath[rootName]
+		matcher := s.ignoreMatcherFor(rootName)
+		err := filepath.WalkDir(rootPath, func(fullPath string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				if entry != nil && entry.IsDir() && fullPath != rootPath {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if fullPath == rootPath {
+				return nil
+			}
+			relativePath, err := filepath.Rel(rootPath, fullPath)
+			if err != nil {
+				return nil
+			}
+			if matcher.ignored(relativePath, entry.IsDir()) {
+				if entry.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if entry.IsDir() {
+				return nil
+			}
+			info, err := entry.Info()
+			if err != nil {
+				return nil
+			}
+			return fn(s.newFileItem(filepath.Join(rootName, relativePath), info), fullPath)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
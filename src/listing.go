@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/maruel/natural"
+)
+
+// maxListLimit bounds a single page of a directory listing
+const maxListLimit = 5000
+
+// typeOrder is the grouping order used when sorting by type
+var typeOrder = map[string]int{"directory": 0, "book": 1, "video": 2, "audio": 3, "file": 4}
+
+// listOptions holds the sorting, filtering and pagination query of a listing
+type listOptions struct {
+	Sort   string
+	Desc   bool
+	Types  map[string]bool
+	Query  string
+	Offset int
+	Limit  int
+	After  *fileItem // the last item of the previous page, from the cursor
+}
+
+// listCursor is the sort position of the last item of a page. Pages continue
+// after this position, so a cursor stays valid when its item is removed.
+type listCursor struct {
+	Name     string    `json:"n"`
+	Type     string    `json:"t"`
+	Size     int64     `json:"s"`
+	Modified time.Time `json:"m"`
+}
+
+// encodeListCursor returns the opaque cursor for the page after an item
+func encodeListCursor(item fileItem) string {
+	data, _ := json.Marshal(listCursor{Name: item.Name, Type: item.Type, Size: item.Size, Modified: item.Modified})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeListCursor reads a cursor back into the item it was taken from
+func decodeListCursor(value string) (*fileItem, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	var cursor listCursor
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.Name == "" {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	return &fileItem{Name: cursor.Name, Type: cursor.Type, Size: cursor.Size, Modified: cursor.Modified}, nil
+}
+
+// parseListOptions reads listing options from query parameters
+func parseListOptions(query url.Values) (*listOptions, error) {
+	opts := &listOptions{Sort: query.Get("sort"), Query: strings.ToLower(query.Get("q"))}
+
+	switch opts.Sort {
+	case "":
+		opts.Sort = "name"
+	case "name", "modified", "size", "type":
+	default:
+		return nil, fmt.Errorf("invalid sort key: %s", opts.Sort)
+	}
+
+	switch query.Get("order") {
+	case "", "asc":
+	case "desc":
+		opts.Desc = true
+	default:
+		return nil, fmt.Errorf("invalid order: %s", query.Get("order"))
+	}
+
+	if value := query.Get("type"); value != "" {
+		opts.Types = make(map[string]bool)
+		for _, fileType := range strings.Split(value, ",") {
+			if _, ok := typeOrder[fileType]; !ok {
+				return nil, fmt.Errorf("invalid type: %s", fileType)
+			}
+			opts.Types[fileType] = true
+		}
+	}
+
+	if value := query.Get("offset"); value != "" {
+		offset, err := strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			return nil, fmt.Errorf("invalid offset: %s", value)
+		}
+		opts.Offset = offset
+	}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxListLimit {
+			return nil, fmt.Errorf("limit must be between 1 and %d", maxListLimit)
+		}
+		opts.Limit = limit
+	}
+	if value := query.Get("cursor"); value != "" {
+		after, err := decodeListCursor(value)
+		if err != nil {
+			return nil, err
+		}
+		opts.After = after
+	}
+	return opts, nil
+}
+
+// apply filters, sorts and pages a listing. It returns the page, the number of
+// matching items and the cursor for the next page ("" on the last page).
+func (opts *listOptions) apply(files []fileItem) ([]fileItem, int, string) {
+	filtered := files[:0:0]
+	for _, file := range files {
+		if opts.Types != nil && !opts.Types[file.Type] {
+			continue
+		}
+		if opts.Query != "" && !strings.Contains(strings.ToLower(file.Name), opts.Query) {
+			continue
+		}
+		filtered = append(filtered, file)
+	}
+
+	sortFileItemsBy(filtered, opts.Sort, opts.Desc)
+
+	start := opts.Offset
+	if opts.After != nil {
+		// Continue after the cursor's sort position rather than its index, so pages
+		// stay stable when entries are added or removed before it.
+		start = sort.Search(len(filtered), func(i int) bool {
+			return fileItemLess(*opts.After, filtered[i], opts.Sort, opts.Desc)
+		})
+	}
+	if start > len(filtered) {
+		start = len(filtered)
+	}
+	end := len(filtered)
+	if opts.Limit > 0 && start+opts.Limit < end {
+		end = start + opts.Limit
+	}
+
+	nextCursor := ""
+	if end < len(filtered) && end > start {
+		nextCursor = encodeListCursor(filtered[end-1])
+	}
+	return filtered[start:end], len(filtered), nextCursor
+}
+
+// sortFileItemsBy orders directories first, then by the given key with natural name as tiebreaker
+func sortFileItemsBy(files []fileItem, key string, desc bool) {
+	sort.SliceStable(files, func(i, j int) bool {
+		return fileItemLess(files[i], files[j], key, desc)
+	})
+}
+
+// fileItemLess is the listing order of sortFileItemsBy
+func fileItemLess(a, b fileItem, key string, desc bool) bool {
+	if (a.Type == "directory") != (b.Type == "directory") {
+		return a.Type == "directory"
+	}
+	if desc {
+		a, b = b, a
+	}
+	switch key {
+	case "modified":
+		if !a.Modified.Equal(b.Modified) {
+			return a.Modified.Before(b.Modified)
+		}
+	case "size":
+		if a.Size != b.Size {
+			return a.Size < b.Size
+		}
+	case "type":
+		if typeOrder[a.Type] != typeOrder[b.Type] {
+			return typeOrder[a.Type] < typeOrder[b.Type]
+		}
+	}
+	return natural.Less(a.Name, b.Name)
+}
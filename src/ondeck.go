@@ -0,0 +1,122 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultOnDeckLimit = 20
+	maxOnDeckLimit     = 100
+)
+
+// onDeckItem is a book on the continue-reading shelf
+type onDeckItem struct {
+	fileItem
+	Reason   string    `json:"reason"` // "continue" for a book in progress, "next" for the volume after a finished one
+	LastRead time.Time `json:"lastRead"`
+}
+
+// finishedVolume is the highest finished volume of a series
+type finishedVolume struct {
+	resolved *ResolvedPath
+	series   string
+	number   float64
+	lastRead time.Time
+}
+
+// nextVolume returns the volume after a book in its series within the same directory
+func (s *Server) nextVolume(book *ResolvedPath, series string) *fileItem {
+	parent := parentPath(book)
+	items, err := s.readDirItems(parent)
+	if err != nil {
+		return nil
+	}
+	s.applyComicInfoSeries(parent, items)
+	groups, _ := groupSeries(items)
+	name := filepath.Base(book.FullPath)
+	for _, group := range groups {
+		if seriesKey(group.Name) != seriesKey(series) {
+			continue
+		}
+		for i := range group.Volumes {
+			if group.Volumes[i].Name == name && i+1 < len(group.Volumes) {
+				return &group.Volumes[i+1]
+			}
+		}
+	}
+	return nil
+}
+
+// handleOnDeck lists what the requester can continue reading across all roots: books
+// in progress, and the next unread volume of each series whose latest read volume
+// was finished. Most recently read first; the optional limit parameter caps the list.
+func (s *Server) handleOnDeck(w http.ResponseWriter, r *http.Request) {
+	limit := defaultOnDeckLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			respondError(w, "limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+		limit = min(parsed, maxOnDeckLimit)
+	}
+
+	access := s.requestAccess(r)
+	records := s.progress.forUser(requestUserName(r))
+	recorded := make(map[string]bool, len(records))
+	for _, record := range records {
+		recorded[record.Path] = true
+	}
+
+	items := make([]onDeckItem, 0)
+	finished := make(map[string]*finishedVolume)
+	for id, record := range records {
+		if record.Path == "" || !access.canSeePath(record.Path) {
+			continue
+		}
+		resolved, err := s.resolvePath(record.Path)
+		if err != nil {
+			continue
+		}
+		info, err := os.Stat(resolved.FullPath)
+		if err != nil || info.IsDir() {
+			continue
+		}
+		if !record.Completed {
+			item := onDeckItem{fileItem: s.newFileItem(record.Path, info), Reason: "continue", LastRead: record.lastRead()}
+			item.ID = id
+			progress := record.Progress
+			item.Progress = &progress
+			items = append(items, item)
+			continue
+		}
+		series, number, ok := s.seriesInfoFor(resolved.FullPath)
+		if !ok {
+			continue
+		}
+		key := filepath.Dir(record.Path) + "\x00" + seriesKey(series)
+		if current, ok := finished[key]; !ok || number > current.number {
+			finished[key] = &finishedVolume{resolved: resolved, series: series, number: number, lastRead: record.lastRead()}
+		}
+	}
+	for _, volume := range finished {
+		next := s.nextVolume(volume.resolved, volume.series)
+		if next == nil || recorded[next.Path] {
+			continue
+		}
+		items = append(items, onDeckItem{fileItem: *next, Reason: "next", LastRead: volume.lastRead})
+	}
+
+	sort.Slice(items, func(i, j int) bool { return items[i].LastRead.After(items[j].LastRead) })
+	if len(items) > limit {
+		items = items[:limit]
+	}
+	respondJSON(w, struct {
+		Books []onDeckItem `json:"books"`
+	}{items})
+}
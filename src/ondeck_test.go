@@ -0,0 +1,67 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestOnDeck(t *testing.T) {
+	root := t.TempDir()
+	order := []string{"01.jpg", "02.jpg", "03.jpg"}
+	for _, name := range []string{"Saga_v01.cbz", "Saga_v02.cbz", "Saga_v03.cbz", "Other.cbz"} {
+		// Distinct contents give each book its own ID
+		pages := map[string]string{"01.jpg": name, "02.jpg": "b", "03.jpg": "c"}
+		writeTestZip(t, filepath.Join(root, name), zip.Store, pages, order)
+	}
+	server := newAuthTestServerWithConfig(t, &Config{Roots: []RootConfig{{Path: root, Name: "Root"}}})
+
+	onDeck := func() []onDeckItem {
+		t.Helper()
+		var result struct {
+			Books []onDeckItem `json:"books"`
+		}
+		response := authRequest(t, server, "GET", "/api/ondeck", nil)
+		if err := json.Unmarshal(response.Body.Bytes(), &result); err != nil || response.Code != http.StatusOK {
+			t.Fatalf("ondeck = %d; body = %s", response.Code, response.Body.String())
+		}
+		return result.Books
+	}
+
+	// Page requests record progress until a client reports it
+	if response := authRequest(t, server, "GET", "/api/book/Root/Other.cbz/image/1", nil); response.Code != http.StatusOK {
+		t.Fatalf("image = %d", response.Code)
+	}
+	books := onDeck()
+	if len(books) != 1 || books[0].Name != "Other.cbz" || books[0].Reason != "continue" || books[0].Progress.Page != 1 || books[0].Progress.Pages != 3 {
+		t.Fatalf("after page view = %+v", books)
+	}
+	// The viewer's read-ahead is not a page view
+	authRequest(t, server, "GET", "/api/book/Root/Other.cbz/image/2?prefetch=1", nil)
+	if books := onDeck(); books[0].Progress.Page != 1 || books[0].Progress.Completed {
+		t.Fatalf("prefetch counted as a view: %+v", books[0].Progress)
+	}
+	authRequest(t, server, "PUT", "/api/book/Root/Other.cbz/progress", map[string]interface{}{"page": 0, "updated": time.Now().Add(-time.Minute)})
+	authRequest(t, server, "GET", "/api/book/Root/Other.cbz/image/2", nil)
+	if books := onDeck(); books[0].Progress.Page != 0 || books[0].Progress.Completed {
+		t.Fatalf("page view overrode reported progress: %+v", books[0].Progress)
+	}
+
+	// Finishing a volume puts the next one on deck
+	time.Sleep(10 * time.Millisecond)
+	authRequest(t, server, "PUT", "/api/book/Root/Saga_v01.cbz/progress", map[string]interface{}{"page": 2, "completed": true})
+	books = onDeck()
+	if len(books) != 2 || books[0].Name != "Saga_v02.cbz" || books[0].Reason != "next" || books[1].Name != "Other.cbz" {
+		t.Fatalf("after finishing v01 = %+v", books)
+	}
+
+	// Once started, the next volume is in progress rather than next
+	authRequest(t, server, "GET", "/api/book/Root/Saga_v02.cbz/image/0", nil)
+	books = onDeck()
+	if len(books) != 2 || books[0].Name != "Saga_v02.cbz" || books[0].Reason != "continue" {
+		t.Fatalf("after starting v02 = %+v", books)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// CORSConfig lets web applications on other origins call the API from a browser
+type CORSConfig struct {
+	AllowedOrigins   []string `json:"allowedOrigins,omitempty"`   // Origins such as "https://reader.example.com"; "*" allows read-only access from any origin
+	AllowCredentials bool     `json:"allowCredentials,omitempty"` // Let listed origins send the session cookie
+}
+
+// bodyContentTypes lists the accepted request body types of routes that do not take JSON
+var bodyContentTypes = map[string][]string{
+	"/api/command/upload":      {"multipart/form-data"},
+	"/api/readinglists/import": {"multipart/form-data", "application/xml", "text/xml"},
+}
+
+// isStateChanging reports whether a method may modify server state
+func isStateChanging(method string) bool {
+	return method != "GET" && method != "HEAD" && method != "OPTIONS"
+}
+
+// corsOrigin returns the Access-Control-Allow-Origin value for an origin, or "" when it is not allowed
+func (s *Server) corsOrigin(origin string) string {
+	if s.config.CORS == nil || origin == "" {
+		return ""
+	}
+	result := ""
+	for _, allowed := range s.config.CORS.AllowedOrigins {
+		if strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return origin
+		}
+		if allowed == "*" {
+			result = "*"
+		}
+	}
+	return result
+}
+
+// isKnownHost reports whether a Host header names this server. IP addresses,
+// localhost and names that cannot be registered publicly (single labels, .local)
+// are always accepted; other names must be listed in allowedHosts. Refusing
+// unknown names stops DNS rebinding, where a page on another site resolves its
+// own name to this server so that the browser treats it as same-origin.
+func (s *Server) isKnownHost(host string) bool {
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		host = name
+	}
+	host = strings.ToLower(strings.TrimSuffix(strings.Trim(host, "[]"), "."))
+	if host == "" || net.ParseIP(host) != nil {
+		return true
+	}
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") || strings.HasSuffix(host, ".local") || !strings.Contains(host, ".") {
+		return true
+	}
+	for _, allowed := range s.config.AllowedHosts {
+		allowed = strings.ToLower(strings.TrimSuffix(allowed, "."))
+		if allowed == "*" || allowed == host || (strings.HasPrefix(allowed, "*.") && strings.HasSuffix(host, allowed[1:])) {
+			return true
+		}
+	}
+	return false
+}
+
+// isSameOrigin reports whether an Origin header names this server. Only the host
+// is compared so TLS-terminating proxies without forwarded headers still work.
+func (s *Server) isSameOrigin(r *http.Request, origin string) bool {
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	return strings.EqualFold(u.Host, s.requestHost(r))
+}
+
+// crossOriginAllowed reports whether a state-changing request may proceed. Browsers
+// mark cross-site requests with Sec-Fetch-Site or Origin; requests carrying
+// neither come from non-browser clients and cannot be forged by a web page.
+func (s *Server) crossOriginAllowed(r *http.Request) bool {
+	if _, ok := bearerToken(r); ok {
+		// Browsers never attach Authorization headers to cross-site requests on their own
+		return true
+	}
+	origin := r.Header.Get("Origin")
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "same-origin", "none":
+		return true
+	case "":
+		if origin == "" {
+			return true
+		}
+	}
+	if origin != "" && origin != "null" && s.isSameOrigin(r, origin) {
+		return true
+	}
+	// "*" only grants reads; mutations need an explicitly listed origin
+	allowed := s.corsOrigin(origin)
+	return allowed != "" && allowed != "*"
+}
+
+// checkContentType reports whether the body of a state-changing request has an accepted type.
+// Requiring JSON keeps HTML forms, which can only send simple types, from reaching the API.
+func checkContentType(r *http.Request) bool {
+	if r.ContentLength == 0 {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	accepted, ok := bodyContentTypes[r.URL.Path]
+	if !ok {
+		accepted = []string{"application/json"}
+	}
+	for _, contentType := range accepted {
+		if mediaType == contentType {
+			return true
+		}
+	}
+	return false
+}
+
+// originMiddleware rejects requests for unknown host names, answers CORS preflights, adds CORS headers for allowed origins and
+// rejects cross-site state-changing requests and bodies of unexpected types
+func (s *Server) originMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.isKnownHost(s.requestHost(r)) {
+			respondError(w, "Unknown host name; add it to allowedHosts", http.StatusMisdirectedRequest)
+			return
+		}
+		origin := r.Header.Get("Origin")
+		allowed := ""
+		if origin != "" && !s.isSameOrigin(r, origin) {
+			allowed = s.corsOrigin(origin)
+		}
+		if allowed != "" {
+			w.Header().Set("Access-Control-Allow-Origin", allowed)
+			w.Header().Add("Vary", "Origin")
+			if allowed != "*" && s.config.CORS.AllowCredentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+		}
+
+		if r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != "" {
+			if allowed == "" {
+				respondError(w, "Origin not allowed", http.StatusForbidden)
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, POST, PUT, DELETE")
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		if isStateChanging(r.Method) && strings.HasPrefix(r.URL.Path, "/api/") {
+			if !s.crossOriginAllowed(r) {
+				respondError(w, "Cross-origin request blocked", http.StatusForbidden)
+				return
+			}
+			if !checkContentType(r) {
+				respondError(w, "Unsupported Content-Type", http.StatusUnsupportedMediaType)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestOriginMiddlewareBlocksCrossSiteMutations(t *testing.T) {
+	server := newAuthTestServerWithConfig(t, &Config{
+		Roots: []RootConfig{{Path: t.TempDir(), Name: "Root"}},
+		CORS:  &CORSConfig{AllowedOrigins: []string{"https://reader.example.com", "*"}},
+	})
+	handler := server.handler()
+
+	request := func(method, target, contentType string, headers map[string]string) *httptest.ResponseRecorder {
+		body := ""
+		if contentType != "" {
+			body = `{"name":"list"}`
+		}
+		r := httptest.NewRequest(method, "http://localhost:8539"+target, strings.NewReader(body))
+		if contentType != "" {
+			r.Header.Set("Content-Type", contentType)
+		}
+		for name, value := range headers {
+			r.Header.Set(name, value)
+		}
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, r)
+		return response
+	}
+
+	tests := []struct {
+		name        string
+		contentType string
+		headers     map[string]string
+		want        int
+	}{
+		{"same origin", "application/json", map[string]string{"Origin": "http://localhost:8539", "Sec-Fetch-Site": "same-origin"}, http.StatusOK},
+		{"non-browser client", "application/json; charset=utf-8", nil, http.StatusOK},
+		{"drive-by from another site", "application/json", map[string]string{"Origin": "http://evil.example", "Sec-Fetch-Site": "cross-site"}, http.StatusForbidden},
+		{"other local port", "application/json", map[string]string{"Origin": "http://localhost:3000", "Sec-Fetch-Site": "same-site"}, http.StatusForbidden},
+		{"legacy browser without fetch metadata", "application/json", map[string]string{"Origin": "http://evil.example"}, http.StatusForbidden},
+		{"listed origin", "application/json", map[string]string{"Origin": "https://reader.example.com", "Sec-Fetch-Site": "cross-site"}, http.StatusOK},
+		{"form post", "application/x-www-form-urlencoded", map[string]string{"Sec-Fetch-Site": "same-origin"}, http.StatusUnsupportedMediaType},
+		{"text/plain", "text/plain", nil, http.StatusUnsupportedMediaType},
+		{"no body reaches the handler", "", nil, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if response := request("POST", "/api/collections", tt.contentType, tt.headers); response.Code != tt.want {
+			t.Errorf("%s = %d, want %d; body = %s", tt.name, response.Code, tt.want, response.Body.String())
+		}
+	}
+
+	// Restart and logout carry no body
+	if response := request("POST", "/api/auth/logout", "", map[string]string{"Sec-Fetch-Site": "same-origin"}); response.Code != http.StatusOK {
+		t.Errorf("empty body = %d", response.Code)
+	}
+
+	preflight := request("OPTIONS", "/api/collections", "", map[string]string{
+		"Origin": "https://reader.example.com", "Access-Control-Request-Method": "POST",
+	})
+	if preflight.Code != http.StatusNoContent || preflight.Header().Get("Access-Control-Allow-Origin") != "https://reader.example.com" {
+		t.Errorf("preflight = %d, %v", preflight.Code, preflight.Header())
+	}
+	read := request("GET", "/api/dir", "", map[string]string{"Origin": "https://any.example", "Sec-Fetch-Site": "cross-site"})
+	if read.Code != http.StatusOK || read.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Errorf("wildcard read = %d, %v", read.Code, read.Header())
+	}
+	if response := request("POST", "/api/collections", "application/json", map[string]string{"Origin": "https://any.example"}); response.Code != http.StatusForbidden {
+		t.Errorf("wildcard mutation = %d, want 403", response.Code)
+	}
+}
+
+func TestOriginMiddlewareRejectsUnknownHosts(t *testing.T) {
+	server := newAuthTestServerWithConfig(t, &Config{
+		Roots:        []RootConfig{{Path: t.TempDir(), Name: "Root"}},
+		AllowedHosts: []string{"comics.example.com", "*.home.example.net"},
+	})
+	handler := server.handler()
+
+	tests := []struct {
+		host string
+		want int
+	}{
+		{"localhost:8539", http.StatusOK},
+		{"192.168.0.10:8539", http.StatusOK},
+		{"[::1]:8539", http.StatusOK},
+		{"nas:8539", http.StatusOK},
+		{"nas.local", http.StatusOK},
+		{"comics.example.com", http.StatusOK},
+		{"Reader.Home.Example.Net:8539", http.StatusOK},
+		// A page on another site that resolved its own name to this server
+		{"rebind.evil.example:8539", http.StatusMisdirectedRequest},
+		{"home.example.net", http.StatusMisdirectedRequest},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/api/dir", nil)
+		r.Host = tt.host
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, r)
+		if response.Code != tt.want {
+			t.Errorf("%s = %d, want %d", tt.host, response.Code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+const (
+	maxPreferences           = 64
+	maxPreferenceKeyLength   = 64
+	maxPreferenceValueLength = 1024
+)
+
+// PreferenceStore persists client settings (theme, zoom, ...) per user in the
+// data directory as free-form strings. Without accounts every client shares the "" user.
+type PreferenceStore struct {
+	mu    sync.Mutex
+	path  string
+	Users map[string]map[string]string `json:"users"`
+}
+
+func loadPreferenceStore(path string) *PreferenceStore {
+	store := &PreferenceStore{path: path, Users: make(map[string]map[string]string)}
+	if err := readJSONFile(path, store); err != nil {
+		logStoreLoadError("preferences", err)
+	}
+	if store.Users == nil {
+		store.Users = make(map[string]map[string]string)
+	}
+	return store
+}
+
+// save writes the store; callers must hold mu
+func (p *PreferenceStore) save() error {
+	return writeJSONFile(p.path, p)
+}
+
+// get copies the preferences of a user
+func (p *PreferenceStore) get(user string) map[string]string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	result := make(map[string]string, len(p.Users[user]))
+	for key, value := range p.Users[user] {
+		result[key] = value
+	}
+	return result
+}
+
+// validatePreferences checks changed preferences against the limits, counting
+// the keys the user would end up with
+func validatePreferences(current map[string]string, changes map[string]*string) error {
+	count := len(current)
+	for key, value := range changes {
+		if key == "" || len(key) > maxPreferenceKeyLength {
+			return fmt.Errorf("Preference names must be 1 to %d bytes", maxPreferenceKeyLength)
+		}
+		if value != nil && len(*value) > maxPreferenceValueLength {
+			return fmt.Errorf("Preference %q is longer than %d bytes", key, maxPreferenceValueLength)
+		}
+		_, exists := current[key]
+		if value != nil && !exists {
+			count++
+		} else if value == nil && exists {
+			count--
+		}
+	}
+	if count > maxPreferences {
+		return fmt.Errorf("At most %d preferences can be stored", maxPreferences)
+	}
+	return nil
+}
+
+// update sets the given preferences of a user; nil values remove them. It returns
+// the preferences now stored.
+func (p *PreferenceStore) update(user string, changes map[string]*string) (map[string]string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := validatePreferences(p.Users[user], changes); err != nil {
+		return nil, err
+	}
+	preferences, ok := p.Users[user]
+	if !ok {
+		preferences = make(map[string]string)
+		p.Users[user] = preferences
+	}
+	for key, value := range changes {
+		if value == nil {
+			delete(preferences, key)
+		} else {
+			preferences[key] = *value
+		}
+	}
+	result := make(map[string]string, len(preferences))
+	for key, value := range preferences {
+		result[key] = value
+	}
+	return result, p.save()
+}
+
+// removeUser deletes the preferences of a deleted account
+func (p *PreferenceStore) removeUser(user string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.Users[user]; !ok {
+		return
+	}
+	delete(p.Users, user)
+	if err := p.save(); err != nil {
+		logStoreError("preferences", err)
+	}
+}
+
+// handlePreferences returns (GET) or changes (PUT) the requester's preferences.
+// PUT takes an object of names to string values, or null to remove a preference.
+func (s *Server) handlePreferences(w http.ResponseWriter, r *http.Request) {
+	user := requestUserName(r)
+	preferences := s.preferences.get(user)
+	if r.Method == "PUT" {
+		var changes map[string]*string
+		if err := json.NewDecoder(r.Body).Decode(&changes); err != nil {
+			respondError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if err := validatePreferences(preferences, changes); err != nil {
+			respondError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var err error
+		if preferences, err = s.preferences.update(user, changes); err != nil {
+			respondError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	respondJSON(w, struct {
+		Preferences map[string]string `json:"preferences"`
+	}{preferences})
+}
@@ -0,0 +1,432 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// progressViewSaveInterval limits how often page views are written back to disk
+const progressViewSaveInterval = time.Minute
+
+// Progress is the reading position of one user in one book
+type Progress struct {
+	Page      int       `json:"page"`
+	Offset    int       `json:"offset"`
+	Direction string    `json:"direction,omitempty"` // "rtl" or "ltr"
+	Completed bool      `json:"completed"`
+	Pages     int       `json:"pages,omitempty"`  // Page count of the book when known
+	Device    string    `json:"device,omitempty"` // Free-form name of the device that saved it
+	Updated   time.Time `json:"updated"`
+}
+
+// progressRecord is stored progress with the last known location of the book
+type progressRecord struct {
+	Progress
+	Path     string            `json:"path"`
+	KOReader *koreaderPosition `json:"koreader,omitempty"` // Set when KOReader saved the progress
+	Viewed   time.Time         `json:"viewed,omitempty"`   // Last time a page of the book was served
+	Inferred bool              `json:"inferred,omitempty"` // Page taken from page requests; no client reported progress
+}
+
+// lastRead is when the book was last opened or its progress last changed
+func (r *progressRecord) lastRead() time.Time {
+	if r.Viewed.After(r.Updated) {
+		return r.Viewed
+	}
+	return r.Updated
+}
+
+// ProgressStore persists reading progress per user and book ID in the data directory.
+// Without accounts every client shares the "" user.
+type ProgressStore struct {
+	mu       sync.Mutex
+	path     string
+	Users    map[string]map[string]*progressRecord `json:"users"` // user -> book ID -> progress
+	lastSave time.Time
+	dirty    bool                                     // page views not yet written
+	timer    *time.Timer                              // pending write of page views
+	onChange func(user, path string, state readState) // Called with mu held after progress in a book changes
+}
+
+func loadProgressStore(path string) *ProgressStore {
+	store := &ProgressStore{path: path, Users: make(map[string]map[string]*progressRecord)}
+	if err := readJSONFile(path, store); err != nil {
+		logStoreLoadError("progress", err)
+	}
+	if store.Users == nil {
+		store.Users = make(map[string]map[string]*progressRecord)
+	}
+	return store
+}
+
+// changed reports the state of a book to onChange; callers must hold mu
+func (p *ProgressStore) changed(user string, record *progressRecord) {
+	if p.onChange != nil && record.Path != "" {
+		p.onChange(user, record.Path, readStateOf(record))
+	}
+}
+
+// save writes the store; callers must hold mu
+func (p *ProgressStore) save() error {
+	p.lastSave, p.dirty = time.Now(), false
+	return writeJSONFile(p.path, p)
+}
+
+// flush writes page views that were held back by progressViewSaveInterval
+func (p *ProgressStore) flush() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+	if !p.dirty {
+		return
+	}
+	if err := p.save(); err != nil {
+		logStoreError("progress", err)
+	}
+}
+
+// get returns the progress of a user in a book
+func (p *ProgressStore) get(user, id string) (progressRecord, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	record, ok := p.Users[user][id]
+	if !ok {
+		return progressRecord{}, false
+	}
+	return *record, true
+}
+
+// forUser copies every progress record of a user, keyed by book ID
+func (p *ProgressStore) forUser(user string) map[string]progressRecord {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	result := make(map[string]progressRecord, len(p.Users[user]))
+	for id, record := range p.Users[user] {
+		result[id] = *record
+	}
+	return result
+}
+
+// books returns the progress map of a user, creating it; callers must hold mu
+func (p *ProgressStore) books(user string) map[string]*progressRecord {
+	books, ok := p.Users[user]
+	if !ok {
+		books = make(map[string]*progressRecord)
+		p.Users[user] = books
+	}
+	return books
+}
+
+// supersedes reports whether a write replaces the current progress: last write
+// wins by Updated, and progress inferred from page requests always yields
+func (record *progressRecord) supersedes(current *progressRecord) bool {
+	return current == nil || current.Inferred || !current.Updated.After(record.Updated)
+}
+
+// put stores progress unless a newer write already exists (see supersedes). It
+// returns the progress now stored and whether the write was applied.
+func (p *ProgressStore) put(user, id string, record progressRecord) (progressRecord, bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stored, accepted := p.apply(user, id, record)
+	if !accepted {
+		return stored, false, nil
+	}
+	return stored, true, p.save()
+}
+
+// apply stores progress unless a newer write already exists; callers must hold mu
+func (p *ProgressStore) apply(user, id string, record progressRecord) (progressRecord, bool) {
+	books := p.books(user)
+	if current, ok := books[id]; ok {
+		if !record.supersedes(current) {
+			return *current, false
+		}
+		if record.Viewed.Before(current.Viewed) {
+			record.Viewed = current.Viewed
+		}
+	}
+	books[id] = &record
+	p.changed(user, &record)
+	return record, true
+}
+
+// putAll stores several progress records (book IDs in ids, parallel to records)
+// with one write, as put would one by one. With dryRun nothing is changed. It
+// reports which records were, or would be, accepted.
+func (p *ProgressStore) putAll(user string, ids []string, records []progressRecord, dryRun bool) ([]bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	accepted := make([]bool, len(records))
+	if dryRun {
+		pending := make(map[string]*progressRecord)
+		for i := range records {
+			current, ok := pending[ids[i]]
+			if !ok {
+				current = p.Users[user][ids[i]]
+			}
+			if accepted[i] = current == nil || records[i].supersedes(current); accepted[i] {
+				pending[ids[i]] = &records[i]
+			}
+		}
+		return accepted, nil
+	}
+	changed := false
+	for i := range records {
+		_, accepted[i] = p.apply(user, ids[i], records[i])
+		changed = changed || accepted[i]
+	}
+	if !changed {
+		return accepted, nil
+	}
+	return accepted, p.save()
+}
+
+// view records that a user was served a page of a book. The page is only taken
+// over while no client has reported progress for the book, since readers fetch
+// pages ahead. Views are written to disk at most once per progressViewSaveInterval;
+// skipped writes are made once the interval has passed.
+func (p *ProgressStore) view(user, id, path string, page, pages int, now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	books := p.books(user)
+	record, ok := books[id]
+	if !ok {
+		record = &progressRecord{Inferred: true}
+		books[id] = record
+	}
+	record.Path = path
+	record.Viewed = now
+	if record.Inferred {
+		record.Page, record.Pages, record.Updated = page, pages, now
+		record.Completed = record.Completed || page == pages-1
+	}
+	p.changed(user, record)
+	if now.Sub(p.lastSave) >= progressViewSaveInterval {
+		if err := p.save(); err != nil {
+			logStoreError("progress", err)
+		}
+		return
+	}
+	// Written later, so the last pages read are not lost when views stop
+	p.dirty = true
+	if p.timer == nil {
+		p.timer = time.AfterFunc(progressViewSaveInterval, p.flush)
+	}
+}
+
+// remove deletes the progress of a user in a book
+func (p *ProgressStore) remove(user, id string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	record, ok := p.Users[user][id]
+	if !ok {
+		return nil
+	}
+	delete(p.Users[user], id)
+	if p.onChange != nil && record.Path != "" {
+		p.onChange(user, record.Path, readStateUnread)
+	}
+	return p.save()
+}
+
+// setRead marks books (ID -> request path) read or unread. Unread books lose their
+// progress; books marked read keep their page.
+func (p *ProgressStore) setRead(user string, books map[string]string, read bool, now time.Time) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	records := p.books(user)
+	for id, path := range books {
+		record, ok := records[id]
+		if !read {
+			if ok {
+				delete(records, id)
+				if p.onChange != nil {
+					p.onChange(user, path, readStateUnread)
+				}
+			}
+			continue
+		}
+		if !ok {
+			record = &progressRecord{}
+			records[id] = record
+		}
+		record.Path, record.Completed, record.Inferred, record.Updated = path, true, false, now
+		p.changed(user, record)
+	}
+	return p.save()
+}
+
+// removeUser deletes all progress of a deleted account
+func (p *ProgressStore) removeUser(user string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.Users[user]; !ok {
+		return
+	}
+	delete(p.Users, user)
+	if err := p.save(); err != nil {
+		logStoreError("progress", err)
+	}
+}
+
+// movePath keeps the recorded locations of books current after a rename or move
+func (p *ProgressStore) movePath(oldPath, newPath string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	changed := false
+	for _, books := range p.Users {
+		for _, record := range books {
+			if moved, ok := movedPath(record.Path, oldPath, newPath); ok {
+				record.Path, changed = moved, true
+			}
+		}
+	}
+	if changed {
+		if err := p.save(); err != nil {
+			logStoreError("progress", err)
+		}
+	}
+}
+
+// bookPageCount returns the number of pages of a book, or 0 when it cannot be read
+func (s *Server) bookPageCount(resolved *ResolvedPath) int {
+	images, err := s.getImagesFromBook(resolved.FullPath)
+	if err != nil {
+		return 0
+	}
+	return len(images)
+}
+
+// recordPageView notes that the requester was served a page of a book in their
+// progress and reading history. Visitors of share links have neither.
+func (s *Server) recordPageView(r *http.Request, resolved *ResolvedPath, page, pages int) {
+	if requestShare(r) != nil {
+		return
+	}
+	id, err := s.bookID(resolved)
+	if err != nil {
+		return
+	}
+	user, now := requestUserName(r), time.Now()
+	s.progress.view(user, id, resolved.RequestPath(), page, pages, now)
+	s.recordHistory(user, id, resolved, page, pages, now)
+}
+
+// attachProgress adds the requester's progress to listed books with known IDs
+func (s *Server) attachProgress(r *http.Request, items []fileItem) {
+	progress := s.progress.forUser(requestUserName(r))
+	if len(progress) == 0 {
+		return
+	}
+	for i := range items {
+		if items[i].ID == "" {
+			continue
+		}
+		if record, ok := progress[items[i].ID]; ok {
+			items[i].Progress = &record.Progress
+		}
+	}
+}
+
+// handleBookProgress gets (GET), saves (PUT) or clears (DELETE) the reading
+// progress of the requester in a book
+func (s *Server) handleBookProgress(w http.ResponseWriter, r *http.Request) {
+	requestPath, _ := url.PathUnescape(mux.Vars(r)["path"])
+	resolved, err := s.resolveRequestPath(r, requestPath)
+	if err != nil {
+		respondError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	id, err := s.bookID(resolved)
+	if err != nil {
+		respondError(w, "file not found", http.StatusNotFound)
+		return
+	}
+	user := requestUserName(r)
+
+	switch r.Method {
+	case "GET":
+		record, ok := s.progress.get(user, id)
+		if !ok {
+			respondError(w, "No progress for this book", http.StatusNotFound)
+			return
+		}
+		respondJSON(w, record.Progress)
+
+	case "PUT":
+		var req Progress
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if req.Page < -1 {
+			respondError(w, "Page must be -1 or greater", http.StatusBadRequest)
+			return
+		}
+		if req.Direction != "" && req.Direction != "rtl" && req.Direction != "ltr" {
+			respondError(w, "Direction must be rtl or ltr", http.StatusBadRequest)
+			return
+		}
+		now := time.Now()
+		if req.Updated.IsZero() || req.Updated.After(now) {
+			// Clock skew must not let one device win every future conflict
+			req.Updated = now
+		}
+		req.Pages = s.bookPageCount(resolved)
+		record, accepted, err := s.progress.put(user, id, progressRecord{Progress: req, Path: resolved.RequestPath()})
+		if err != nil {
+			respondError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// The viewer shows read-ahead pages from its cache, so its progress reports
+		// are what adds those pages to the history
+		if page := req.Page + req.Offset; page >= 0 && page < req.Pages {
+			s.recordHistory(user, id, resolved, page, req.Pages, now)
+		}
+		respondJSON(w, struct {
+			Progress
+			Accepted bool `json:"accepted"` // false when a newer write from another device was kept
+		}{record.Progress, accepted})
+
+	case "DELETE":
+		if err := s.progress.remove(user, id); err != nil {
+			respondError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		respondJSON(w, map[string]string{"status": "ok"})
+	}
+}
+
+// progressItem is a book in the progress list
+type progressItem struct {
+	ID   string `json:"id"`
+	Path string `json:"path"`
+	Progress
+}
+
+// handleProgress lists the requester's progress in every visible book, most recent first
+func (s *Server) handleProgress(w http.ResponseWriter, r *http.Request) {
+	access := s.requestAccess(r)
+	items := make([]progressItem, 0)
+	for id, record := range s.progress.forUser(requestUserName(r)) {
+		// Documents outside the library synced by KOReader have no path
+		if record.Path != "" && access.canSeePath(record.Path) {
+			items = append(items, progressItem{ID: id, Path: record.Path, Progress: record.Progress})
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Updated.After(items[j].Updated) })
+	respondJSON(w, struct {
+		Books []progressItem `json:"books"`
+	}{items})
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestProgressLastWriteWins(t *testing.T) {
+	root := t.TempDir()
+	writeTestZip(t, filepath.Join(root, "vol1.cbz"), zip.Store, map[string]string{"01.jpg": "a", "02.jpg": "b"}, []string{"01.jpg", "02.jpg"})
+	server := newAuthTestServerWithConfig(t, &Config{Roots: []RootConfig{{Path: root, Name: "Root"}}})
+
+	put := func(body map[string]interface{}) (Progress, bool) {
+		t.Helper()
+		response := authRequest(t, server, "PUT", "/api/book/Root/vol1.cbz/progress", body)
+		var result struct {
+			Progress
+			Accepted bool `json:"accepted"`
+		}
+		if err := json.Unmarshal(response.Body.Bytes(), &result); err != nil || response.Code != http.StatusOK {
+			t.Fatalf("put = %d; body = %s", response.Code, response.Body.String())
+		}
+		return result.Progress, result.Accepted
+	}
+
+	if response := authRequest(t, server, "GET", "/api/book/Root/vol1.cbz/progress", nil); response.Code != http.StatusNotFound {
+		t.Fatalf("initial progress = %d, want 404", response.Code)
+	}
+	ipadTime := time.Now().Add(-time.Minute).UTC().Truncate(time.Second)
+	if _, accepted := put(map[string]interface{}{"page": 12, "offset": 1, "direction": "ltr", "device": "iPad", "updated": ipadTime}); !accepted {
+		t.Fatal("first write rejected")
+	}
+	// A stale write from another device does not overwrite newer progress
+	stale, accepted := put(map[string]interface{}{"page": 3, "device": "Phone", "updated": ipadTime.Add(-time.Hour)})
+	if accepted || stale.Page != 12 || stale.Device != "iPad" {
+		t.Fatalf("stale write = %+v, accepted %v", stale, accepted)
+	}
+	if _, accepted := put(map[string]interface{}{"page": 14, "completed": true, "device": "Phone"}); !accepted {
+		t.Fatal("newer write rejected")
+	}
+	if response := authRequest(t, server, "PUT", "/api/book/Root/vol1.cbz/progress", map[string]interface{}{"direction": "up"}); response.Code != http.StatusBadRequest {
+		t.Fatalf("invalid direction = %d", response.Code)
+	}
+
+	var book struct {
+		Progress *Progress `json:"progress"`
+	}
+	response := authRequest(t, server, "GET", "/api/book/Root/vol1.cbz/list", nil)
+	if err := json.Unmarshal(response.Body.Bytes(), &book); err != nil || book.Progress == nil || book.Progress.Page != 14 || !book.Progress.Completed {
+		t.Fatalf("book list progress = %+v; body = %s", book.Progress, response.Body.String())
+	}
+
+	var dir struct {
+		Files []fileItem `json:"files"`
+	}
+	response = authRequest(t, server, "GET", "/api/dir/Root", nil)
+	if err := json.Unmarshal(response.Body.Bytes(), &dir); err != nil || len(dir.Files) != 1 || dir.Files[0].Progress == nil || dir.Files[0].Progress.Page != 14 {
+		t.Fatalf("dir listing = %s", response.Body.String())
+	}
+
+	// Progress is per user
+	admin := sessionCookie(t, authRequest(t, server, "POST", "/api/settings/users", map[string]interface{}{"name": "admin", "password": "adminpass"}))
+	if response := authRequest(t, server, "GET", "/api/book/Root/vol1.cbz/progress", nil, admin); response.Code != http.StatusNotFound {
+		t.Fatalf("other user's progress = %d, want 404", response.Code)
+	}
+}
+
+func TestProgressViewsAreFlushed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "progress.json")
+	store := loadProgressStore(path)
+	now := time.Now()
+	store.view("", "book", "Root/vol1.cbz", 0, 10, now)
+	store.view("", "book", "Root/vol1.cbz", 4, 10, now.Add(time.Second))
+	if record, _ := loadProgressStore(path).get("", "book"); record.Page != 0 {
+		t.Fatalf("throttled view saved at once: page %d", record.Page)
+	}
+	store.mu.Lock()
+	scheduled := store.timer != nil
+	store.mu.Unlock()
+	if !scheduled {
+		t.Fatal("no delayed save scheduled")
+	}
+	// Shutdown writes what the throttle held back
+	store.flush()
+	if record, _ := loadProgressStore(path).get("", "book"); record.Page != 4 {
+		t.Fatalf("flushed page = %d, want 4", record.Page)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// parseCIDRs parses CIDR ranges; single addresses are treated as /32 or /128
+func parseCIDRs(values []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(values))
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address: %s", value)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR: %s", value)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// mustParseCIDRs parses a config list, logging and skipping it when invalid
+func mustParseCIDRs(name string, values []string) []*net.IPNet {
+	networks, err := parseCIDRs(values)
+	if err != nil {
+		log.Printf("Warning: ignoring %s: %v", name, err)
+		return nil
+	}
+	return networks
+}
+
+// containsIP reports whether ip lies in any of the networks
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteIP returns the address of the directly connected peer
+func remoteIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// clientIP returns the address of the client. Behind trusted proxies it is the
+// right-most X-Forwarded-For entry that is not itself a trusted proxy, so clients
+// cannot spoof it by sending their own header.
+func (s *Server) clientIP(r *http.Request) net.IP {
+	ip := remoteIP(r)
+	if ip == nil || !containsIP(s.trustedProxies, ip) {
+		return ip
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if !containsIP(s.trustedProxies, hop) {
+			break
+		}
+	}
+	return ip
+}
+
+// fromTrustedProxy reports whether the request was forwarded by a configured trusted proxy
+func (s *Server) fromTrustedProxy(r *http.Request) bool {
+	ip := remoteIP(r)
+	return ip != nil && containsIP(s.trustedProxies, ip)
+}
+
+// forwardedHeader returns the first value of a proxy header, only for trusted proxies
+func (s *Server) forwardedHeader(r *http.Request, name string) string {
+	if !s.fromTrustedProxy(r) {
+		return ""
+	}
+	value, _, _ := strings.Cut(r.Header.Get(name), ",")
+	return strings.TrimSpace(value)
+}
+
+// requestScheme returns the scheme the client used, honouring X-Forwarded-Proto from trusted proxies
+func (s *Server) requestScheme(r *http.Request) string {
+	if proto := strings.ToLower(s.forwardedHeader(r, "X-Forwarded-Proto")); proto == "http" || proto == "https" {
+		return proto
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// requestHost returns the host the client used, honouring X-Forwarded-Host from trusted proxies
+func (s *Server) requestHost(r *http.Request) string {
+	if host := s.forwardedHeader(r, "X-Forwarded-Host"); host != "" {
+		return host
+	}
+	return r.Host
+}
+
+// proxyAuthEnabled reports whether usernames are taken from a trusted proxy header
+func (s *Server) proxyAuthEnabled() bool {
+	return s.config.Auth != nil && s.config.Auth.ProxyHeader != "" && len(s.trustedProxies) > 0
+}
+
+// authEnabled reports whether requests must identify a user
+func (s *Server) authEnabled() bool {
+	return s.users.enabled() || s.proxyAuthEnabled()
+}
+
+// proxyUser returns the user named by the trusted proxy header. Names matching a
+// stored account use that account; other names get a non-admin user whose groups
+// come from the groups header.
+func (s *Server) proxyUser(r *http.Request) (*User, bool) {
+	if !s.proxyAuthEnabled() || !s.fromTrustedProxy(r) {
+		return nil, false
+	}
+	name := strings.TrimSpace(r.Header.Get(s.config.Auth.ProxyHeader))
+	if name == "" {
+		return nil, false
+	}
+
+	s.users.mu.Lock()
+	stored, ok := s.users.Users[name]
+	var user User
+	if ok {
+		user = *stored
+	}
+	s.users.mu.Unlock()
+	if ok {
+		return &user, true
+	}
+
+	user = User{Name: name}
+	if header := s.config.Auth.ProxyGroupsHeader; header != "" {
+		user.Groups = normalizeGroups(strings.Split(r.Header.Get(header), ","))
+	}
+	return &user, true
+}
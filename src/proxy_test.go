@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProxyAuthFromTrustedProxyOnly(t *testing.T) {
+	comics, adult := t.TempDir(), t.TempDir()
+	if err := os.WriteFile(filepath.Join(comics, "movie.mp4"), []byte("video"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	server := newAuthTestServerWithConfig(t, &Config{
+		Roots:          []RootConfig{{Path: comics, Name: "Comics"}, {Path: adult, Name: "Adult"}},
+		TrustedProxies: []string{"10.0.0.0/8", "::1"},
+		Auth:           &AuthConfig{ProxyHeader: "Remote-User", ProxyGroupsHeader: "Remote-Groups"},
+		Groups:         map[string]AccessRule{"kids": {Roots: []string{"Comics"}}},
+	})
+
+	proxied := func(remoteAddr, target string, headers map[string]string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest("GET", target, nil)
+		request.RemoteAddr = remoteAddr
+		for name, value := range headers {
+			request.Header.Set(name, value)
+		}
+		response := httptest.NewRecorder()
+		server.router.ServeHTTP(response, request)
+		return response
+	}
+	kid := map[string]string{"Remote-User": "kid", "Remote-Groups": "kids, readers"}
+
+	response := proxied("10.1.2.3:5000", "/api/dir", kid)
+	var roots struct {
+		Files []fileItem `json:"files"`
+		User  string     `json:"user"`
+	}
+	if err := json.Unmarshal(response.Body.Bytes(), &roots); err != nil {
+		t.Fatalf("%v; body = %s", err, response.Body.String())
+	}
+	if roots.User != "kid" || len(roots.Files) != 1 || roots.Files[0].Name != "Comics" {
+		t.Fatalf("proxied roots = %+v", roots)
+	}
+
+	// Spoofed header from outside the trusted range is ignored
+	if response := proxied("192.168.1.20:5000", "/api/dir", kid); response.Code != http.StatusUnauthorized {
+		t.Fatalf("untrusted proxy = %d, want 401", response.Code)
+	}
+
+	response = proxied("[::1]:5000", "/api/media-url/Comics/movie.mp4", map[string]string{
+		"Remote-User": "kid", "Remote-Groups": "kids", "X-Forwarded-Proto": "https", "X-Forwarded-Host": "comics.example.com",
+	})
+	var media struct {
+		URL string `json:"url"`
+	}
+	if err := json.Unmarshal(response.Body.Bytes(), &media); err != nil {
+		t.Fatalf("%v; body = %s", err, response.Body.String())
+	}
+	if want := "https://comics.example.com/api/file/Comics%2Fmovie.mp4"; media.URL != want {
+		t.Fatalf("media url = %q, want %q", media.URL, want)
+	}
+}
@@ -0,0 +1,33 @@
+// ログイン後の戻り先（同一オリジンのパスのみ許可）
+function getNextUrl() {
+    const next = new URLSearchParams(window.location.search).get('next');
+    if (next && next.startsWith('/') && !next.startsWith('//')) {
+        return next;
+    }
+    return '/';
+}
+
+document.getElementById('login-form').addEventListener('submit', async (e) => {
+    e.preventDefault();
+    const message = document.getElementById('message');
+    message.style.display = 'none';
+
+    try {
+        const res = await fetch('/api/auth/login', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({
+                name: document.getElementById('name').value,
+                password: document.getElementById('password').value
+            })
+        });
+        const data = await res.json();
+        if (!res.ok) {
+            throw new Error(data.error || 'Login failed');
+        }
+        location.href = getNextUrl();
+    } catch (err) {
+        message.textContent = err.message;
+        message.style.display = 'block';
+    }
+});
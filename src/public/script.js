@@ -35,6 +35,7 @@ let currentRootName = null;
 let currentRelativePath = '';
 let allowFileOperations = false;
 let allowUpload = false;
+let allowShare = false;
 let disableGUI = false;
 
 const TRANSFER_MIME = 'application/x-litecomics-item';
@@ -853,6 +854,19 @@ function createContextMenu(file) {
     });
   }
 
+  // 既読/未読（本とフォルダ）
+  if (file.type === 'book' || file.type === 'directory') {
+    const read = file.type === 'book'
+      ? file.progress && file.progress.completed
+      : file.books && file.books.total > 0 && file.books.read === file.books.total;
+    addMenuItem(read ? 'Mark as unread' : 'Mark as read', () => markRead(file, !read));
+  }
+
+  // Share link (read-only access to this book or folder only)
+  if (allowShare) {
+    addMenuItem('Share link', () => createShareLink(file));
+  }
+
   // Rename
   if (allowFileOperations) {
     addMenuItem('Rename', () => renameFile(file));
@@ -1027,12 +1041,45 @@ function changeZoom(delta) {
   zoomLevel = Math.max(50, Math.min(200, zoomLevel + delta));
   applyZoom();
   localStorage.setItem('zoomLevel', zoomLevel);
+  savePreference('zoomLevel', zoomLevel);
 }
 
 function resetZoom() {
   zoomLevel = 100;
   applyZoom();
   localStorage.setItem('zoomLevel', zoomLevel);
+  savePreference('zoomLevel', zoomLevel);
+}
+
+// 設定をサーバーにも保存（別のブラウザへ引き継ぐため）
+function savePreference(key, value) {
+  if (window.location.pathname.split('/').includes('__demo__')) return;
+  fetch('/api/preferences', {
+    method: 'PUT',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify({ [key]: String(value) })
+  }).catch(err => console.error('Failed to save preference:', err));
+}
+
+// サーバーに保存された設定をこのブラウザに反映
+async function loadPreferences() {
+  if (window.location.pathname.split('/').includes('__demo__')) return;
+  try {
+    const response = await fetch('/api/preferences');
+    if (!response.ok) return;
+    const { preferences } = await response.json();
+    if (preferences.theme && preferences.theme !== localStorage.getItem('theme')) {
+      localStorage.setItem('theme', preferences.theme);
+      document.body.setAttribute('data-theme', preferences.theme);
+      updateThemeIcon();
+    }
+    if (preferences.zoomLevel && preferences.zoomLevel !== localStorage.getItem('zoomLevel')) {
+      localStorage.setItem('zoomLevel', preferences.zoomLevel);
+      initZoom();
+    }
+  } catch (err) {
+    console.error('Failed to load preferences:', err);
+  }
 }
 
 // テーマの初期化
@@ -1064,6 +1111,7 @@ function toggleTheme() {
   const newTheme = currentTheme === 'light' ? 'dark' : 'light';
   document.body.setAttribute('data-theme', newTheme);
   localStorage.setItem('theme', newTheme);
+  savePreference('theme', newTheme);
   updateThemeIcon();
 }
 
@@ -1151,6 +1199,99 @@ function getCurrentDirParam() {
   return hash ? decodeURIComponent(hash.substring(1)) : null;
 }
 
+// セッション切れ時はログイン画面へ（埋め込みペインからはページ全体を遷移）
+function redirectToLogin() {
+  const location = window.top.location;
+  const next = location.pathname + location.search + location.hash;
+  location.href = `/login/?next=${encodeURIComponent(next)}`;
+}
+
+// ログアウト
+async function createShareLink(file) {
+  const expires = await showPromptDialog(`Share "${file.name}" for how long? (e.g. 24h, 168h)`, '168h');
+  if (expires === null) return;
+  try {
+    const response = await fetch('/api/shares', {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ path: file.path, expires: expires.trim() }),
+    });
+    const result = await response.json();
+    if (!response.ok) {
+      throw new Error(result.error || 'Failed to create share link');
+    }
+    showPromptDialog('Share this link:', result.url);
+  } catch (err) {
+    alert('Error: ' + err.message);
+  }
+}
+
+// 本またはフォルダ内のすべての本を既読/未読にする
+async function markRead(file, read) {
+  try {
+    const response = await fetch(fixUrl('/api/progress/read'), {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ paths: [file.path], read })
+    });
+    const result = await response.json();
+    if (result.error) {
+      alert(`Error: ${result.error}`);
+      return;
+    }
+    await loadFileList(getCurrentDirParam());
+  } catch (err) {
+    alert(`Failed to update read state: ${err.message}`);
+  }
+}
+
+// 進捗・ブックマーク・履歴・設定をJSONファイルとしてダウンロード
+function exportUserData() {
+  if (window.location.pathname.split('/').includes('__demo__')) return;
+  window.location.href = '/api/userdata';
+}
+
+// このブラウザのlocalStorageにある読書位置・履歴・設定をサーバーへ取り込む
+async function importBrowserData() {
+  if (window.location.pathname.split('/').includes('__demo__')) return;
+  const storage = {};
+  for (let i = 0; i < localStorage.length; i++) {
+    const key = localStorage.key(i);
+    if (/^viewer_(page|offset|direction|updated)_/.test(key) || ['file_history', 'theme', 'zoomLevel'].includes(key)) {
+      storage[key] = localStorage.getItem(key);
+    }
+  }
+  const send = async (dryRun) => {
+    const response = await fetch(`/api/userdata/import${dryRun ? '?dryRun=true' : ''}`, {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ version: 1, localStorage: storage })
+    });
+    const result = await response.json();
+    if (result.error) throw new Error(result.error);
+    return result;
+  };
+
+  try {
+    // まず試行して、取り込める件数と本が見つからない件数を確認する
+    const report = await send(true);
+    let message = `Import ${report.progress} reading positions, ${report.history} history entries and ${report.preferences} settings from this browser?`;
+    if (report.unmatched.length > 0) {
+      message += ` ${report.unmatched.length} entries do not match a book in the library and will be skipped.`;
+    }
+    if (!await showConfirmDialog(message, { confirmLabel: 'Import' })) return;
+    await send(false);
+    alert('Browser data imported');
+  } catch (err) {
+    alert(`Failed to import browser data: ${err.message}`);
+  }
+}
+
+async function logout() {
+  await fetch('/api/auth/logout', { method: 'POST' });
+  redirectToLogin();
+}
+
 // ファイル一覧を取得して表示
 async function loadFileList(dirPath = null) {
   const fileListDiv = document.getElementById('file-list');
@@ -1162,6 +1303,10 @@ async function loadFileList(dirPath = null) {
     // API call: /api/dir with optional path
     const apiUrl = `/api/dir${dirPath ? `/${encodeURIComponent(dirPath)}` : ''}`;
     const response = await fetch(fixUrl(apiUrl));
+    if (response.status === 401) {
+      redirectToLogin();
+      return;
+    }
     const data = await response.json();
 
     // Handle error response
@@ -1175,12 +1320,18 @@ async function loadFileList(dirPath = null) {
     currentRelativePath = data.relativePath || '';
     allowFileOperations = data.allowFileOperations || false;
     allowUpload = data.allowUpload || false;
+    allowShare = data.allowShare || false;
     disableGUI = data.disableGUI || false;
 
     // Update settings menu visibility
     const settingsMenu = document.getElementById('menu-settings');
     if (settingsMenu) {
-      settingsMenu.style.display = disableGUI ? 'none' : '';
+      settingsMenu.style.display = disableGUI || data.allowSettings === false ? 'none' : '';
+    }
+    const logoutMenu = document.getElementById('menu-logout');
+    if (logoutMenu) {
+      logoutMenu.style.display = data.user ? '' : 'none';
+      document.getElementById('logout-label').textContent = data.user ? `Log Out (${data.user})` : 'Log Out';
     }
 
     fileListDiv.innerHTML = '';
@@ -1242,6 +1393,11 @@ async function loadFileList(dirPath = null) {
     createListSection(filesByType.audio);
     createListSection(filesByType.file);
 
+    // ルート一覧では続きから読める本を表示
+    if (!dirPath) {
+      loadOnDeck(fileListDiv);
+    }
+
     // パンくずリストを更新
     updateBreadcrumb();
 
@@ -1270,6 +1426,66 @@ async function loadFileList(dirPath = null) {
   }
 }
 
+// 読みかけの本と次の巻の棚をファイル一覧の先頭に追加
+async function loadOnDeck(fileListDiv) {
+  try {
+    const response = await fetch(fixUrl('/api/ondeck'));
+    if (!response.ok) return;
+    const data = await response.json();
+    if (!data.books || data.books.length === 0) return;
+
+    const heading = document.createElement('div');
+    heading.className = 'ondeck-heading';
+    heading.textContent = 'Continue Reading';
+    const section = document.createElement('div');
+    section.className = 'tile-section ondeck-section';
+
+    data.books.forEach((book) => {
+      const tile = document.createElement('div');
+      tile.className = 'tile-item';
+
+      const link = document.createElement('a');
+      link.href = fixUrl(`/viewer/#${encodeURIComponent(book.path)}`);
+      link.draggable = false;
+      link.addEventListener('click', () => addToHistory(book));
+
+      const thumbnail = document.createElement('img');
+      thumbnail.className = 'tile-thumbnail';
+      thumbnail.src = fixUrl(`/api/book/${encodeURIComponent(book.path)}/thumbnail`);
+      thumbnail.alt = book.name;
+      thumbnail.loading = 'lazy';
+      thumbnail.draggable = false;
+
+      const title = document.createElement('div');
+      title.className = 'tile-title';
+      title.textContent = book.name;
+
+      const subtitle = document.createElement('div');
+      subtitle.className = 'tile-subtitle';
+      if (book.reason === 'next') {
+        subtitle.textContent = 'Next volume';
+      } else if (book.progress && book.progress.pages) {
+        subtitle.textContent = `Page ${Math.max(book.progress.page, 0) + 1} / ${book.progress.pages}`;
+      } else if (book.progress) {
+        subtitle.textContent = `Page ${Math.max(book.progress.page, 0) + 1}`;
+      }
+
+      link.appendChild(thumbnail);
+      link.appendChild(title);
+      link.appendChild(subtitle);
+      tile.appendChild(link);
+      section.appendChild(tile);
+    });
+
+    // 一覧の再描画後に古い結果を差し込まない
+    if (!fileListDiv.isConnected || currentRootName) return;
+    fileListDiv.querySelectorAll('.ondeck-heading, .ondeck-section').forEach(el => el.remove());
+    fileListDiv.prepend(heading, section);
+  } catch (err) {
+    // 棚はおまけなので失敗しても一覧はそのまま
+  }
+}
+
 // リスト形式のアイテムを作成
 function createListItem(file, index) {
   const li = document.createElement('li');
@@ -1295,6 +1511,14 @@ function createListItem(file, index) {
     link.href = fixUrl(`/#${encodeURIComponent(file.path)}`);
     link.textContent = file.name;
     contentWrapper.appendChild(link);
+    // サブフォルダを含む既読数
+    if (file.books && file.books.total > 0) {
+      const counts = document.createElement('span');
+      counts.className = 'read-counts';
+      counts.textContent = `${file.books.read}/${file.books.total}`;
+      counts.title = `${file.books.read} read, ${file.books.inProgress} in progress, ${file.books.total} books`;
+      contentWrapper.appendChild(counts);
+    }
   } else if (file.type === 'video' || file.type === 'audio') {
     const link = document.createElement('a');
     link.href = fixUrl(`/media/#${encodeURIComponent(file.path)}`);
@@ -1710,6 +1934,7 @@ document.addEventListener('keydown', async (e) => {
 // ページ読み込み時にファイル一覧を取得
 initTheme();
 initZoom();
+loadPreferences();
 document.body.classList.toggle('embedded-pane', isEmbeddedPane);
 setupCurrentDirectoryDropTarget(document.getElementById('file-list'));
 updateTwoPaneMenu();
@@ -1725,10 +1950,22 @@ document.getElementById('menu-history').addEventListener('click', () => {
   hideMenu();
   showHistoryOverlay();
 });
+document.getElementById('menu-export').addEventListener('click', () => {
+  hideMenu();
+  exportUserData();
+});
+document.getElementById('menu-import-browser').addEventListener('click', () => {
+  hideMenu();
+  importBrowserData();
+});
 document.getElementById('menu-settings').addEventListener('click', () => {
   hideMenu();
   window.location.href = fixUrl('/settings/');
 });
+document.getElementById('menu-logout').addEventListener('click', () => {
+  hideMenu();
+  logout();
+});
 document.getElementById('history-close').addEventListener('click', hideHistoryOverlay);
 document.getElementById('history-clear').addEventListener('click', clearHistory);
 document.getElementById('menu-theme').addEventListener('click', toggleTheme);
@@ -100,10 +100,14 @@ function getRootsFromUI() {
         const uploadDisabled = item.querySelector('.root-upload-disabled').checked;
 
         if (path) {
-            if (name || uploadDisabled) {
-                const root = { path };
-                if (name) root.name = name;
-                if (uploadDisabled) root.uploadDisabled = true;
+            // Keep options that have no editor here (ignore patterns etc.)
+            const original = (config && config.roots || []).find(r => typeof r === 'object' && r.path === path);
+            const root = original ? { ...original } : { path };
+            delete root.name;
+            delete root.uploadDisabled;
+            if (name) root.name = name;
+            if (uploadDisabled) root.uploadDisabled = true;
+            if (Object.keys(root).length > 1) {
                 roots.push(root);
             } else {
                 roots.push(path);
@@ -128,31 +132,21 @@ async function saveSettings() {
             return;
         }
 
+        // Start from the loaded config so settings without an editor here are kept
         const newConfig = {
+            ...config,
             port: port,
             roots: roots
         };
 
-        // Add disableGUI setting
-        const disableGUI = document.getElementById('disableGUI').checked;
-        if (disableGUI) {
-            newConfig.disableGUI = true;
-        }
-
-        // Add defaultLTR setting
-        const defaultLTR = document.getElementById('defaultLTR').checked;
-        if (defaultLTR) {
-            newConfig.defaultLTR = true;
-        }
-
-        const allowFileOperations = document.getElementById('allowFileOperations').checked;
-        if (allowFileOperations) {
-            newConfig.allowFileOperations = true;
-        }
-        const allowUpload = document.getElementById('allowUpload').checked;
-        if (allowUpload) {
-            newConfig.allowUpload = true;
-        }
+        // Checkbox settings are only written when enabled
+        ['disableGUI', 'defaultLTR', 'allowFileOperations', 'allowUpload'].forEach(key => {
+            if (document.getElementById(key).checked) {
+                newConfig[key] = true;
+            } else {
+                delete newConfig[key];
+            }
+        });
 
         // Add TLS settings if provided
         const tlsCertFile = document.getElementById('tlsCertFile').value.trim();
@@ -162,6 +156,8 @@ async function saveSettings() {
                 certFile: tlsCertFile,
                 keyFile: tlsKeyFile
             };
+        } else {
+            delete newConfig.tls;
         }
 
         const res = await fetch('/api/settings/config', {
@@ -206,6 +202,299 @@ async function saveAndRestart() {
     }
 }
 
+async function loadUsers() {
+    const usersDiv = document.getElementById('users');
+    try {
+        const res = await fetch('/api/settings/users');
+        const data = await res.json();
+        if (!res.ok) {
+            throw new Error(data.error || 'Failed to load users');
+        }
+
+        usersDiv.innerHTML = '';
+        if (data.users.length === 0) {
+            usersDiv.textContent = 'No users. Anyone who can reach the server has full access.';
+            return;
+        }
+        data.users.forEach(user => {
+            const div = document.createElement('div');
+            div.className = 'user-item';
+
+            const name = document.createElement('span');
+            name.className = 'user-name';
+            name.textContent = user.admin ? `${user.name} (admin)` : user.name;
+            if (user.groups && user.groups.length > 0) {
+                name.textContent += ` [${user.groups.join(', ')}]`;
+            }
+
+            const groupsBtn = document.createElement('button');
+            groupsBtn.type = 'button';
+            groupsBtn.className = 'btn-secondary btn-small';
+            groupsBtn.textContent = 'Groups';
+            groupsBtn.onclick = () => changeGroups(user);
+
+            const passwordBtn = document.createElement('button');
+            passwordBtn.type = 'button';
+            passwordBtn.className = 'btn-secondary btn-small';
+            passwordBtn.textContent = 'Change Password';
+            passwordBtn.onclick = () => changePassword(user.name);
+
+            const removeBtn = document.createElement('button');
+            removeBtn.type = 'button';
+            removeBtn.className = 'btn-danger btn-small';
+            removeBtn.textContent = 'Remove';
+            removeBtn.onclick = () => removeUser(user.name);
+
+            div.appendChild(name);
+            div.appendChild(groupsBtn);
+            div.appendChild(passwordBtn);
+            div.appendChild(removeBtn);
+            usersDiv.appendChild(div);
+        });
+    } catch (err) {
+        usersDiv.textContent = err.message;
+    }
+}
+
+async function userRequest(url, method, body) {
+    const res = await fetch(url, {
+        method,
+        headers: { 'Content-Type': 'application/json' },
+        body: body ? JSON.stringify(body) : undefined
+    });
+    const data = await res.json();
+    if (!res.ok) {
+        throw new Error(data.error || 'Request failed');
+    }
+    return data;
+}
+
+async function addUser() {
+    try {
+        await userRequest('/api/settings/users', 'POST', {
+            name: document.getElementById('newUserName').value.trim(),
+            password: document.getElementById('newUserPassword').value,
+            admin: document.getElementById('newUserAdmin').checked,
+            groups: parseGroups(document.getElementById('newUserGroups').value)
+        });
+        document.getElementById('newUserName').value = '';
+        document.getElementById('newUserGroups').value = '';
+        document.getElementById('newUserPassword').value = '';
+        document.getElementById('newUserAdmin').checked = false;
+        showMessage('User added.', 'success');
+        loadUsers();
+    } catch (err) {
+        showMessage('Error: ' + err.message, 'error');
+    }
+}
+
+function parseGroups(text) {
+    return text.split(',').map(g => g.trim()).filter(g => g);
+}
+
+async function changeGroups(user) {
+    const text = window.prompt(`Groups for ${user.name} (comma separated):`, (user.groups || []).join(', '));
+    if (text === null) {
+        return;
+    }
+    try {
+        await userRequest(`/api/settings/users/${encodeURIComponent(user.name)}`, 'PUT', { groups: parseGroups(text) });
+        showMessage('Groups changed.', 'success');
+        loadUsers();
+    } catch (err) {
+        showMessage('Error: ' + err.message, 'error');
+    }
+}
+
+async function changePassword(name) {
+    const password = window.prompt(`New password for ${name}:`);
+    if (!password) {
+        return;
+    }
+    try {
+        await userRequest(`/api/settings/users/${encodeURIComponent(name)}`, 'PUT', { password });
+        showMessage('Password changed.', 'success');
+    } catch (err) {
+        showMessage('Error: ' + err.message, 'error');
+    }
+}
+
+async function removeUser(name) {
+    if (!window.confirm(`Remove user ${name}?`)) {
+        return;
+    }
+    try {
+        await userRequest(`/api/settings/users/${encodeURIComponent(name)}`, 'DELETE');
+        showMessage('User removed.', 'success');
+        loadUsers();
+    } catch (err) {
+        showMessage('Error: ' + err.message, 'error');
+    }
+}
+
+async function loadTokens() {
+    const tokensDiv = document.getElementById('tokens');
+    try {
+        const res = await fetch('/api/settings/tokens');
+        const data = await res.json();
+        if (!res.ok) {
+            throw new Error(data.error || 'Failed to load tokens');
+        }
+
+        tokensDiv.innerHTML = '';
+        if (data.tokens.length === 0) {
+            tokensDiv.textContent = 'No tokens.';
+            return;
+        }
+        data.tokens.forEach(token => {
+            const div = document.createElement('div');
+            div.className = 'user-item';
+
+            const name = document.createElement('span');
+            name.className = 'user-name';
+            const details = [token.scope === 'full' ? 'full access' : 'read only', `by ${token.user}`];
+            if (token.expires) details.push(`expires ${new Date(token.expires).toLocaleString()}`);
+            if (token.lastUsed) details.push(`last used ${new Date(token.lastUsed).toLocaleString()}`);
+            name.textContent = `${token.name} (${details.join(', ')})`;
+
+            const revokeBtn = document.createElement('button');
+            revokeBtn.type = 'button';
+            revokeBtn.className = 'btn-danger btn-small';
+            revokeBtn.textContent = 'Revoke';
+            revokeBtn.onclick = () => revokeToken(token);
+
+            div.appendChild(name);
+            div.appendChild(revokeBtn);
+            tokensDiv.appendChild(div);
+        });
+    } catch (err) {
+        tokensDiv.textContent = err.message;
+    }
+}
+
+async function addToken() {
+    try {
+        const data = await userRequest('/api/settings/tokens', 'POST', {
+            name: document.getElementById('newTokenName').value.trim(),
+            scope: document.getElementById('newTokenScope').value,
+            expires: document.getElementById('newTokenExpires').value.trim()
+        });
+        document.getElementById('newTokenName').value = '';
+        document.getElementById('newTokenExpires').value = '';
+        showMessage(`Token created. Copy it now, it will not be shown again: ${data.token}`, 'success');
+        loadTokens();
+    } catch (err) {
+        showMessage('Error: ' + err.message, 'error');
+    }
+}
+
+async function revokeToken(token) {
+    if (!window.confirm(`Revoke token ${token.name}?`)) {
+        return;
+    }
+    try {
+        await userRequest(`/api/settings/tokens/${encodeURIComponent(token.id)}`, 'DELETE');
+        showMessage('Token revoked.', 'success');
+        loadTokens();
+    } catch (err) {
+        showMessage('Error: ' + err.message, 'error');
+    }
+}
+
+async function loadShares() {
+    const sharesDiv = document.getElementById('shares');
+    try {
+        const res = await fetch('/api/shares');
+        const data = await res.json();
+        if (!res.ok) {
+            throw new Error(data.error || 'Failed to load share links');
+        }
+
+        sharesDiv.innerHTML = '';
+        if (data.shares.length === 0) {
+            sharesDiv.textContent = 'No share links.';
+            return;
+        }
+        data.shares.forEach(share => {
+            const div = document.createElement('div');
+            div.className = 'user-item';
+
+            const name = document.createElement('span');
+            name.className = 'user-name';
+            const details = [`expires ${new Date(share.expires).toLocaleString()}`];
+            if (share.user) details.unshift(`by ${share.user}`);
+            name.textContent = `${share.path} (${details.join(', ')})`;
+
+            const copyBtn = document.createElement('button');
+            copyBtn.type = 'button';
+            copyBtn.className = 'btn-secondary btn-small';
+            copyBtn.textContent = 'Link';
+            copyBtn.onclick = () => window.prompt('Share link:', share.url);
+
+            const revokeBtn = document.createElement('button');
+            revokeBtn.type = 'button';
+            revokeBtn.className = 'btn-danger btn-small';
+            revokeBtn.textContent = 'Revoke';
+            revokeBtn.onclick = () => revokeShare(share);
+
+            div.appendChild(name);
+            div.appendChild(copyBtn);
+            div.appendChild(revokeBtn);
+            sharesDiv.appendChild(div);
+        });
+    } catch (err) {
+        sharesDiv.textContent = err.message;
+    }
+}
+
+async function revokeShare(share) {
+    if (!window.confirm(`Revoke share link for ${share.path}?`)) {
+        return;
+    }
+    try {
+        await userRequest(`/api/shares/${encodeURIComponent(share.id)}`, 'DELETE');
+        showMessage('Share link revoked.', 'success');
+        loadShares();
+    } catch (err) {
+        showMessage('Error: ' + err.message, 'error');
+    }
+}
+
+async function loadAudit() {
+    const auditDiv = document.getElementById('audit');
+    try {
+        const res = await fetch('/api/settings/audit?limit=50');
+        const data = await res.json();
+        if (!res.ok) {
+            throw new Error(data.error || 'Failed to load audit log');
+        }
+
+        auditDiv.innerHTML = '';
+        if (data.entries.length === 0) {
+            auditDiv.textContent = 'No entries.';
+            return;
+        }
+        data.entries.forEach(entry => {
+            const div = document.createElement('div');
+            div.className = 'user-item';
+
+            const text = document.createElement('span');
+            text.className = 'user-name';
+            const parts = [new Date(entry.time).toLocaleString(), entry.action];
+            if (entry.target) parts.push(entry.target);
+            if (entry.paths) parts.push(entry.paths.join(' → '));
+            parts.push(`by ${entry.user || entry.ip || 'unknown'}`);
+            parts.push(entry.error ? `failed: ${entry.error}` : 'ok');
+            text.textContent = parts.join(' · ');
+
+            div.appendChild(text);
+            auditDiv.appendChild(div);
+        });
+    } catch (err) {
+        auditDiv.textContent = err.message;
+    }
+}
+
 function showMessage(text, type) {
     const msg = document.getElementById('message');
     msg.textContent = text;
@@ -214,3 +503,7 @@ function showMessage(text, type) {
 }
 
 loadSettings();
+loadUsers();
+loadTokens();
+loadShares();
+loadAudit();
@@ -20,6 +20,7 @@ function fixUrl(path) {
 
 // ビューアの状態管理
 let currentFile = '';
+let storageKey = ''; // ページ位置の保存キー（サーバーのブックIDがあればそれを使う）
 let images = [];
 let imageCount = 0; // 画像数
 let currentPage = 0; // 表示開始ページのインデックス
@@ -73,6 +74,7 @@ function cleanupOldFiles(currentFile) {
       localStorage.removeItem(`viewer_page_${file}`);
       localStorage.removeItem(`viewer_offset_${file}`);
       localStorage.removeItem(`viewer_direction_${file}`);
+      localStorage.removeItem(`viewer_updated_${file}`);
     });
 
     // 履歴を更新
@@ -374,6 +376,11 @@ async function loadImageList() {
     }
 
     const response = await fetch(fixUrl(`/api/book/${encodeURIComponent(fileInfo.rootName)}/${fileInfo.relativePath}/list`));
+    if (response.status === 401) {
+      // セッション切れ時はログイン画面へ
+      location.href = `/login/?next=${encodeURIComponent(location.pathname + location.search + location.hash)}`;
+      return;
+    }
     const data = await response.json();
     console.log('画像リスト:', data);
 
@@ -389,10 +396,37 @@ async function loadImageList() {
       throw new Error('No images found');
     }
 
+    // ブックIDはリネームや移動でも変わらないので、保存キーとして優先する
+    storageKey = data.id ? `id:${data.id}` : currentFile;
+    if (storageKey !== currentFile && localStorage.getItem(`viewer_page_${storageKey}`) === null) {
+      // パスで保存されていた位置をIDキーへ引き継ぐ
+      ['viewer_page_', 'viewer_offset_', 'viewer_direction_'].forEach(prefix => {
+        const value = localStorage.getItem(`${prefix}${currentFile}`);
+        if (value !== null) {
+          localStorage.setItem(`${prefix}${storageKey}`, value);
+          localStorage.removeItem(`${prefix}${currentFile}`);
+        }
+      });
+    }
+
+    // サーバーの進捗がこの端末の保存より新しければ採用する（別の端末で読み進めた場合）
+    if (data.progress) {
+      const serverUpdated = Date.parse(data.progress.updated);
+      const localUpdated = parseInt(localStorage.getItem(`viewer_updated_${storageKey}`) || '0');
+      if (serverUpdated > localUpdated) {
+        localStorage.setItem(`viewer_page_${storageKey}`, data.progress.page);
+        localStorage.setItem(`viewer_offset_${storageKey}`, data.progress.offset);
+        if (data.progress.direction) {
+          localStorage.setItem(`viewer_direction_${storageKey}`, data.progress.direction);
+        }
+        localStorage.setItem(`viewer_updated_${storageKey}`, serverUpdated);
+      }
+    }
+
     // 保存されたページ位置を復元（検証付き）
-    const savedPage = localStorage.getItem(`viewer_page_${currentFile}`);
-    const savedOffset = localStorage.getItem(`viewer_offset_${currentFile}`);
-    const savedDirection = localStorage.getItem(`viewer_direction_${currentFile}`);
+    const savedPage = localStorage.getItem(`viewer_page_${storageKey}`);
+    const savedOffset = localStorage.getItem(`viewer_offset_${storageKey}`);
+    const savedDirection = localStorage.getItem(`viewer_direction_${storageKey}`);
 
     if (savedPage !== null) {
       const page = parseInt(savedPage);
@@ -429,8 +463,79 @@ async function loadImageList() {
   }
 }
 
-// 画像を読み込み（キャッシュあり）
-async function loadImage(index) {
+// ============================================================================
+// 進捗の同期
+// ============================================================================
+
+let progressSyncTimer = null;
+
+// 端末名（進捗を保存した端末の表示用）
+function getDeviceName() {
+  const match = navigator.userAgent.match(/iPad|iPhone|Android|Macintosh|Windows|Linux/);
+  return match ? match[0] : 'Browser';
+}
+
+// ページ移動が落ち着いてからサーバーへ進捗を保存
+function scheduleProgressSync() {
+  if (window.location.pathname.split('/').includes('__demo__')) return; // デモでは同期しない
+  clearTimeout(progressSyncTimer);
+  progressSyncTimer = setTimeout(syncProgress, 1000);
+}
+
+async function syncProgress() {
+  progressSyncTimer = null;
+  // 未保存（初めて開いた本）はサーバーの現在時刻を使う
+  const updated = parseInt(localStorage.getItem(`viewer_updated_${storageKey}`));
+  try {
+    await fetch(`/api/book/${encodeURIComponent(currentFile)}/progress`, {
+      method: 'PUT',
+      headers: { 'Content-Type': 'application/json' },
+      keepalive: true, // ページを閉じる直前の保存も届くように
+      body: JSON.stringify({
+        page: currentPage,
+        offset,
+        direction: readingDirection,
+        completed: currentPage >= imageCount - 2,
+        device: getDeviceName(),
+        updated: isNaN(updated) ? undefined : new Date(updated).toISOString(),
+      }),
+    });
+  } catch (err) {
+    console.error('Failed to sync progress:', err);
+  }
+}
+
+// 表示中のページにブックマークを追加
+async function addBookmark() {
+  if (window.location.pathname.split('/').includes('__demo__')) return; // デモでは保存しない
+  const page = Math.min(Math.max(getDisplayPage(), 0), imageCount - 1);
+  const note = prompt(`Bookmark page ${page + 1} (optional note):`, '');
+  if (note === null) return;
+  const pageInfoDiv = document.getElementById('page-info');
+  try {
+    const response = await fetch(`/api/book/${encodeURIComponent(currentFile)}/bookmarks`, {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ page, note }),
+    });
+    if (!response.ok) throw new Error(`HTTP ${response.status}`);
+    pageInfoDiv.textContent = `Bookmarked page ${page + 1}`;
+  } catch (err) {
+    console.error('Failed to add bookmark:', err);
+    pageInfoDiv.textContent = 'Failed to add bookmark';
+  }
+}
+
+// 閉じる前に未送信の進捗を保存
+window.addEventListener('pagehide', () => {
+  if (progressSyncTimer) {
+    clearTimeout(progressSyncTimer);
+    syncProgress();
+  }
+});
+
+// 画像を読み込み（キャッシュあり）。先読みはサーバーで閲覧として記録されないよう印を付ける
+async function loadImage(index, prefetch = false) {
   if (index < 0 || index >= imageCount) {
     return null;
   }
@@ -440,7 +545,7 @@ async function loadImage(index) {
     return imageCache[index];
   }
 
-  const url = fixUrl(`/api/book/${encodeURIComponent(currentFile)}/image/${index}`);
+  const url = fixUrl(`/api/book/${encodeURIComponent(currentFile)}/image/${index}`) + (prefetch ? '?prefetch=1' : '');
 
   return new Promise((resolve, reject) => {
     const img = new Image();
@@ -490,12 +595,17 @@ async function displayCurrentPages(isInitialLoad = false) {
   console.log('displayPage:', displayPage);
 
   // ページ位置とオフセットと読み方向を保存
-  localStorage.setItem(`viewer_page_${currentFile}`, currentPage);
-  localStorage.setItem(`viewer_offset_${currentFile}`, offset);
-  localStorage.setItem(`viewer_direction_${currentFile}`, readingDirection);
+  localStorage.setItem(`viewer_page_${storageKey}`, currentPage);
+  localStorage.setItem(`viewer_offset_${storageKey}`, offset);
+  localStorage.setItem(`viewer_direction_${storageKey}`, readingDirection);
+  if (!isInitialLoad) {
+    localStorage.setItem(`viewer_updated_${storageKey}`, Date.now());
+  }
+  // 初回表示も保存する（先読みはサーバーの進捗に数えられないため）。時刻は復元した位置のまま
+  scheduleProgressSync();
 
   // 古いファイルのデータをクリーンアップ
-  cleanupOldFiles(currentFile);
+  cleanupOldFiles(storageKey);
 
   const forceOnePageMode = isForceOnePageMode();
 
@@ -602,7 +712,7 @@ async function preloadAdjacentPages(currentIndex) {
   for (const offset of preloadOffsets) {
     const index = currentIndex + offset;
     if (index >= 0 && index < imageCount && !imageCache[index]) {
-      loadImage(index).catch(() => {
+      loadImage(index, true).catch(() => {
         // エラーは無視（バックグラウンドでの先読みのため）
       });
     }
@@ -843,6 +953,11 @@ document.addEventListener('keydown', (e) => {
       e.preventDefault();
       toggleReadingDirection();
       break;
+    case 'b':
+    case 'B':
+      e.preventDefault();
+      addBookmark();
+      break;
   }
 });
 
@@ -895,6 +1010,7 @@ function setupToolbar() {
   });
   document.getElementById('btn-direction').addEventListener('click', toggleReadingDirection);
   document.getElementById('btn-fullscreen').addEventListener('click', toggleFullscreen);
+  document.getElementById('btn-bookmark').addEventListener('click', addBookmark);
   document.getElementById('btn-help').addEventListener('click', toggleHelp);
   document.getElementById('close-help').addEventListener('click', closeHelp);
 }
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultLoginAttempts = 5
+	defaultLoginLockout  = 15 * time.Minute
+	// rateLimitSweepInterval is how often idle buckets and expired lockouts are dropped
+	rateLimitSweepInterval = time.Minute
+)
+
+// Route groups with separate rate limits
+const (
+	rateGroupAuth     = "auth"
+	rateGroupReading  = "reading"
+	rateGroupCommands = "commands"
+	rateGroupUploads  = "uploads"
+)
+
+// RateLimit is a token bucket: up to Burst requests at once, refilled at PerMinute
+type RateLimit struct {
+	PerMinute float64 `json:"perMinute"`
+	Burst     int     `json:"burst,omitempty"` // Defaults to PerMinute
+}
+
+// RateLimitConfig limits requests per client (the user when logged in, otherwise the
+// client address) for each route group. Groups without a limit are unrestricted.
+type RateLimitConfig struct {
+	Auth          *RateLimit `json:"auth,omitempty"`          // /api/auth/* and /api/kosync/users/*
+	Reading       *RateLimit `json:"reading,omitempty"`       // Other API reads: listings, pages, thumbnails
+	Commands      *RateLimit `json:"commands,omitempty"`      // File operations and other API changes
+	Uploads       *RateLimit `json:"uploads,omitempty"`       // /api/command/upload
+	LoginAttempts int        `json:"loginAttempts,omitempty"` // Failed logins before a lockout (default 5)
+	LoginLockout  string     `json:"loginLockout,omitempty"`  // Lockout duration such as "15m" (default)
+}
+
+// tokenBucket holds the remaining requests of one client in one route group
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateLimiter keeps token buckets for every client and route group
+type RateLimiter struct {
+	mu        sync.Mutex
+	limits    map[string]RateLimit
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+func newRateLimiter(cfg *RateLimitConfig) *RateLimiter {
+	limiter := &RateLimiter{limits: make(map[string]RateLimit), buckets: make(map[string]*tokenBucket)}
+	if cfg == nil {
+		return limiter
+	}
+	for group, limit := range map[string]*RateLimit{
+		rateGroupAuth: cfg.Auth, rateGroupReading: cfg.Reading,
+		rateGroupCommands: cfg.Commands, rateGroupUploads: cfg.Uploads,
+	} {
+		if limit == nil || limit.PerMinute <= 0 {
+			continue
+		}
+		if limit.Burst <= 0 {
+			limit.Burst = int(math.Ceil(limit.PerMinute))
+		}
+		limiter.limits[group] = *limit
+	}
+	return limiter
+}
+
+// allow takes a token for client in group. When the bucket is empty it returns
+// false and how long until the next request is allowed.
+func (l *RateLimiter) allow(group, client string, now time.Time) (bool, time.Duration) {
+	limit, ok := l.limits[group]
+	if !ok {
+		return true, 0
+	}
+	rate := limit.PerMinute / 60 // tokens per second
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.lastSweep) > rateLimitSweepInterval {
+		l.sweep(now)
+	}
+	key := group + "\x00" + client
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(limit.Burst), last: now}
+		l.buckets[key] = bucket
+	}
+	bucket.tokens = math.Min(float64(limit.Burst), bucket.tokens+now.Sub(bucket.last).Seconds()*rate)
+	bucket.last = now
+	if bucket.tokens < 1 {
+		return false, time.Duration((1 - bucket.tokens) / rate * float64(time.Second))
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+// sweep drops buckets that have refilled completely; callers must hold mu
+func (l *RateLimiter) sweep(now time.Time) {
+	for key, bucket := range l.buckets {
+		group, _, _ := strings.Cut(key, "\x00")
+		limit := l.limits[group]
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*limit.PerMinute/60 >= float64(limit.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// rateGroup returns the route group of a request, or "" for requests that are never limited
+func rateGroup(r *http.Request) string {
+	switch {
+	case !strings.HasPrefix(r.URL.Path, "/api/"):
+		return ""
+	case strings.HasPrefix(r.URL.Path, "/api/auth/"), strings.HasPrefix(r.URL.Path, kosyncPrefix+"users/"):
+		return rateGroupAuth
+	case r.URL.Path == "/api/command/upload":
+		return rateGroupUploads
+	case isStateChanging(r.Method) || strings.HasPrefix(r.URL.Path, "/api/command/"):
+		return rateGroupCommands
+	default:
+		return rateGroupReading
+	}
+}
+
+// rateLimitClient identifies the client a request is counted against
+func (s *Server) rateLimitClient(r *http.Request) string {
+	if name := requestUserName(r); name != "" {
+		return "user:" + name
+	}
+	if ip := s.clientIP(r); ip != nil {
+		return "ip:" + ip.String()
+	}
+	return "ip:" + r.RemoteAddr
+}
+
+// respondTooManyRequests answers 429 with a Retry-After of at least one second
+func respondTooManyRequests(w http.ResponseWriter, message string, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Max(1, math.Ceil(wait.Seconds())))))
+	respondError(w, message, http.StatusTooManyRequests)
+}
+
+// rateLimitMiddleware rejects clients that exceed the limit of a route group. It runs
+// after authentication so logged-in users are counted by account.
+func (s *Server) rateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if group := rateGroup(r); group != "" {
+			if ok, wait := s.rateLimiter.allow(group, s.rateLimitClient(r), time.Now()); !ok {
+				respondTooManyRequests(w, "Too many requests", wait)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// loginFailures counts recent failed logins of one client and account
+type loginFailures struct {
+	count       int
+	lockedUntil time.Time
+	last        time.Time
+}
+
+// LoginGuard locks out a client address from an account after repeated failed logins.
+// Keying on both keeps an attacker from locking the real user out everywhere.
+type LoginGuard struct {
+	mu        sync.Mutex
+	attempts  int
+	lockout   time.Duration
+	failures  map[string]*loginFailures
+	lastSweep time.Time
+}
+
+func newLoginGuard(cfg *RateLimitConfig) *LoginGuard {
+	guard := &LoginGuard{
+		attempts: defaultLoginAttempts,
+		lockout:  defaultLoginLockout,
+		failures: make(map[string]*loginFailures),
+	}
+	if cfg == nil {
+		return guard
+	}
+	if cfg.LoginAttempts > 0 {
+		guard.attempts = cfg.LoginAttempts
+	}
+	if cfg.LoginLockout != "" {
+		if lockout, err := time.ParseDuration(cfg.LoginLockout); err == nil && lockout > 0 {
+			guard.lockout = lockout
+		} else {
+			log.Printf("Warning: invalid rateLimit.loginLockout %q, using %v", cfg.LoginLockout, defaultLoginLockout)
+		}
+	}
+	return guard
+}
+
+func loginGuardKey(client, name string) string {
+	return client + "\x00" + strings.ToLower(name)
+}
+
+// locked reports how long a client remains locked out of an account
+func (g *LoginGuard) locked(client, name string, now time.Time) (bool, time.Duration) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	failures, ok := g.failures[loginGuardKey(client, name)]
+	if !ok || !now.Before(failures.lockedUntil) {
+		return false, 0
+	}
+	return true, failures.lockedUntil.Sub(now)
+}
+
+// fail counts a failed login. Failures older than the lockout duration are forgotten.
+func (g *LoginGuard) fail(client, name string, now time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if now.Sub(g.lastSweep) > rateLimitSweepInterval {
+		for key, failures := range g.failures {
+			if now.Sub(failures.last) > g.lockout && !now.Before(failures.lockedUntil) {
+				delete(g.failures, key)
+			}
+		}
+		g.lastSweep = now
+	}
+
+	key := loginGuardKey(client, name)
+	failures, ok := g.failures[key]
+	if !ok || now.Sub(failures.last) > g.lockout {
+		failures = &loginFailures{}
+		g.failures[key] = failures
+	}
+	failures.count++
+	failures.last = now
+	if failures.count >= g.attempts {
+		failures.count = 0
+		failures.lockedUntil = now.Add(g.lockout)
+	}
+}
+
+// succeed clears the failures of a client after a successful login
+func (g *LoginGuard) succeed(client, name string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.failures, loginGuardKey(client, name))
+}
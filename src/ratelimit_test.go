@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterTokenBucket(t *testing.T) {
+	limiter := newRateLimiter(&RateLimitConfig{Reading: &RateLimit{PerMinute: 60, Burst: 2}})
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := limiter.allow(rateGroupReading, "ip:192.0.2.1", now); !ok {
+			t.Fatalf("request %d within burst was limited", i)
+		}
+	}
+	ok, wait := limiter.allow(rateGroupReading, "ip:192.0.2.1", now)
+	if ok || wait <= 0 || wait > time.Second {
+		t.Fatalf("over burst = %v, wait %v", ok, wait)
+	}
+	if ok, _ := limiter.allow(rateGroupReading, "ip:192.0.2.2", now); !ok {
+		t.Fatal("another client was limited")
+	}
+	if ok, _ := limiter.allow(rateGroupCommands, "ip:192.0.2.1", now); !ok {
+		t.Fatal("an unconfigured group was limited")
+	}
+	if ok, _ := limiter.allow(rateGroupReading, "ip:192.0.2.1", now.Add(time.Second)); !ok {
+		t.Fatal("bucket did not refill")
+	}
+}
+
+func TestRateLimitMiddlewareRespondsWithRetryAfter(t *testing.T) {
+	server := newAuthTestServerWithConfig(t, &Config{
+		Roots:     []RootConfig{{Path: t.TempDir(), Name: "Root"}},
+		RateLimit: &RateLimitConfig{Reading: &RateLimit{PerMinute: 1}},
+	})
+	if response := authRequest(t, server, "GET", "/api/dir", nil); response.Code != http.StatusOK {
+		t.Fatalf("first request = %d", response.Code)
+	}
+	response := authRequest(t, server, "GET", "/api/dir", nil)
+	if response.Code != http.StatusTooManyRequests {
+		t.Fatalf("second request = %d, want 429", response.Code)
+	}
+	if seconds, err := strconv.Atoi(response.Header().Get("Retry-After")); err != nil || seconds < 1 || seconds > 60 {
+		t.Fatalf("Retry-After = %q", response.Header().Get("Retry-After"))
+	}
+	// Static pages are not limited
+	if response := authRequest(t, server, "GET", "/viewer/", nil); response.Code != http.StatusOK {
+		t.Fatalf("page = %d", response.Code)
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	server := newAuthTestServerWithConfig(t, &Config{
+		Roots:     []RootConfig{{Path: t.TempDir(), Name: "Root"}},
+		RateLimit: &RateLimitConfig{LoginAttempts: 3, LoginLockout: "1m"},
+	})
+	authRequest(t, server, "POST", "/api/settings/users", map[string]interface{}{"name": "alice", "password": "correct horse"})
+
+	// httptest requests come from 192.0.2.1
+	login := func(password string) *httptest.ResponseRecorder {
+		return authRequest(t, server, "POST", "/api/auth/login", map[string]string{"name": "alice", "password": password})
+	}
+	for i := 0; i < 3; i++ {
+		if response := login("wrong password"); response.Code != http.StatusUnauthorized {
+			t.Fatalf("failure %d = %d", i, response.Code)
+		}
+	}
+	response := login("correct horse")
+	if response.Code != http.StatusTooManyRequests || response.Header().Get("Retry-After") == "" {
+		t.Fatalf("locked login = %d, Retry-After %q", response.Code, response.Header().Get("Retry-After"))
+	}
+
+	// The lockout is per client address: the real user elsewhere can still log in
+	guard := server.loginGuard
+	if locked, _ := guard.locked("ip:198.51.100.7", "alice", time.Now()); locked {
+		t.Fatal("lockout applied to another address")
+	}
+	if locked, _ := guard.locked("ip:192.0.2.1", "alice", time.Now().Add(2*time.Minute)); locked {
+		t.Fatal("lockout did not expire")
+	}
+}
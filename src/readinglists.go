@@ -0,0 +1,609 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/maruel/natural"
+)
+
+// maxCBLSize bounds an uploaded ComicRack reading list
+const maxCBLSize = 10 << 20
+
+// ReadingListEntry is one position in a reading list. Path is empty when an
+// imported entry could not be matched against the library.
+type ReadingListEntry struct {
+	Path   string `json:"path,omitempty"`
+	Series string `json:"series,omitempty"`
+	Number string `json:"number,omitempty"`
+	Volume string `json:"volume,omitempty"`
+	Year   string `json:"year,omitempty"`
+	Read   bool   `json:"read,omitempty"` // Whether the requester finished the book; filled per request, not stored
+}
+
+// ReadingList is an explicitly ordered list of books, possibly spanning folders and roots
+type ReadingList struct {
+	ID      string             `json:"id"`
+	Name    string             `json:"name"`
+	Entries []ReadingListEntry `json:"entries"`
+	Current int                `json:"current"` // index of the requester's first unread entry
+	Created time.Time          `json:"created"`
+	Updated time.Time          `json:"updated"`
+}
+
+// ReadingListStore persists reading lists in the data directory
+type ReadingListStore struct {
+	mu    sync.Mutex
+	path  string
+	Lists map[string]*ReadingList `json:"lists"`
+}
+
+// cblReadingList is the ComicRack .cbl XML document
+type cblReadingList struct {
+	XMLName xml.Name  `xml:"ReadingList"`
+	Name    string    `xml:"Name"`
+	Books   []cblBook `xml:"Books>Book"`
+}
+
+type cblBook struct {
+	Series string `xml:"Series,attr"`
+	Number string `xml:"Number,attr"`
+	Volume string `xml:"Volume,attr,omitempty"`
+	Year   string `xml:"Year,attr,omitempty"`
+	File   string `xml:"FileName,omitempty"`
+}
+
+func loadReadingListStore(path string) *ReadingListStore {
+	store := &ReadingListStore{path: path, Lists: make(map[string]*ReadingList)}
+	if err := readJSONFile(path, store); err != nil {
+		logStoreLoadError("reading lists", err)
+	}
+	if store.Lists == nil {
+		store.Lists = make(map[string]*ReadingList)
+	}
+	// Read flags were once shared by everyone; they now come from each user's progress
+	for _, list := range store.Lists {
+		for i := range list.Entries {
+			list.Entries[i].Read = false
+		}
+	}
+	return store
+}
+
+// save writes the store; callers must hold mu
+func (c *ReadingListStore) save() error {
+	return writeJSONFile(c.path, c)
+}
+
+// movePath rewrites list entries after a rename or move
+func (c *ReadingListStore) movePath(oldPath, newPath string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	changed := false
+	for _, list := range c.Lists {
+		for i := range list.Entries {
+			if moved, ok := movedPath(list.Entries[i].Path, oldPath, newPath); ok {
+				list.Entries[i].Path, changed = moved, true
+			}
+		}
+	}
+	if changed {
+		if err := c.save(); err != nil {
+			logStoreError("reading lists", err)
+		}
+	}
+}
+
+// snapshot copies a list so it can be encoded after the store lock is released
+func (l *ReadingList) snapshot() ReadingList {
+	result := *l
+	result.Entries = append(make([]ReadingListEntry, 0, len(l.Entries)), l.Entries...)
+	return result
+}
+
+// hideInaccessible unlinks entries in roots the requester cannot see, so they
+// read as unmatched without shifting entry indexes
+func (l *ReadingList) hideInaccessible(access *Access) {
+	for i := range l.Entries {
+		if l.Entries[i].Path != "" && !access.canSeePath(l.Entries[i].Path) {
+			l.Entries[i].Path = ""
+		}
+	}
+}
+
+// markRead sets the Read flags from the request paths a user has finished and
+// points Current at the first unread entry
+func (l *ReadingList) markRead(completed map[string]bool) {
+	for i := range l.Entries {
+		l.Entries[i].Read = l.Entries[i].Path != "" && completed[l.Entries[i].Path]
+	}
+	l.Current = len(l.Entries)
+	for i := range l.Entries {
+		if !l.Entries[i].Read {
+			l.Current = i
+			return
+		}
+	}
+}
+
+// completedPaths returns the request paths of the books a user has finished
+func (s *Server) completedPaths(user string) map[string]bool {
+	completed := make(map[string]bool)
+	for _, record := range s.progress.forUser(user) {
+		if record.Completed && record.Path != "" {
+			completed[record.Path] = true
+		}
+	}
+	return completed
+}
+
+// readingListFor copies a list as the requester sees it: entries in roots they
+// cannot see are unlinked and Read reflects their own progress
+func (s *Server) readingListFor(r *http.Request, list *ReadingList) ReadingList {
+	result := list.snapshot()
+	result.hideInaccessible(s.requestAccess(r))
+	result.markRead(s.completedPaths(requestUserName(r)))
+	return result
+}
+
+// readingListEntryFor builds an entry for a library path, filling series information from the name
+func readingListEntryFor(path string) ReadingListEntry {
+	entry := ReadingListEntry{Path: path}
+	if series, number, ok := parseSeriesName(filepath.Base(path)); ok {
+		entry.Series, entry.Number = series, strconv.FormatFloat(number, 'f', -1, 64)
+	}
+	return entry
+}
+
+// libraryBookIndex maps normalized series/number keys to library books for .cbl matching
+type libraryBookIndex struct {
+	bySeries map[string][]string // seriesKey + "#" + number -> paths
+	byName   map[string][]string // lowercased filename without extension -> paths
+}
+
+func seriesNumberKey(series string, number float64) string {
+	return seriesKey(series) + "#" + strconv.FormatFloat(number, 'f', -1, 64)
+}
+
+func (s *Server) buildLibraryBookIndex() (*libraryBookIndex, error) {
+	index := &libraryBookIndex{bySeries: make(map[string][]string), byName: make(map[string][]string)}
+	err := s.walkLibrary(func(item fileItem, fullPath string) error {
+		if item.Type != "book" {
+			return nil
+		}
+		if item.Series != "" && item.Number != nil {
+			key := seriesNumberKey(item.Series, *item.Number)
+			index.bySeries[key] = append(index.bySeries[key], item.Path)
+		}
+		name := strings.ToLower(strings.TrimSuffix(item.Name, filepath.Ext(item.Name)))
+		index.byName[name] = append(index.byName[name], item.Path)
+		return nil
+	})
+	return index, err
+}
+
+// match finds the library book for a .cbl entry by series and number, then by filename
+func (index *libraryBookIndex) match(book cblBook) string {
+	if number, err := strconv.ParseFloat(strings.TrimSpace(book.Number), 64); err == nil && !math.IsNaN(number) {
+		candidates := index.bySeries[seriesNumberKey(book.Series, number)]
+		if len(candidates) == 1 {
+			return candidates[0]
+		}
+		// Several volumes of a same-named series: prefer the one naming the volume/year
+		for _, hint := range []string{book.Volume, book.Year} {
+			if hint == "" {
+				continue
+			}
+			for _, candidate := range candidates {
+				if strings.Contains(candidate, hint) {
+					return candidate
+				}
+			}
+		}
+		if len(candidates) > 0 {
+			return candidates[0]
+		}
+	}
+	if book.File != "" {
+		name := strings.ToLower(strings.TrimSuffix(filepath.Base(book.File), filepath.Ext(book.File)))
+		if candidates := index.byName[name]; len(candidates) > 0 {
+			return candidates[0]
+		}
+	}
+	return ""
+}
+
+// handleReadingLists lists (GET) or creates (POST) reading lists
+func (s *Server) handleReadingLists(w http.ResponseWriter, r *http.Request) {
+	store := s.readingLists
+
+	if r.Method == "GET" {
+		type summary struct {
+			ID      string    `json:"id"`
+			Name    string    `json:"name"`
+			Count   int       `json:"count"`
+			Current int       `json:"current"`
+			Updated time.Time `json:"updated"`
+		}
+		completed := s.completedPaths(requestUserName(r))
+		store.mu.Lock()
+		list := make([]summary, 0, len(store.Lists))
+		for _, l := range store.Lists {
+			result := l.snapshot()
+			result.markRead(completed)
+			list = append(list, summary{l.ID, l.Name, len(l.Entries), result.Current, l.Updated})
+		}
+		store.mu.Unlock()
+		sort.Slice(list, func(i, j int) bool { return natural.Less(list[i].Name, list[j].Name) })
+		respondJSON(w, struct {
+			ReadingLists []summary `json:"readingLists"`
+		}{list})
+		return
+	}
+	if !s.checkCollections(w, r) {
+		return
+	}
+
+	var req struct {
+		Name  string   `json:"name"`
+		Books []string `json:"books"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		respondError(w, "Name is required", http.StatusBadRequest)
+		return
+	}
+	entries := make([]ReadingListEntry, 0, len(req.Books))
+	for _, book := range req.Books {
+		canonical, err := s.canonicalBookPath(r, book)
+		if err != nil {
+			respondError(w, "Invalid book path: "+book, http.StatusBadRequest)
+			return
+		}
+		entries = append(entries, readingListEntryFor(canonical))
+	}
+
+	now := time.Now()
+	s.saveNewReadingList(w, r, &ReadingList{ID: newID(), Name: req.Name, Entries: entries, Created: now, Updated: now})
+}
+
+// saveNewReadingList stores a new list and responds with it
+func (s *Server) saveNewReadingList(w http.ResponseWriter, r *http.Request, list *ReadingList) {
+	store := s.readingLists
+	store.mu.Lock()
+	store.Lists[list.ID] = list
+	err := store.save()
+	result := s.readingListFor(r, list)
+	store.mu.Unlock()
+	if err != nil {
+		respondError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, result)
+}
+
+// handleReadingList returns (GET), renames (PUT) or deletes (DELETE) one reading list
+func (s *Server) handleReadingList(w http.ResponseWriter, r *http.Request) {
+	store := s.readingLists
+	id := mux.Vars(r)["id"]
+
+	if r.Method != "GET" && !s.checkCollections(w, r) {
+		return
+	}
+	var req struct {
+		Name string `json:"name"`
+	}
+	if r.Method == "PUT" {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	store.mu.Lock()
+	list, ok := store.Lists[id]
+	if !ok {
+		store.mu.Unlock()
+		respondError(w, "Reading list not found", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		result := s.readingListFor(r, list)
+		store.mu.Unlock()
+
+		items := make([]*fileItem, len(result.Entries))
+		for i, entry := range result.Entries {
+			if entry.Path != "" {
+				items[i], _ = s.fileItemFor(entry.Path)
+			}
+		}
+		respondJSON(w, struct {
+			ReadingList
+			Books []*fileItem `json:"books"` // parallel to entries; null when unmatched or missing
+		}{result, items})
+		return
+
+	case "PUT":
+		if name := strings.TrimSpace(req.Name); name != "" {
+			list.Name = name
+		}
+		list.Updated = time.Now()
+
+	case "DELETE":
+		delete(store.Lists, id)
+		err := store.save()
+		store.mu.Unlock()
+		if err != nil {
+			respondError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		respondJSON(w, struct {
+			Success bool `json:"success"`
+		}{true})
+		return
+	}
+
+	err := store.save()
+	result := s.readingListFor(r, list)
+	store.mu.Unlock()
+	if err != nil {
+		respondError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, result)
+}
+
+// handleReadingListEntries removes, reorders and adds entries, in that order
+func (s *Server) handleReadingListEntries(w http.ResponseWriter, r *http.Request) {
+	store := s.readingLists
+	id := mux.Vars(r)["id"]
+	if !s.checkCollections(w, r) {
+		return
+	}
+
+	var req struct {
+		Remove []int `json:"remove"`
+		Move   *struct {
+			From int `json:"from"`
+			To   int `json:"to"`
+		} `json:"move"`
+		Add      []string `json:"add"`
+		Position *int     `json:"position"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	added := make([]ReadingListEntry, 0, len(req.Add))
+	for _, book := range req.Add {
+		canonical, err := s.canonicalBookPath(r, book)
+		if err != nil {
+			respondError(w, "Invalid book path: "+book, http.StatusBadRequest)
+			return
+		}
+		added = append(added, readingListEntryFor(canonical))
+	}
+
+	store.mu.Lock()
+	list, ok := store.Lists[id]
+	if !ok {
+		store.mu.Unlock()
+		respondError(w, "Reading list not found", http.StatusNotFound)
+		return
+	}
+	entries := append([]ReadingListEntry(nil), list.Entries...)
+
+	remove := append([]int(nil), req.Remove...)
+	sort.Sort(sort.Reverse(sort.IntSlice(remove)))
+	for i, index := range remove {
+		if index < 0 || index >= len(entries) || (i > 0 && remove[i-1] == index) {
+			store.mu.Unlock()
+			respondError(w, fmt.Sprintf("Invalid entry index: %d", index), http.StatusBadRequest)
+			return
+		}
+		entries = append(entries[:index], entries[index+1:]...)
+	}
+
+	if req.Move != nil {
+		from, to := req.Move.From, req.Move.To
+		if from < 0 || from >= len(entries) || to < 0 || to >= len(entries) {
+			store.mu.Unlock()
+			respondError(w, "Invalid move", http.StatusBadRequest)
+			return
+		}
+		entry := entries[from]
+		entries = append(entries[:from], entries[from+1:]...)
+		entries = append(entries[:to], append([]ReadingListEntry{entry}, entries[to:]...)...)
+	}
+
+	position := len(entries)
+	if req.Position != nil {
+		if *req.Position < 0 || *req.Position > len(entries) {
+			store.mu.Unlock()
+			respondError(w, "Invalid position", http.StatusBadRequest)
+			return
+		}
+		position = *req.Position
+	}
+	entries = append(entries[:position], append(added, entries[position:]...)...)
+
+	list.Entries = entries
+	list.Updated = time.Now()
+	err := store.save()
+	result := s.readingListFor(r, list)
+	store.mu.Unlock()
+	if err != nil {
+		respondError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, result)
+}
+
+// handleReadingListProgress marks entries of a list read or unread in the
+// requester's own progress
+func (s *Server) handleReadingListProgress(w http.ResponseWriter, r *http.Request) {
+	store := s.readingLists
+	id := mux.Vars(r)["id"]
+
+	var req struct {
+		Index int  `json:"index"`
+		Read  bool `json:"read"`
+		// Through marks every entry up to and including Index
+		Through bool `json:"through"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	store.mu.Lock()
+	list, ok := store.Lists[id]
+	if !ok {
+		store.mu.Unlock()
+		respondError(w, "Reading list not found", http.StatusNotFound)
+		return
+	}
+	result := list.snapshot()
+	store.mu.Unlock()
+	result.hideInaccessible(s.requestAccess(r))
+	if req.Index < 0 || req.Index >= len(result.Entries) {
+		respondError(w, "Invalid entry index", http.StatusBadRequest)
+		return
+	}
+	start := req.Index
+	if req.Through {
+		start = 0
+	}
+
+	books := make(map[string]string) // book ID -> request path
+	for _, entry := range result.Entries[start : req.Index+1] {
+		if entry.Path == "" {
+			continue
+		}
+		resolved, err := s.resolvePath(entry.Path)
+		if err != nil {
+			continue
+		}
+		if id, err := s.bookID(resolved); err == nil {
+			books[id] = entry.Path
+		}
+	}
+	user := requestUserName(r)
+	if err := s.progress.setRead(user, books, req.Read, time.Now()); err != nil {
+		respondError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	result.markRead(s.completedPaths(user))
+	respondJSON(w, result)
+}
+
+// handleReadingListImport creates a reading list from a ComicRack .cbl file.
+// The file is accepted as the raw request body or as the "file" field of a multipart form.
+func (s *Server) handleReadingListImport(w http.ResponseWriter, r *http.Request) {
+	if !s.checkCollections(w, r) {
+		return
+	}
+	var reader io.Reader = http.MaxBytesReader(w, r.Body, maxCBLSize)
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		r.Body = http.MaxBytesReader(w, r.Body, maxCBLSize)
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			respondError(w, "A .cbl file is required", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		reader = file
+	}
+
+	var cbl cblReadingList
+	if err := xml.NewDecoder(reader).Decode(&cbl); err != nil {
+		respondError(w, fmt.Sprintf("Invalid .cbl file: %v", err), http.StatusBadRequest)
+		return
+	}
+	name := strings.TrimSpace(cbl.Name)
+	if name == "" {
+		name = "Imported reading list"
+	}
+
+	index, err := s.buildLibraryBookIndex()
+	if err != nil {
+		respondError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	access := s.requestAccess(r)
+	entries := make([]ReadingListEntry, 0, len(cbl.Books))
+	for _, book := range cbl.Books {
+		path := index.match(book)
+		if !access.canSeePath(path) {
+			path = ""
+		}
+		entries = append(entries, ReadingListEntry{
+			Path: path, Series: book.Series, Number: book.Number,
+			Volume: book.Volume, Year: book.Year,
+		})
+	}
+
+	now := time.Now()
+	s.saveNewReadingList(w, r, &ReadingList{ID: newID(), Name: name, Entries: entries, Created: now, Updated: now})
+}
+
+// handleReadingListExport downloads a reading list as a ComicRack .cbl file
+func (s *Server) handleReadingListExport(w http.ResponseWriter, r *http.Request) {
+	store := s.readingLists
+	id := mux.Vars(r)["id"]
+
+	store.mu.Lock()
+	list, ok := store.Lists[id]
+	if !ok {
+		store.mu.Unlock()
+		respondError(w, "Reading list not found", http.StatusNotFound)
+		return
+	}
+	result := list.snapshot()
+	store.mu.Unlock()
+
+	result.hideInaccessible(s.requestAccess(r))
+	cbl := cblReadingList{Name: result.Name, Books: make([]cblBook, 0, len(result.Entries))}
+	for _, entry := range result.Entries {
+		book := cblBook{Series: entry.Series, Number: entry.Number, Volume: entry.Volume, Year: entry.Year}
+		if entry.Path != "" {
+			book.File = filepath.Base(entry.Path)
+		}
+		cbl.Books = append(cbl.Books, book)
+	}
+
+	data, err := xml.MarshalIndent(cbl, "", "  ")
+	if err != nil {
+		respondError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	filename := strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`<>:"/\|?*`, r) || r < 0x20 {
+			return '_'
+		}
+		return r
+	}, result.Name) + ".cbl"
+	w.Header().Set("Content-Type", "application/xml")
+	w.Header().Set("Content-Disposition", "attachment; filename*=UTF-8''"+url.PathEscape(filename))
+	w.Write([]byte(xml.Header))
+	w.Write(data)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+const testCBL = `<?xml version="1.0"?>
+<ReadingList xmlns:xsd="http://www.w3.org/2001/XMLSchema" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+  <Name>Crossover Event</Name>
+  <Books>
+    <Book Series="Hero" Number="2" Volume="2011" Year="2012"><Id>a</Id></Book>
+    <Book Series="Villain" Number="1" Volume="2011" Year="2012"><Id>b</Id></Book>
+    <Book Series="Unknown" Number="9" Volume="1999" Year="1999"><Id>c</Id></Book>
+  </Books>
+</ReadingList>`
+
+func TestReadingListImportReorderAndExport(t *testing.T) {
+	t.Setenv("DATA_DIR", t.TempDir())
+	root := t.TempDir()
+	for _, name := range []string{"Hero/Hero 002 (2011).cbz", "Villain/Villain v01.cbz"} {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	server := initServer(&Config{Roots: []RootConfig{{Path: root, Name: "Root"}}})
+
+	response := httptest.NewRecorder()
+	server.handleReadingListImport(response, httptest.NewRequest(http.MethodPost, "/api/readinglists/import", bytes.NewBufferString(testCBL)))
+	if response.Code != http.StatusOK {
+		t.Fatalf("import status = %d; body = %s", response.Code, response.Body.String())
+	}
+	var list ReadingList
+	if err := json.Unmarshal(response.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if list.Name != "Crossover Event" || len(list.Entries) != 3 {
+		t.Fatalf("imported list = %+v", list)
+	}
+	if list.Entries[0].Path != filepath.Join("Root", "Hero", "Hero 002 (2011).cbz") ||
+		list.Entries[1].Path != filepath.Join("Root", "Villain", "Villain v01.cbz") ||
+		list.Entries[2].Path != "" {
+		t.Fatalf("matched entries = %+v", list.Entries)
+	}
+
+	vars := map[string]string{"id": list.ID}
+	response = httptest.NewRecorder()
+	server.handleReadingListEntries(response, mux.SetURLVars(httptest.NewRequest(http.MethodPost, "/",
+		bytes.NewBufferString(`{"remove":[2],"move":{"from":1,"to":0}}`)), vars))
+	if response.Code != http.StatusOK {
+		t.Fatalf("reorder status = %d; body = %s", response.Code, response.Body.String())
+	}
+	response = httptest.NewRecorder()
+	server.handleReadingListProgress(response, mux.SetURLVars(httptest.NewRequest(http.MethodPost, "/",
+		bytes.NewBufferString(`{"index":0,"read":true}`)), vars))
+	if err := json.Unmarshal(response.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Entries) != 2 || list.Entries[0].Series != "Villain" || list.Current != 1 {
+		t.Fatalf("updated list = %+v", list)
+	}
+
+	response = httptest.NewRecorder()
+	server.handleReadingListExport(response, mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/", nil), vars))
+	var exported cblReadingList
+	if err := xml.Unmarshal(response.Body.Bytes(), &exported); err != nil {
+		t.Fatalf("export is not valid XML: %v; body = %s", err, response.Body.String())
+	}
+	if exported.Name != "Crossover Event" || len(exported.Books) != 2 ||
+		exported.Books[0].Series != "Villain" || exported.Books[1].Number != "2" {
+		t.Fatalf("exported list = %+v", exported)
+	}
+}
+
+func TestReadingListsRequireCapabilityAndTrackReadPerUser(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"Saga v01.cbz", "Saga v02.cbz"} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	server := newAuthTestServerWithConfig(t, &Config{
+		Roots:  []RootConfig{{Path: root, Name: "Root"}},
+		Groups: map[string]AccessRule{"kids": {Roots: []string{"Root"}}},
+	})
+	admin := sessionCookie(t, authRequest(t, server, "POST", "/api/settings/users", map[string]interface{}{"name": "parent", "password": "parentpass"}))
+	authRequest(t, server, "POST", "/api/settings/users", map[string]interface{}{"name": "kid", "password": "kidpass1", "groups": []string{"kids"}}, admin)
+	kid := sessionCookie(t, authRequest(t, server, "POST", "/api/auth/login", map[string]string{"name": "kid", "password": "kidpass1"}))
+
+	response := authRequest(t, server, "POST", "/api/readinglists", map[string]interface{}{"name": "Saga", "books": []string{"Root/Saga v01.cbz", "Root/Saga v02.cbz"}}, admin)
+	var list ReadingList
+	if err := json.Unmarshal(response.Body.Bytes(), &list); err != nil || response.Code != http.StatusOK {
+		t.Fatalf("admin create = %d; body = %s", response.Code, response.Body.String())
+	}
+	for _, request := range []struct{ method, target string }{
+		{"POST", "/api/readinglists"},
+		{"PUT", "/api/readinglists/" + list.ID},
+		{"DELETE", "/api/readinglists/" + list.ID},
+		{"POST", "/api/readinglists/" + list.ID + "/entries"},
+		{"POST", "/api/readinglists/import"},
+	} {
+		if response := authRequest(t, server, request.method, request.target, map[string]interface{}{"name": "x"}, kid); response.Code != http.StatusForbidden {
+			t.Fatalf("kid %s %s = %d, want 403", request.method, request.target, response.Code)
+		}
+	}
+
+	// Marking an entry read changes only the requester's progress
+	response = authRequest(t, server, "POST", "/api/readinglists/"+list.ID+"/progress", map[string]interface{}{"index": 0, "read": true}, kid)
+	if err := json.Unmarshal(response.Body.Bytes(), &list); err != nil || !list.Entries[0].Read || list.Current != 1 {
+		t.Fatalf("kid progress = %d; body = %s", response.Code, response.Body.String())
+	}
+	if response := authRequest(t, server, "GET", "/api/book/Root/Saga%20v01.cbz/progress", nil, kid); response.Code != http.StatusOK {
+		t.Fatalf("kid book progress = %d", response.Code)
+	}
+	response = authRequest(t, server, "GET", "/api/readinglists/"+list.ID, nil, admin)
+	list = ReadingList{}
+	if err := json.Unmarshal(response.Body.Bytes(), &list); err != nil || list.Entries[0].Read || list.Current != 0 {
+		t.Fatalf("admin list = %s", response.Body.String())
+	}
+}
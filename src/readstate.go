@@ -0,0 +1,298 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// readCountScanTTL is how long a library scan is reused for folder read counts
+const readCountScanTTL = 5 * time.Minute
+
+// readState is how far a user got in a book
+type readState int
+
+const (
+	readStateUnread readState = iota
+	readStateInProgress
+	readStateRead
+)
+
+// readStateOf returns the state a progress record stands for; nil is unread
+func readStateOf(record *progressRecord) readState {
+	switch {
+	case record == nil:
+		return readStateUnread
+	case record.Completed:
+		return readStateRead
+	default:
+		return readStateInProgress
+	}
+}
+
+// bookCounts summarizes the books in a directory and everything below it
+type bookCounts struct {
+	Total      int `json:"total"`
+	Read       int `json:"read"`
+	InProgress int `json:"inProgress"`
+}
+
+// userReadCounts holds the read and in-progress counts of one user per directory
+type userReadCounts struct {
+	states map[string]readState   // book request path -> state, unread books omitted
+	dirs   map[string]*bookCounts // directory request path -> counts (Total unused)
+}
+
+// ReadCountCache keeps per-directory book totals from a library scan and per-user
+// read counts that are updated as progress changes, so listings never walk the tree
+type ReadCountCache struct {
+	mu      sync.Mutex
+	books   map[string]bool // request paths of every book
+	totals  map[string]int  // directory request path -> books in its subtree
+	scanned time.Time
+	users   map[string]*userReadCounts
+	changes map[string]int // user -> progress changes seen, to detect changes during a rebuild
+	version int            // incremented by invalidate, to discard scans that started before
+
+	scanMu sync.Mutex // serializes library scans, which run without holding mu
+}
+
+func newReadCountCache() *ReadCountCache {
+	return &ReadCountCache{users: make(map[string]*userReadCounts), changes: make(map[string]int)}
+}
+
+// invalidate forces the next request to rescan the library
+func (c *ReadCountCache) invalidate() {
+	c.mu.Lock()
+	c.books, c.totals, c.scanned = nil, nil, time.Time{}
+	c.users = make(map[string]*userReadCounts)
+	c.version++
+	c.mu.Unlock()
+}
+
+// forEachAncestor calls fn for every directory above a request path, up to its root
+func forEachAncestor(p string, fn func(dir string)) {
+	for dir := filepath.Dir(p); dir != "." && dir != string(filepath.Separator); dir = filepath.Dir(dir) {
+		fn(dir)
+	}
+}
+
+// add moves a book between states in the counts of its directories
+func (u *userReadCounts) add(p string, state readState) {
+	before := u.states[p]
+	if before == state {
+		return
+	}
+	forEachAncestor(p, func(dir string) {
+		counts, ok := u.dirs[dir]
+		if !ok {
+			counts = &bookCounts{}
+			u.dirs[dir] = counts
+		}
+		switch before {
+		case readStateRead:
+			counts.Read--
+		case readStateInProgress:
+			counts.InProgress--
+		}
+		switch state {
+		case readStateRead:
+			counts.Read++
+		case readStateInProgress:
+			counts.InProgress++
+		}
+	})
+	if state == readStateUnread {
+		delete(u.states, p)
+	} else {
+		u.states[p] = state
+	}
+}
+
+// apply updates the counts of a user after the progress in a book changed
+func (c *ReadCountCache) apply(user, p string, state readState) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.changes[user]++
+	if counts, ok := c.users[user]; ok && c.books[p] {
+		counts.add(p, state)
+	}
+}
+
+// removeUser drops the counts of a deleted account
+func (c *ReadCountCache) removeUser(user string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.users, user)
+	delete(c.changes, user)
+}
+
+// scanReadCounts rebuilds the library totals when they are missing or stale. The
+// library is walked without holding mu, so page views that update read counts
+// are not blocked by the scan; the totals are swapped in when it completes.
+func (s *Server) scanReadCounts() {
+	c := s.readCounts
+	c.scanMu.Lock()
+	defer c.scanMu.Unlock()
+	c.mu.Lock()
+	fresh := c.totals != nil && time.Since(c.scanned) < readCountScanTTL
+	version := c.version
+	c.mu.Unlock()
+	if fresh {
+		return
+	}
+
+	books := make(map[string]bool)
+	totals := make(map[string]int)
+	s.walkLibrary(func(item fileItem, fullPath string) error {
+		if item.Type == "book" {
+			books[item.Path] = true
+			forEachAncestor(item.Path, func(dir string) { totals[dir]++ })
+		}
+		return nil
+	})
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.version != version {
+		// The library changed during the walk; the next request scans again
+		return
+	}
+	c.books, c.totals, c.scanned = books, totals, time.Now()
+	c.users = make(map[string]*userReadCounts)
+}
+
+// bookCountsFor returns the book counts of a user for directories by request path
+func (s *Server) bookCountsFor(user string, dirs []string) map[string]bookCounts {
+	c := s.readCounts
+	for {
+		s.scanReadCounts()
+		c.mu.Lock()
+		counts, ok := c.users[user]
+		if ok && c.books != nil {
+			result := make(map[string]bookCounts, len(dirs))
+			for _, dir := range dirs {
+				result[dir] = bookCounts{Total: c.totals[dir]}
+				if userCounts, ok := counts.dirs[dir]; ok {
+					result[dir] = bookCounts{Total: c.totals[dir], Read: userCounts.Read, InProgress: userCounts.InProgress}
+				}
+			}
+			c.mu.Unlock()
+			return result
+		}
+		seen, scanned := c.changes[user], c.scanned
+		c.mu.Unlock()
+
+		// Progress is read without holding mu, since progress changes call apply
+		records := s.progress.forUser(user)
+		c.mu.Lock()
+		if c.changes[user] == seen && c.scanned.Equal(scanned) && c.books != nil {
+			counts = &userReadCounts{states: make(map[string]readState), dirs: make(map[string]*bookCounts)}
+			for _, record := range records {
+				if c.books[record.Path] {
+					counts.add(record.Path, readStateOf(&record))
+				}
+			}
+			c.users[user] = counts
+		}
+		c.mu.Unlock()
+	}
+}
+
+// attachBookCounts adds the requester's book counts to listed directories
+func (s *Server) attachBookCounts(r *http.Request, items []fileItem) {
+	dirs := make([]string, 0, len(items))
+	for _, item := range items {
+		if item.Type == "directory" {
+			dirs = append(dirs, item.Path)
+		}
+	}
+	if len(dirs) == 0 {
+		return
+	}
+	counts := s.bookCountsFor(requestUserName(r), dirs)
+	for i := range items {
+		if items[i].Type == "directory" {
+			itemCounts := counts[items[i].Path]
+			items[i].Books = &itemCounts
+		}
+	}
+}
+
+// booksBelow returns the request paths of the books at or below a request path
+func (s *Server) booksBelow(p string) []string {
+	c := s.readCounts
+	for {
+		s.scanReadCounts()
+		c.mu.Lock()
+		if c.books != nil {
+			break
+		}
+		c.mu.Unlock()
+	}
+	defer c.mu.Unlock()
+	result := make([]string, 0)
+	prefix := p + string(filepath.Separator)
+	for book := range c.books {
+		if book == p || strings.HasPrefix(book, prefix) {
+			result = append(result, book)
+		}
+	}
+	return result
+}
+
+// handleMarkRead marks books, or every book in folders, read or unread for the
+// requester. Unread books lose their progress; read books keep their page.
+func (s *Server) handleMarkRead(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Paths []string `json:"paths"`
+		Read  bool     `json:"read"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Paths) == 0 {
+		respondError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	books := make(map[string]string) // book ID -> request path
+	for _, requestPath := range req.Paths {
+		resolved, err := s.resolveRequestPath(r, requestPath)
+		if err != nil {
+			respondError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		info, err := os.Stat(resolved.FullPath)
+		if err != nil {
+			respondError(w, "Path not found", http.StatusNotFound)
+			return
+		}
+		paths := []string{resolved.RequestPath()}
+		if info.IsDir() {
+			paths = s.booksBelow(resolved.RequestPath())
+		} else if !isArchiveFile(resolved.FullPath) {
+			respondError(w, "Not a book: "+requestPath, http.StatusBadRequest)
+			return
+		}
+		for _, p := range paths {
+			book, err := s.resolvePath(p)
+			if err != nil {
+				continue
+			}
+			if id, err := s.bookID(book); err == nil {
+				books[id] = p
+			}
+		}
+	}
+
+	if err := s.progress.setRead(requestUserName(r), books, req.Read, time.Now()); err != nil {
+		respondError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, struct {
+		Status string `json:"status"`
+		Books  int    `json:"books"`
+	}{"ok", len(books)})
+}
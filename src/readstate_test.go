@@ -0,0 +1,72 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFolderReadCounts(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"Saga/a1.cbz", "Saga/a2.cbz", "Saga/a3.cbz", "Saga/Extras/b1.cbz", "Other/o1.cbz"} {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		writeTestZip(t, path, zip.Store, map[string]string{"01.jpg": name, "02.jpg": "b"}, []string{"01.jpg", "02.jpg"})
+	}
+	server := newAuthTestServerWithConfig(t, &Config{Roots: []RootConfig{{Path: root, Name: "Root"}}})
+
+	counts := func(target string) map[string]bookCounts {
+		t.Helper()
+		var listing struct {
+			Files []fileItem `json:"files"`
+		}
+		response := authRequest(t, server, "GET", target, nil)
+		if err := json.Unmarshal(response.Body.Bytes(), &listing); err != nil || response.Code != http.StatusOK {
+			t.Fatalf("%s = %d; body = %s", target, response.Code, response.Body.String())
+		}
+		result := make(map[string]bookCounts)
+		for _, item := range listing.Files {
+			if item.Books != nil {
+				result[item.Name] = *item.Books
+			}
+		}
+		return result
+	}
+	mark := func(read bool, paths ...string) {
+		t.Helper()
+		if response := authRequest(t, server, "POST", "/api/progress/read", map[string]interface{}{"paths": paths, "read": read}); response.Code != http.StatusOK {
+			t.Fatalf("mark = %d; body = %s", response.Code, response.Body.String())
+		}
+	}
+
+	if got := counts("/api/dir/Root"); got["Saga"] != (bookCounts{Total: 4}) || got["Other"] != (bookCounts{Total: 1}) {
+		t.Fatalf("initial counts = %+v", got)
+	}
+
+	mark(true, "Root/Saga/Extras")
+	authRequest(t, server, "PUT", "/api/book/Root/Saga/a1.cbz/progress", map[string]interface{}{"page": 1})
+	if got := counts("/api/dir/Root"); got["Saga"] != (bookCounts{Total: 4, Read: 1, InProgress: 1}) {
+		t.Fatalf("after reading = %+v", got)
+	}
+	if got := counts("/api/dir"); got["Root"] != (bookCounts{Total: 5, Read: 1, InProgress: 1}) {
+		t.Fatalf("root list counts = %+v", got)
+	}
+
+	mark(true, "Root/Saga/a1.cbz", "Root/Other")
+	if got := counts("/api/dir"); got["Root"] != (bookCounts{Total: 5, Read: 3}) {
+		t.Fatalf("after marking read = %+v", got)
+	}
+
+	mark(false, "Root/Saga")
+	if got := counts("/api/dir/Root"); got["Saga"] != (bookCounts{Total: 4}) || got["Other"] != (bookCounts{Total: 1, Read: 1}) {
+		t.Fatalf("after marking unread = %+v", got)
+	}
+	if response := authRequest(t, server, "GET", "/api/book/Root/Saga/a1.cbz/progress", nil); response.Code != http.StatusNotFound {
+		t.Fatalf("unread book progress = %d, want 404", response.Code)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRecentLimit = 50
+	maxRecentLimit     = 1000
+	// recentScanTTL is how long a library scan is reused for the recent feed
+	recentScanTTL = time.Minute
+)
+
+// RecentCache keeps the media files of the last library scan, newest first
+type RecentCache struct {
+	mu      sync.Mutex
+	items   []fileItem
+	scanned time.Time
+	version int // incremented by invalidate, to discard scans that started before
+
+	scanMu sync.Mutex // serializes library scans, which run without holding mu
+}
+
+// invalidate forces the next request to rescan the library
+func (c *RecentCache) invalidate() {
+	c.mu.Lock()
+	c.items, c.scanned = nil, time.Time{}
+	c.version++
+	c.mu.Unlock()
+}
+
+// cached returns the items of a scan that is still fresh, and the current version
+func (c *RecentCache) cached() ([]fileItem, int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.items, c.version, c.items != nil && time.Since(c.scanned) < recentScanTTL
+}
+
+// recentItems returns all books and media across roots ordered by modification time,
+// newest first. The library is walked without holding mu, so invalidations from
+// file operations are not blocked by the scan; the result is swapped in when it completes.
+func (s *Server) recentItems() ([]fileItem, error) {
+	c := s.recent
+	if items, _, ok := c.cached(); ok {
+		return items, nil
+	}
+	c.scanMu.Lock()
+	defer c.scanMu.Unlock()
+	// Another request may have finished a scan while this one waited
+	items, version, ok := c.cached()
+	if ok {
+		return items, nil
+	}
+
+	items = make([]fileItem, 0)
+	err := s.walkLibrary(func(item fileItem, fullPath string) error {
+		if item.Type != "file" {
+			items = append(items, item)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(items, func(i, j int) bool { return items[i].Modified.After(items[j].Modified) })
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// When the library changed during the walk, this request still gets the
+	// result but the next one scans again
+	if c.version == version {
+		c.items, c.scanned = items, time.Now()
+	}
+	return items, nil
+}
+
+// parseSince accepts RFC 3339 timestamps or Unix seconds
+func parseSince(value string) (time.Time, bool) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, true
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), true
+	}
+	return time.Time{}, false
+}
+
+// handleRecent lists the newest books and media across all roots
+func (s *Server) handleRecent(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	limit := defaultRecentLimit
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxRecentLimit {
+			respondError(w, "limit must be between 1 and "+strconv.Itoa(maxRecentLimit), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	var since time.Time
+	if value := query.Get("since"); value != "" {
+		var ok bool
+		if since, ok = parseSince(value); !ok {
+			respondError(w, "since must be an RFC 3339 time or Unix seconds", http.StatusBadRequest)
+			return
+		}
+	}
+	types := map[string]bool{"book": true, "video": true, "audio": true}
+	if value := query.Get("type"); value != "" {
+		types = make(map[string]bool)
+		for _, fileType := range strings.Split(value, ",") {
+			if fileType != "book" && fileType != "video" && fileType != "audio" {
+				respondError(w, "invalid type: "+fileType, http.StatusBadRequest)
+				return
+			}
+			types[fileType] = true
+		}
+	}
+
+	items, err := s.recentItems()
+	if err != nil {
+		respondError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	items = s.requestAccess(r).filterItems(items)
+
+	files := make([]fileItem, 0, limit)
+	for _, item := range items {
+		if !since.IsZero() && !item.Modified.After(since) {
+			break
+		}
+		if types[item.Type] {
+			files = append(files, item)
+			if len(files) == limit {
+				break
+			}
+		}
+	}
+	respondJSONWithETag(w, r, struct {
+		Files []fileItem `json:"files"`
+	}{files})
+}
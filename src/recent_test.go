@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHandleRecentOrdersAcrossRoots(t *testing.T) {
+	rootA, rootB := t.TempDir(), t.TempDir()
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	files := []struct {
+		root, name string
+		age        time.Duration
+	}{
+		{rootA, "old.cbz", 72 * time.Hour},
+		{rootA, "series/new.cbz", time.Hour},
+		{rootB, "middle.mp4", 24 * time.Hour},
+		{rootB, "notes.txt", 0},
+	}
+	for _, f := range files {
+		path := filepath.Join(f.root, filepath.FromSlash(f.name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		modified := base.Add(-f.age)
+		if err := os.Chtimes(path, modified, modified); err != nil {
+			t.Fatal(err)
+		}
+	}
+	server := initServer(&Config{Roots: []RootConfig{{Path: rootA, Name: "A"}, {Path: rootB, Name: "B"}}})
+
+	recent := func(query string) string {
+		response := httptest.NewRecorder()
+		server.handleRecent(response, httptest.NewRequest(http.MethodGet, "/api/recent?"+query, nil))
+		if response.Code != http.StatusOK {
+			t.Fatalf("status = %d; body = %s", response.Code, response.Body.String())
+		}
+		var result struct {
+			Files []fileItem `json:"files"`
+		}
+		if err := json.Unmarshal(response.Body.Bytes(), &result); err != nil {
+			t.Fatal(err)
+		}
+		names := make([]string, len(result.Files))
+		for i := range result.Files {
+			names[i] = result.Files[i].Name
+		}
+		return fmt.Sprint(names)
+	}
+
+	if got := recent(""); got != "[new.cbz middle.mp4 old.cbz]" {
+		t.Fatalf("recent = %s", got)
+	}
+	if got := recent("limit=1"); got != "[new.cbz]" {
+		t.Fatalf("limited = %s", got)
+	}
+	if got := recent(fmt.Sprintf("since=%d", base.Add(-48*time.Hour).Unix())); got != "[new.cbz middle.mp4]" {
+		t.Fatalf("since = %s", got)
+	}
+	if got := recent("type=book"); got != "[new.cbz old.cbz]" {
+		t.Fatalf("books = %s", got)
+	}
+}
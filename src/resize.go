@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"strconv"
+
+	_ "image/gif"
+	_ "image/png"
+)
+
+const (
+	// maxImageWidth caps the width parameter of resized pages
+	maxImageWidth = 2048
+	// resizedJPEGQuality is the quality of resized pages, which are always JPEG
+	resizedJPEGQuality = 85
+	// maxResizePixels is the largest page decoded for resizing; bigger pages are served as they are
+	maxResizePixels = 64 << 20
+)
+
+// parseImageWidth parses the width parameter of a page request; 0 means full size
+func parseImageWidth(value string) (int, bool) {
+	if value == "" {
+		return 0, true
+	}
+	width, err := strconv.Atoi(value)
+	if err != nil || width <= 0 || width > maxImageWidth {
+		return 0, false
+	}
+	return width, true
+}
+
+// pixelReader returns a function reading the 8-bit RGBA values of a source pixel.
+// The common decoded types are read from their pixel buffers directly, which
+// avoids a color.Color allocation per pixel.
+func pixelReader(src image.Image) func(x, y int) (r, g, b, a uint32) {
+	switch src := src.(type) {
+	case *image.YCbCr:
+		return func(x, y int) (uint32, uint32, uint32, uint32) {
+			yi, ci := src.YOffset(x, y), src.COffset(x, y)
+			r, g, b := color.YCbCrToRGB(src.Y[yi], src.Cb[ci], src.Cr[ci])
+			return uint32(r), uint32(g), uint32(b), 0xff
+		}
+	case *image.RGBA:
+		return func(x, y int) (uint32, uint32, uint32, uint32) {
+			p := src.Pix[src.PixOffset(x, y):]
+			return uint32(p[0]), uint32(p[1]), uint32(p[2]), uint32(p[3])
+		}
+	case *image.Gray:
+		return func(x, y int) (uint32, uint32, uint32, uint32) {
+			v := uint32(src.Pix[src.PixOffset(x, y)])
+			return v, v, v, 0xff
+		}
+	}
+	return func(x, y int) (uint32, uint32, uint32, uint32) {
+		r, g, b, a := src.At(x, y).RGBA()
+		return r >> 8, g >> 8, b >> 8, a >> 8
+	}
+}
+
+// resizeImage scales an image down to width, averaging the source pixels each
+// output pixel covers. It reports false when the image is already narrow enough,
+// too large to decode safely or in a format that cannot be decoded (such as WebP),
+// so the original is served.
+func resizeImage(data []byte, width int) ([]byte, bool) {
+	// The header gives the size without decoding, so oversized pages cost no memory
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || config.Width <= width || config.Width*config.Height > maxResizePixels {
+		return nil, false
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, false
+	}
+	bounds := src.Bounds()
+	if bounds.Dx() <= width {
+		return nil, false
+	}
+	height := max(1, bounds.Dy()*width/bounds.Dx())
+
+	pixel := pixelReader(src)
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := bounds.Min.Y + y*bounds.Dy()/height
+		y1 := max(y0+1, bounds.Min.Y+(y+1)*bounds.Dy()/height)
+		for x := 0; x < width; x++ {
+			x0 := bounds.Min.X + x*bounds.Dx()/width
+			x1 := max(x0+1, bounds.Min.X+(x+1)*bounds.Dx()/width)
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := pixel(sx, sy)
+					r, g, b, a, n = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa), n+1
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{uint8(r / n), uint8(g / n), uint8(b / n), uint8(a / n)})
+		}
+	}
+
+	var out bytes.Buffer
+	if err := jpeg.Encode(&out, dst, &jpeg.Options{Quality: resizedJPEGQuality}); err != nil {
+		return nil, false
+	}
+	return out.Bytes(), true
+}
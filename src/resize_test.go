@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func TestResizeImage(t *testing.T) {
+	fill := color.RGBA{200, 40, 40, 255}
+	encode := map[string]func(image.Image) []byte{
+		"jpeg": func(img image.Image) []byte {
+			var buf bytes.Buffer
+			if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 100}); err != nil {
+				t.Fatal(err)
+			}
+			return buf.Bytes()
+		},
+		"png": func(img image.Image) []byte {
+			var buf bytes.Buffer
+			if err := png.Encode(&buf, img); err != nil {
+				t.Fatal(err)
+			}
+			return buf.Bytes()
+		},
+	}
+	src := image.NewRGBA(image.Rect(0, 0, 400, 600))
+	for i := 0; i < len(src.Pix); i += 4 {
+		src.Pix[i], src.Pix[i+1], src.Pix[i+2], src.Pix[i+3] = fill.R, fill.G, fill.B, fill.A
+	}
+	for format, encode := range encode {
+		data, ok := resizeImage(encode(src), 100)
+		if !ok {
+			t.Fatalf("%s: not resized", format)
+		}
+		img, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil || img.Bounds().Dx() != 100 || img.Bounds().Dy() != 150 {
+			t.Fatalf("%s: resized = %v, %v", format, img, err)
+		}
+		r, g, b, _ := img.At(50, 75).RGBA()
+		near := func(got uint32, want uint8) bool { return int(got>>8) >= int(want)-8 && int(got>>8) <= int(want)+8 }
+		if !near(r, fill.R) || !near(g, fill.G) || !near(b, fill.B) {
+			t.Fatalf("%s: color = %d %d %d", format, r>>8, g>>8, b>>8)
+		}
+		if _, ok := resizeImage(encode(src), 400); ok {
+			t.Fatalf("%s: resized to its own width", format)
+		}
+	}
+
+	// A header claiming a huge page is refused before any pixels are decoded
+	header := make([]byte, 13)
+	binary.BigEndian.PutUint32(header[0:], 100000)
+	binary.BigEndian.PutUint32(header[4:], 100000)
+	header[8], header[9] = 8, 2 // 8-bit RGB
+	chunk := append([]byte("IHDR"), header...)
+	huge := append([]byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0d"), chunk...)
+	huge = binary.BigEndian.AppendUint32(huge, crc32.ChecksumIEEE(chunk))
+	if _, ok := resizeImage(huge, 100); ok {
+		t.Fatal("oversized page resized")
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bodgit/sevenzip"
+	"github.com/gorilla/mux"
+	"github.com/maruel/natural"
+	"github.com/nwaples/rardecode/v2"
+	"golang.org/x/text/width"
+)
+
+// maxMissingVolumes caps the gap list so chapter-numbered series stay small
+const maxMissingVolumes = 200
+
+var (
+	// Bracketed tags such as [Author], (2019) or {digital} carry no series information
+	seriesTagPattern = regexp.MustCompile(`[\[\(\{【（][^\]\)\}】）]*[\]\)\}】）]`)
+	// Number patterns, tried in order. The first group is the series name, the second the number.
+	seriesNumberPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`(?i)^(.*?)[\s._-]+(?:v|vol|volume)[\s.]*(\d+(?:\.\d+)?)(?:$|[^\d])`),
+		regexp.MustCompile(`(?i)^(.*?)(?:[\s._-]+(?:c|ch|chap|chapter|ep|episode)[\s.]*|\s*#)(\d+(?:\.\d+)?)(?:$|[^\d])`),
+		regexp.MustCompile(`^(.*?)\s*第?\s*(\d+(?:\.\d+)?)\s*[巻話集部]`),
+		regexp.MustCompile(`^(.*?)[\s._-]+(\d+(?:\.\d+)?)$`),
+	}
+	// A bare trailing number in this range is a year ("Annual 2019"), not a volume
+	seriesYearPattern = regexp.MustCompile(`^(?:19|20)\d\d$`)
+)
+
+// ComicInfo holds the subset of ComicInfo.xml used for series detection
+type ComicInfo struct {
+	Series string `xml:"Series"`
+	Number string `xml:"Number"`
+	Volume string `xml:"Volume"`
+	Count  int    `xml:"Count"`
+}
+
+// parseSeriesName detects the series name and volume/chapter number from a book filename
+func parseSeriesName(filename string) (string, float64, bool) {
+	name := width.Fold.String(strings.TrimSuffix(filename, filepath.Ext(filename)))
+	name = strings.Join(strings.Fields(seriesTagPattern.ReplaceAllString(name, " ")), " ")
+
+	for i, pattern := range seriesNumberPatterns {
+		match := pattern.FindStringSubmatch(name)
+		if match == nil {
+			continue
+		}
+		if i == len(seriesNumberPatterns)-1 && seriesYearPattern.MatchString(match[2]) {
+			continue
+		}
+		series := strings.Trim(match[1], " ._-")
+		number, err := strconv.ParseFloat(match[2], 64)
+		if series == "" || err != nil {
+			continue
+		}
+		return series, number, true
+	}
+	return "", 0, false
+}
+
+// seriesKey normalizes a series name so that spacing and case differences group together
+func seriesKey(series string) string {
+	return strings.ToLower(strings.Join(strings.Fields(width.Fold.String(series)), " "))
+}
+
+// getComicInfo returns the parsed ComicInfo.xml of a book, or nil when the book has none
+func (s *Server) getComicInfo(bookPath string) *ComicInfo {
+	info, err := os.Stat(bookPath)
+	if err != nil {
+		return nil
+	}
+	if cached, ok := s.comicInfoCache.Get(bookPath, info.ModTime()); ok {
+		return cached
+	}
+
+	var comicInfo *ComicInfo
+	if data, err := extractComicInfo(bookPath); err == nil {
+		var parsed ComicInfo
+		if xml.Unmarshal(data, &parsed) == nil {
+			comicInfo = &parsed
+		}
+	}
+	s.comicInfoCache.Set(bookPath, info.ModTime(), comicInfo)
+	return comicInfo
+}
+
+func isComicInfoName(name string) bool {
+	return strings.EqualFold(path.Base(filepath.ToSlash(name)), "ComicInfo.xml")
+}
+
+func extractComicInfo(bookPath string) ([]byte, error) {
+	switch strings.ToLower(filepath.Ext(bookPath)) {
+	case ".zip", ".cbz":
+		r, err := zip.OpenReader(bookPath)
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		for _, f := range r.File {
+			if isComicInfoName(f.Name) {
+				rc, err := f.Open()
+				if err != nil {
+					return nil, err
+				}
+				defer rc.Close()
+				return io.ReadAll(rc)
+			}
+		}
+	case ".rar", ".cbr":
+		r, err := rardecode.OpenReader(bookPath)
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		for {
+			header, err := r.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			if isComicInfoName(header.Name) {
+				return io.ReadAll(r)
+			}
+		}
+	case ".7z", ".cb7":
+		r, err := sevenzip.OpenReader(bookPath)
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		for _, f := range r.File {
+			if isComicInfoName(f.Name) {
+				rc, err := f.Open()
+				if err != nil {
+					return nil, err
+				}
+				defer rc.Close()
+				return io.ReadAll(rc)
+			}
+		}
+	}
+	return nil, fmt.Errorf("ComicInfo.xml not found")
+}
+
+// seriesInfoFor prefers ComicInfo metadata and falls back to the filename
+func (s *Server) seriesInfoFor(fullPath string) (string, float64, bool) {
+	if comicInfo := s.getComicInfo(fullPath); comicInfo != nil && comicInfo.Series != "" {
+		for i, value := range []string{comicInfo.Number, comicInfo.Volume} {
+			number, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			// Volume usually holds the year the series started, not a volume number
+			if err != nil || (i == 1 && number >= 1900 && number <= 2100) {
+				continue
+			}
+			return strings.TrimSpace(comicInfo.Series), number, true
+		}
+	}
+	return parseSeriesName(filepath.Base(fullPath))
+}
+
+// applyComicInfoSeries replaces the filename series of the books listed from a
+// directory with their ComicInfo.xml metadata. Each book's archive is opened, so
+// this is only done for series grouping, never for plain listings.
+func (s *Server) applyComicInfoSeries(dir *ResolvedPath, items []fileItem) {
+	for i := range items {
+		if items[i].Type != "book" {
+			continue
+		}
+		if series, number, ok := s.seriesInfoFor(filepath.Join(dir.FullPath, items[i].Name)); ok {
+			items[i].Series, items[i].Number = series, &number
+		}
+	}
+}
+
+// seriesGroup is one detected series within a directory
+type seriesGroup struct {
+	Name    string     `json:"name"`
+	Volumes []fileItem `json:"volumes"`
+	Missing []float64  `json:"missing"`
+}
+
+// groupSeries groups books by series, ordering volumes by number and collecting gaps
+func groupSeries(items []fileItem) ([]*seriesGroup, []fileItem) {
+	groups := make(map[string]*seriesGroup)
+	var order []string
+	ungrouped := make([]fileItem, 0)
+	for _, item := range items {
+		if item.Type != "book" || item.Series == "" || item.Number == nil {
+			ungrouped = append(ungrouped, item)
+			continue
+		}
+		key := seriesKey(item.Series)
+		group, ok := groups[key]
+		if !ok {
+			group = &seriesGroup{Name: item.Series}
+			groups[key] = group
+			order = append(order, key)
+		}
+		group.Volumes = append(group.Volumes, item)
+	}
+
+	result := make([]*seriesGroup, 0, len(order))
+	for _, key := range order {
+		group := groups[key]
+		sort.SliceStable(group.Volumes, func(i, j int) bool {
+			a, b := *group.Volumes[i].Number, *group.Volumes[j].Number
+			if a != b {
+				return a < b
+			}
+			return natural.Less(group.Volumes[i].Name, group.Volumes[j].Name)
+		})
+		group.Missing = missingNumbers(group.Volumes)
+		result = append(result, group)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return natural.Less(seriesKey(result[i].Name), seriesKey(result[j].Name))
+	})
+	return result, ungrouped
+}
+
+// missingNumbers lists whole numbers absent between the first volume (or 1) and the last
+func missingNumbers(volumes []fileItem) []float64 {
+	missing := make([]float64, 0)
+	if len(volumes) == 0 {
+		return missing
+	}
+	present := make(map[float64]bool, len(volumes))
+	for _, volume := range volumes {
+		present[math.Floor(*volume.Number)] = true
+	}
+	first := math.Floor(*volumes[0].Number)
+	if first > 1 {
+		first = 1
+	}
+	last := math.Floor(*volumes[len(volumes)-1].Number)
+	for n := first; n <= last && len(missing) < maxMissingVolumes; n++ {
+		if !present[n] {
+			missing = append(missing, n)
+		}
+	}
+	return missing
+}
+
+// handleSeries groups the books of a directory into series
+func (s *Server) handleSeries(w http.ResponseWriter, r *http.Request) {
+	requestPath, _ := url.PathUnescape(mux.Vars(r)["path"])
+	resolved, err := s.resolveRequestPath(r, requestPath)
+	if err != nil {
+		respondError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	info, err := os.Stat(resolved.FullPath)
+	if err != nil || !info.IsDir() {
+		respondError(w, "dir is none", http.StatusBadRequest)
+		return
+	}
+
+	items, err := s.readDirItems(resolved)
+	if err != nil {
+		respondError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.applyComicInfoSeries(resolved, items)
+
+	series, ungrouped := groupSeries(items)
+	respondJSON(w, struct {
+		RootName     string         `json:"rootName"`
+		RelativePath string         `json:"relativePath"`
+		Series       []*seriesGroup `json:"series"`
+		Ungrouped    []fileItem     `json:"ungrouped"`
+	}{resolved.RootName, resolved.RelativePath, series, ungrouped})
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestParseSeriesName(t *testing.T) {
+	tests := []struct {
+		filename string
+		series   string
+		number   float64
+		ok       bool
+	}{
+		{"Title v01.cbz", "Title", 1, true},
+		{"[Author] Some Title Vol. 12 (2019).cbr", "Some Title", 12, true},
+		{"Another_Series_c045.5.zip", "Another_Series", 45.5, true},
+		{"Hero #7.cbz", "Hero", 7, true},
+		{"ワンピース 第１０３巻.cbz", "ワンピース", 103, true},
+		{"Plain Title 03.cbz", "Plain Title", 3, true},
+		{"Summer Special 2019.cbz", "", 0, false},
+		{"Annual Vol 2019.cbz", "Annual", 2019, true},
+		{"Oneshot.cbz", "", 0, false},
+	}
+	for _, tt := range tests {
+		series, number, ok := parseSeriesName(tt.filename)
+		if series != tt.series || number != tt.number || ok != tt.ok {
+			t.Errorf("parseSeriesName(%q) = %q, %v, %v; want %q, %v, %v",
+				tt.filename, series, number, ok, tt.series, tt.number, tt.ok)
+		}
+	}
+}
+
+func TestHandleSeriesGroupsVolumesAndGaps(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"Title v01.cbz", "Title v03.cbz", "title v04.cbz", "Other 2.cbz", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(root, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	server := initServer(&Config{Roots: []RootConfig{{Path: root, Name: "Root"}}})
+
+	request := mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/api/series/Root", nil), map[string]string{"path": "Root"})
+	response := httptest.NewRecorder()
+	server.handleSeries(response, request)
+	if response.Code != http.StatusOK {
+		t.Fatalf("status = %d; body = %s", response.Code, response.Body.String())
+	}
+
+	var result struct {
+		Series []struct {
+			Name    string     `json:"name"`
+			Volumes []fileItem `json:"volumes"`
+			Missing []float64  `json:"missing"`
+		} `json:"series"`
+		Ungrouped []fileItem `json:"ungrouped"`
+	}
+	if err := json.Unmarshal(response.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Series) != 2 || result.Series[1].Name != "Title" {
+		t.Fatalf("series = %+v", result.Series)
+	}
+	if got := len(result.Series[1].Volumes); got != 3 {
+		t.Fatalf("Title volumes = %d, want 3", got)
+	}
+	if !reflect.DeepEqual(result.Series[1].Missing, []float64{2}) {
+		t.Fatalf("Title missing = %v, want [2]", result.Series[1].Missing)
+	}
+	if !reflect.DeepEqual(result.Series[0].Missing, []float64{1}) {
+		t.Fatalf("Other missing = %v, want [1]", result.Series[0].Missing)
+	}
+	if len(result.Ungrouped) != 1 || result.Ungrouped[0].Name != "notes.txt" {
+		t.Fatalf("ungrouped = %+v", result.Ungrouped)
+	}
+}
+
+func TestComicInfoSeries(t *testing.T) {
+	root := t.TempDir()
+	order := []string{"ComicInfo.xml", "01.jpg"}
+	writeTestZip(t, filepath.Join(root, "scan001.cbz"), zip.Store, map[string]string{
+		"ComicInfo.xml": "<ComicInfo><Series>Saga</Series><Number>1</Number></ComicInfo>", "01.jpg": "a",
+	}, order)
+	writeTestZip(t, filepath.Join(root, "scan002.cbz"), zip.Store, map[string]string{
+		"ComicInfo.xml": "<ComicInfo><Series>Saga</Series><Volume>2011</Volume></ComicInfo>", "01.jpg": "b",
+	}, order)
+	server := initServer(&Config{Roots: []RootConfig{{Path: root, Name: "Root"}}})
+
+	if series, number, ok := server.seriesInfoFor(filepath.Join(root, "scan001.cbz")); !ok || series != "Saga" || number != 1 {
+		t.Fatalf("scan001 = %q %v %v", series, number, ok)
+	}
+	// A Volume that is the series' start year is not a volume number
+	if series, number, ok := server.seriesInfoFor(filepath.Join(root, "scan002.cbz")); ok {
+		t.Fatalf("scan002 = %q %v", series, number)
+	}
+
+	// Plain listings keep the filename series and leave archives closed
+	resolved, err := server.resolvePath("Root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, err := server.readDirItems(resolved)
+	if err != nil || len(items) != 2 || items[0].Series != "" {
+		t.Fatalf("listing = %+v, %v", items, err)
+	}
+	server.applyComicInfoSeries(resolved, items)
+	if items[0].Series != "Saga" || items[1].Series != "" {
+		t.Fatalf("with ComicInfo = %+v", items)
+	}
+}
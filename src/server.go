@@ -18,6 +18,7 @@ import (
 
 var (
 	currentServer *http.Server
+	currentState  *Server // the server behind currentServer, flushed on shutdown
 	serverMutex   sync.Mutex
 )
 
@@ -31,13 +32,34 @@ const publicDirName = "public-minified"
 
 // Server represents the HTTP server
 type Server struct {
-	config         *Config
-	router         *mux.Router
-	nameToPath     map[string]string
-	pathToName     map[string]string
-	thumbnailCache *ThumbnailCache
-	imageListCache *ImageListCache
-	transferMutex  sync.Mutex
+	config          *Config
+	router          *mux.Router
+	nameToPath      map[string]string
+	pathToName      map[string]string
+	thumbnailCache  *ThumbnailCache
+	imageListCache  *ImageListCache
+	comicInfoCache  *ComicInfoCache
+	ignoreFileCache *IgnoreFileCache
+	dataDir         string
+	collections     *CollectionStore
+	readingLists    *ReadingListStore
+	duplicates      *DuplicateScanner
+	recent          *RecentCache
+	readCounts      *ReadCountCache
+	bookIDs         *BookIDIndex
+	users           *UserStore
+	shares          *ShareStore
+	progress        *ProgressStore
+	bookmarks       *BookmarkStore
+	history         *HistoryStore
+	preferences     *PreferenceStore
+	koreaderDocs    *KOReaderIndex
+	audit           *AuditLog
+	rateLimiter     *RateLimiter
+	loginGuard      *LoginGuard
+	trustedProxies  []*net.IPNet
+	ipRules         ipRules
+	transferMutex   sync.Mutex
 }
 
 // initServer initializes a new Server with caches
@@ -56,6 +78,8 @@ func initServer(cfg *Config) *Server {
 	cacheDir = filepath.Join(cacheDir, "thumbnail")
 	os.MkdirAll(cacheDir, 0755)
 
+	dataDir := getDataDir()
+
 	srv := &Server{
 		config:     cfg,
 		router:     mux.NewRouter(),
@@ -70,8 +94,38 @@ func initServer(cfg *Config) *Server {
 			cache:   make(map[string]*ImageListEntry),
 			maxSize: 256,
 		},
+		comicInfoCache: &ComicInfoCache{
+			cache:   make(map[string]*ComicInfoEntry),
+			maxSize: 4096,
+		},
+		ignoreFileCache: &IgnoreFileCache{
+			files: make(map[string]*ignoreFileEntry),
+		},
+		dataDir:      dataDir,
+		collections:  loadCollectionStore(filepath.Join(dataDir, "collections.json")),
+		readingLists: loadReadingListStore(filepath.Join(dataDir, "readinglists.json")),
+		duplicates: &DuplicateScanner{
+			pageHashes: make(map[string]*pageHashEntry),
+		},
+		recent:         &RecentCache{},
+		readCounts:     newReadCountCache(),
+		bookIDs:        loadBookIDIndex(filepath.Join(dataDir, "bookids.json")),
+		users:          loadUserStore(filepath.Join(dataDir, "users.json")),
+		shares:         loadShareStore(filepath.Join(dataDir, "shares.json")),
+		progress:       loadProgressStore(filepath.Join(dataDir, "progress.json")),
+		bookmarks:      loadBookmarkStore(filepath.Join(dataDir, "bookmarks.json")),
+		history:        loadHistoryStore(filepath.Join(dataDir, "history.json")),
+		preferences:    loadPreferenceStore(filepath.Join(dataDir, "preferences.json")),
+		koreaderDocs:   loadKOReaderIndex(filepath.Join(dataDir, "koreader.json")),
+		audit:          newAuditLog(filepath.Join(dataDir, auditFileName), cfg.Audit),
+		rateLimiter:    newRateLimiter(cfg.RateLimit),
+		loginGuard:     newLoginGuard(cfg.RateLimit),
+		trustedProxies: mustParseCIDRs("trustedProxies", cfg.TrustedProxies),
+		ipRules:        compileIPAccess(cfg.IPAccess),
 	}
 
+	srv.progress.onChange = srv.readCounts.apply
+
 	// Load existing cache metadata
 	srv.thumbnailCache.loadExisting() // Build name/path maps
 	for i := range cfg.Roots {
@@ -86,36 +140,93 @@ func initServer(cfg *Config) *Server {
 func createHTTPServer(srv *Server) *http.Server {
 	return &http.Server{
 		Addr:         net.JoinHostPort("", strconv.Itoa(srv.config.Port)),
-		Handler:      srv.router,
+		Handler:      srv.handler(),
 		ReadTimeout:  30 * time.Second,
 		WriteTimeout: 30 * time.Second,
 		IdleTimeout:  120 * time.Second,
 	}
 }
 
+// handler returns the router behind the middleware that runs before routing
+func (s *Server) handler() http.Handler {
+	return s.ipFilterMiddleware(s.originMiddleware(s.router))
+}
+
 func (s *Server) setupRoutes() {
+	// Once accounts exist, every route below requires a session
+	s.router.Use(s.authMiddleware)
+	// Limits are counted per account, so they apply after authentication
+	s.router.Use(s.rateLimitMiddleware)
+
 	// API routes (must be defined before static files)
 	api := s.router.PathPrefix("/api").Subrouter()
+	api.HandleFunc("/auth/login", s.handleLogin).Methods("POST")
+	api.HandleFunc("/auth/logout", s.handleLogout).Methods("POST")
+	api.HandleFunc("/auth/me", s.handleMe).Methods("GET")
 	api.HandleFunc("/dir/{path:.*}", s.handleDir).Methods("GET")
 	api.HandleFunc("/dir", s.handleDir).Methods("GET") // For root list (empty path)
 	api.HandleFunc("/book/{path:.*}/list", s.handleBookList).Methods("GET")
 	api.HandleFunc("/book/{path:.*}/image/{index:[0-9]+}", s.handleBookImage).Methods("GET")
 	api.HandleFunc("/book/{path:.*}/thumbnail", s.handleThumbnail).Methods("GET")
+	api.HandleFunc("/book/{path:.*}/siblings", s.handleBookSiblings).Methods("GET")
+	api.HandleFunc("/book/{path:.*}/tags", s.handleBookTags).Methods("GET", "POST")
+	api.HandleFunc("/book/{path:.*}/progress", s.handleBookProgress).Methods("GET", "PUT", "DELETE")
+	api.HandleFunc("/book/{path:.*}/bookmarks", s.handleBookBookmarks).Methods("GET", "POST")
+	api.HandleFunc("/book/{path:.*}/bookmarks/{id}", s.handleBookBookmark).Methods("PUT", "DELETE")
+	api.HandleFunc("/bookmarks", s.handleBookmarks).Methods("GET")
+	api.HandleFunc("/progress", s.handleProgress).Methods("GET")
+	api.HandleFunc("/progress/read", s.handleMarkRead).Methods("POST")
+	api.HandleFunc("/history", s.handleHistory).Methods("GET")
+	api.HandleFunc("/stats", s.handleStats).Methods("GET")
+	api.HandleFunc("/preferences", s.handlePreferences).Methods("GET", "PUT")
+	api.HandleFunc("/userdata", s.handleExportUserData).Methods("GET")
+	api.HandleFunc("/userdata/import", s.handleImportUserData).Methods("POST")
+	api.HandleFunc("/ondeck", s.handleOnDeck).Methods("GET")
+	api.HandleFunc("/kosync/users/create", s.handleKOSyncCreateUser).Methods("POST")
+	api.HandleFunc("/kosync/users/auth", s.handleKOSyncAuth).Methods("GET")
+	api.HandleFunc("/kosync/syncs/progress", s.handleKOSyncPutProgress).Methods("PUT")
+	api.HandleFunc("/kosync/syncs/progress/{document}", s.handleKOSyncGetProgress).Methods("GET")
+	api.HandleFunc("/id/{id}", s.handleBookByID).Methods("GET")
+	api.HandleFunc("/series/{path:.*}", s.handleSeries).Methods("GET")
 	api.HandleFunc("/media-url/{path:.*}", s.handleMediaURL).Methods("GET")
 	api.HandleFunc("/file/{path:.*}", s.handleFile).Methods("GET")
-	api.HandleFunc("/command/rename", s.handleRename).Methods("POST")
-	api.HandleFunc("/command/mkdir", s.handleMkdir).Methods("POST")
-	api.HandleFunc("/command/remove", s.handleRemove).Methods("POST")
-	api.HandleFunc("/command/archive", s.handleArchive).Methods("POST")
-	api.HandleFunc("/command/transfer", s.handleTransfer).Methods("POST")
-	api.HandleFunc("/command/upload", s.handleUpload).Methods("POST")
+	api.HandleFunc("/collections", s.handleCollections).Methods("GET", "POST")
+	api.HandleFunc("/collections/{id}", s.handleCollection).Methods("GET", "PUT", "DELETE")
+	api.HandleFunc("/collections/{id}/books", s.handleCollectionBooks).Methods("POST")
+	api.HandleFunc("/tags", s.handleTags).Methods("GET")
+	api.HandleFunc("/tags/{tag}", s.handleTagBooks).Methods("GET")
+	api.HandleFunc("/readinglists", s.handleReadingLists).Methods("GET", "POST")
+	api.HandleFunc("/readinglists/import", s.handleReadingListImport).Methods("POST")
+	api.HandleFunc("/readinglists/{id}", s.handleReadingList).Methods("GET", "PUT", "DELETE")
+	api.HandleFunc("/readinglists/{id}/entries", s.handleReadingListEntries).Methods("POST")
+	api.HandleFunc("/readinglists/{id}/progress", s.handleReadingListProgress).Methods("POST")
+	api.HandleFunc("/readinglists/{id}/export", s.handleReadingListExport).Methods("GET")
+	api.HandleFunc("/recent", s.handleRecent).Methods("GET")
+	api.HandleFunc("/shares", s.audited("share", s.handleShares)).Methods("GET", "POST")
+	api.HandleFunc("/shares/{id}", s.audited("share", s.handleShare)).Methods("DELETE")
+	api.HandleFunc("/duplicates", s.handleDuplicates).Methods("GET")
+	api.HandleFunc("/duplicates/scan", s.handleDuplicateScan).Methods("POST")
+	api.HandleFunc("/command/rename", s.audited("rename", s.handleRename)).Methods("POST")
+	api.HandleFunc("/command/mkdir", s.audited("mkdir", s.handleMkdir)).Methods("POST")
+	api.HandleFunc("/command/remove", s.audited("remove", s.handleRemove)).Methods("POST")
+	api.HandleFunc("/command/archive", s.audited("archive", s.handleArchive)).Methods("POST")
+	api.HandleFunc("/command/transfer", s.audited("transfer", s.handleTransfer)).Methods("POST")
+	api.HandleFunc("/command/upload", s.audited("upload", s.handleUpload)).Methods("POST")
+	api.HandleFunc("/settings/users", s.audited("user", s.handleUsers)).Methods("GET", "POST")
+	api.HandleFunc("/settings/users/{name}", s.audited("user", s.handleUser)).Methods("PUT", "DELETE")
+	api.HandleFunc("/settings/tokens", s.audited("token", s.handleTokens)).Methods("GET", "POST")
+	api.HandleFunc("/settings/tokens/{id}", s.audited("token", s.handleToken)).Methods("DELETE")
+	api.HandleFunc("/settings/audit", s.requireSettings(s.handleAudit)).Methods("GET")
 
 	// GUI control APIs (disabled when disableGUI is true)
 	if s.config.DisableGUI == nil || !*s.config.DisableGUI {
-		api.HandleFunc("/settings/config", s.handleConfig).Methods("GET", "POST")
-		api.HandleFunc("/settings/restart", s.handleRestart).Methods("POST")
+		api.HandleFunc("/settings/config", s.audited("config", s.requireSettings(s.handleConfig))).Methods("GET", "POST")
+		api.HandleFunc("/settings/restart", s.audited("restart", s.requireSettings(s.handleRestart))).Methods("POST")
 	}
 
+	// Share links set a cookie and redirect to the viewer pages
+	s.router.HandleFunc("/share/{token}", s.handleShareLink).Methods("GET")
+
 	// Block settings.html if GUI is disabled
 	if s.config.DisableGUI != nil && *s.config.DisableGUI {
 		s.router.HandleFunc("/settings.html", func(w http.ResponseWriter, r *http.Request) {
@@ -164,6 +275,7 @@ func startServer(cfg *Config) *http.Server {
 	srv := initServer(cfg)
 	srv.setupRoutes()
 	httpServer := createHTTPServer(srv)
+	currentState = srv
 
 	go func() {
 		var err error
@@ -192,6 +304,17 @@ func shutdownServer(server *http.Server) {
 	if err := server.Close(); err != nil {
 		log.Printf("Server close error: %v", err)
 	}
+	if currentState != nil {
+		currentState.flush()
+	}
+}
+
+// flush writes the state that is saved lazily
+func (s *Server) flush() {
+	s.bookIDs.flush()
+	s.koreaderDocs.flush()
+	s.progress.flush()
+	s.history.flush()
 }
 
 // restartServer restarts the HTTP server with reloaded configuration
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	shareCookieName     = "litecomics_share"
+	defaultShareTTL     = 7 * 24 * time.Hour
+	shareContextKey     = contextKey("share")
+	shareQueryParameter = "share"
+	// mediaURLTTL is how long a signed file URL handed to an external player stays valid
+	mediaURLTTL         = 12 * time.Hour
+	mediaQueryParameter = "media"
+	mediaFileRoute      = "/api/file/{path:.*}"
+)
+
+// shareRoutes are the read-only endpoints a share link opens, by route template
+var shareRoutes = map[string]bool{
+	"/api/dir/{path:.*}":                       true,
+	"/api/book/{path:.*}/list":                 true,
+	"/api/book/{path:.*}/image/{index:[0-9]+}": true,
+	"/api/book/{path:.*}/thumbnail":            true,
+	mediaFileRoute:                             true,
+	"/api/media-url/{path:.*}":                 true,
+}
+
+// Share grants read-only access to one book or folder until it expires or is revoked
+type Share struct {
+	ID        string    `json:"id"`
+	Path      string    `json:"path"` // Canonical "RootName/..." path of the shared book or folder
+	Directory bool      `json:"directory"`
+	User      string    `json:"user,omitempty"`
+	Created   time.Time `json:"created"`
+	Expires   time.Time `json:"expires"`
+}
+
+// ShareStore persists share links and the secret that signs them in the data directory
+type ShareStore struct {
+	mu     sync.Mutex
+	path   string
+	Secret string            `json:"secret"`
+	Shares map[string]*Share `json:"shares"`
+}
+
+func loadShareStore(path string) *ShareStore {
+	store := &ShareStore{path: path, Shares: make(map[string]*Share)}
+	if err := readJSONFile(path, store); err != nil {
+		logStoreLoadError("shares", err)
+	}
+	if store.Shares == nil {
+		store.Shares = make(map[string]*Share)
+	}
+	if store.Secret == "" {
+		secret := make([]byte, 32)
+		rand.Read(secret)
+		store.Secret = hex.EncodeToString(secret)
+	}
+	return store
+}
+
+// save writes the store, dropping expired shares; callers must hold mu
+func (s *ShareStore) save() error {
+	now := time.Now()
+	for id, share := range s.Shares {
+		if now.After(share.Expires) {
+			delete(s.Shares, id)
+		}
+	}
+	return writeJSONFile(s.path, s)
+}
+
+// sign returns an HMAC of a value under the server secret
+func (s *ShareStore) sign(value string) string {
+	mac := hmac.New(sha256.New, []byte(s.Secret))
+	mac.Write([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// token returns the link token of a share: its ID and an HMAC of the ID under the server secret
+func (s *ShareStore) token(id string) string {
+	return id + "." + s.sign(id)
+}
+
+// mediaToken signs a user's access to one file until expires: the user name, the
+// expiry and an HMAC of both with the request path
+func (s *ShareStore) mediaToken(user, requestPath string, expires time.Time) string {
+	expiry := strconv.FormatInt(expires.Unix(), 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(user)) + "." + expiry + "." +
+		s.sign(user+"\x00"+filepath.Clean(requestPath)+"\x00"+expiry)
+}
+
+// mediaUser returns the user a media token was issued to when it is valid for the path
+func (s *ShareStore) mediaUser(token, requestPath string, now time.Time) (string, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", false
+	}
+	user, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", false
+	}
+	expiry, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || now.Unix() > expiry {
+		return "", false
+	}
+	if !hmac.Equal([]byte(token), []byte(s.mediaToken(string(user), requestPath, time.Unix(expiry, 0)))) {
+		return "", false
+	}
+	return string(user), true
+}
+
+// lookup returns the active share of a link token
+func (s *ShareStore) lookup(token string) (Share, bool) {
+	id, _, ok := strings.Cut(token, ".")
+	if !ok {
+		return Share{}, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !hmac.Equal([]byte(token), []byte(s.token(id))) {
+		return Share{}, false
+	}
+	share, ok := s.Shares[id]
+	if !ok || time.Now().After(share.Expires) {
+		return Share{}, false
+	}
+	return *share, true
+}
+
+// movePath keeps shares pointing at books and folders after a rename or move
+func (s *ShareStore) movePath(oldPath, newPath string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	changed := false
+	for _, share := range s.Shares {
+		if moved, ok := movedPath(share.Path, oldPath, newPath); ok {
+			share.Path, changed = moved, true
+		}
+	}
+	if changed {
+		if err := s.save(); err != nil {
+			logStoreError("shares", err)
+		}
+	}
+}
+
+// removeUser revokes the shares created by a deleted account
+func (s *ShareStore) removeUser(user string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	changed := false
+	for id, share := range s.Shares {
+		if share.User == user {
+			delete(s.Shares, id)
+			changed = true
+		}
+	}
+	if changed {
+		if err := s.save(); err != nil {
+			logStoreError("shares", err)
+		}
+	}
+}
+
+// access limits a share request to the shared subtree
+func (share *Share) access() *Access {
+	rootName, _, _ := strings.Cut(filepath.ToSlash(share.Path), "/")
+	return &Access{roots: map[string]bool{rootName: true}, scope: filepath.ToSlash(share.Path)}
+}
+
+// requestShare returns the share link a request was authorized by, or nil
+func requestShare(r *http.Request) *Share {
+	share, _ := r.Context().Value(shareContextKey).(*Share)
+	return share
+}
+
+// shareToken returns the share token of a request from the query or the share cookie
+func shareToken(r *http.Request) string {
+	if token := r.URL.Query().Get(shareQueryParameter); token != "" {
+		return token
+	}
+	if cookie, err := r.Cookie(shareCookieName); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+// authorizeShare serves read-only requests covered by a share link and reports
+// whether it did: the viewer pages and the API routes in shareRoutes. Paths
+// outside the shared subtree are rejected by the handlers.
+func (s *Server) authorizeShare(w http.ResponseWriter, r *http.Request, next http.Handler) bool {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		return false
+	}
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		route := mux.CurrentRoute(r)
+		if route == nil {
+			return false
+		}
+		if template, err := route.GetPathTemplate(); err != nil || !shareRoutes[template] {
+			return false
+		}
+	}
+	token := shareToken(r)
+	if token == "" {
+		return false
+	}
+	share, ok := s.shares.lookup(token)
+	if !ok {
+		return false
+	}
+	next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), shareContextKey, &share)))
+	return true
+}
+
+// authorizeMediaURL serves a file request signed by handleMediaURL as the user it
+// was issued to, since external players cannot send the session cookie
+func (s *Server) authorizeMediaURL(w http.ResponseWriter, r *http.Request, next http.Handler) bool {
+	token := r.URL.Query().Get(mediaQueryParameter)
+	if token == "" || (r.Method != "GET" && r.Method != "HEAD") {
+		return false
+	}
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return false
+	}
+	if template, err := route.GetPathTemplate(); err != nil || template != mediaFileRoute {
+		return false
+	}
+	requestPath, _ := url.PathUnescape(mux.Vars(r)["path"])
+	name, ok := s.shares.mediaUser(token, requestPath, time.Now())
+	if !ok {
+		return false
+	}
+	user, ok := s.users.get(name)
+	if !ok {
+		return false
+	}
+	next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userContextKey, &user)))
+	return true
+}
+
+// shareInfo is a share with its link
+type shareInfo struct {
+	Share
+	URL string `json:"url"`
+}
+
+func (s *Server) shareInfo(r *http.Request, share *Share) shareInfo {
+	link := url.URL{Scheme: s.requestScheme(r), Host: s.requestHost(r), Path: "/share/" + s.shares.token(share.ID)}
+	return shareInfo{*share, link.String()}
+}
+
+// handleShares lists (GET) or creates (POST) share links. Users see their own
+// shares; administrators see everyone's.
+func (s *Server) handleShares(w http.ResponseWriter, r *http.Request) {
+	access := s.requestAccess(r)
+	if !access.Share {
+		respondError(w, "You do not have permission to share", http.StatusForbidden)
+		return
+	}
+	user := requestUser(r)
+	store := s.shares
+
+	if r.Method == "GET" {
+		now := time.Now()
+		store.mu.Lock()
+		shares := make([]shareInfo, 0)
+		for _, share := range store.Shares {
+			if now.After(share.Expires) || (user != nil && !user.Admin && share.User != user.Name) {
+				continue
+			}
+			shares = append(shares, s.shareInfo(r, share))
+		}
+		store.mu.Unlock()
+		sort.Slice(shares, func(i, j int) bool { return shares[i].Created.After(shares[j].Created) })
+		respondJSON(w, struct {
+			Shares []shareInfo `json:"shares"`
+		}{shares})
+		return
+	}
+
+	if !s.authEnabled() {
+		// Without accounts every visitor already has full access, so a link could not be limited to one book or folder
+		respondError(w, "Share links require user accounts; create a user first", http.StatusConflict)
+		return
+	}
+	var req struct {
+		Path    string `json:"path"`
+		Expires string `json:"expires"` // RFC 3339 time, or a duration such as "72h" (default 7 days)
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	resolved, err := s.resolveRequestPath(r, req.Path)
+	if err != nil {
+		respondError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	info, err := os.Stat(resolved.FullPath)
+	if err != nil {
+		respondError(w, "Path not found", http.StatusNotFound)
+		return
+	}
+	now := time.Now()
+	expires := now.Add(defaultShareTTL)
+	if req.Expires != "" {
+		if expires, err = parseExpires(req.Expires, now); err != nil {
+			respondError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	share := &Share{
+		ID:        newID(),
+		Path:      resolved.RequestPath(),
+		Directory: info.IsDir(),
+		User:      requestUserName(r),
+		Created:   now,
+		Expires:   expires,
+	}
+	store.mu.Lock()
+	store.Shares[share.ID] = share
+	err = store.save()
+	result := s.shareInfo(r, share)
+	store.mu.Unlock()
+	if err != nil {
+		respondError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, result)
+}
+
+// handleShare revokes (DELETE) a share link by ID
+func (s *Server) handleShare(w http.ResponseWriter, r *http.Request) {
+	if !s.requestAccess(r).Share {
+		respondError(w, "You do not have permission to share", http.StatusForbidden)
+		return
+	}
+	user := requestUser(r)
+	id := mux.Vars(r)["id"]
+
+	store := s.shares
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	share, ok := store.Shares[id]
+	if !ok || (user != nil && !user.Admin && share.User != user.Name) {
+		respondError(w, "Share not found", http.StatusNotFound)
+		return
+	}
+	delete(store.Shares, id)
+	if err := store.save(); err != nil {
+		respondError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, map[string]string{"status": "ok"})
+}
+
+// handleShareLink opens a share link: it stores the token in a cookie so the
+// viewer pages can load the shared book or folder, then redirects to them.
+func (s *Server) handleShareLink(w http.ResponseWriter, r *http.Request) {
+	token := mux.Vars(r)["token"]
+	share, ok := s.shares.lookup(token)
+	if !ok {
+		http.Error(w, "This link has expired or been revoked", http.StatusNotFound)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     shareCookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  share.Expires,
+		HttpOnly: true,
+		Secure:   s.requestScheme(r) == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	fragment := url.PathEscape(filepath.ToSlash(share.Path))
+	target := "/api/file/" + fragment
+	switch {
+	case share.Directory:
+		target = "/#" + fragment
+	case isArchiveFile(share.Path):
+		target = "/viewer/#" + fragment
+	case isVideoFile(share.Path) || isAudioFile(share.Path):
+		target = "/media/#" + fragment
+	}
+	http.Redirect(w, r, target, http.StatusFound)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShareLinkScopesToSubtree(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "Series", "Shared"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestZip(t, filepath.Join(root, "Series", "Shared", "vol1.cbz"), zip.Store, map[string]string{"01.jpg": "page"}, []string{"01.jpg"})
+	writeTestZip(t, filepath.Join(root, "Series", "private.cbz"), zip.Store, map[string]string{"01.jpg": "page"}, []string{"01.jpg"})
+	server := newAuthTestServerWithConfig(t, &Config{Roots: []RootConfig{{Path: root, Name: "Root"}}})
+	admin := sessionCookie(t, authRequest(t, server, "POST", "/api/settings/users", map[string]interface{}{"name": "admin", "password": "adminpass"}))
+
+	created := authRequest(t, server, "POST", "/api/shares", map[string]string{"path": "Root/Series/Shared", "expires": "1h"}, admin)
+	var share shareInfo
+	if err := json.Unmarshal(created.Body.Bytes(), &share); err != nil || created.Code != http.StatusOK {
+		t.Fatalf("create share = %d; body = %s", created.Code, created.Body.String())
+	}
+	if !share.Directory || share.User != "admin" {
+		t.Fatalf("share = %+v", share)
+	}
+
+	// Opening the link sets the share cookie and redirects to the folder
+	opened := authRequest(t, server, "GET", strings.TrimPrefix(share.URL, "http://example.com"), nil)
+	if opened.Code != http.StatusFound || opened.Header().Get("Location") != "/#Root%2FSeries%2FShared" {
+		t.Fatalf("open link = %d, %q", opened.Code, opened.Header().Get("Location"))
+	}
+	var cookie *http.Cookie
+	for _, c := range opened.Result().Cookies() {
+		if c.Name == shareCookieName {
+			cookie = c
+		}
+	}
+	if cookie == nil {
+		t.Fatal("no share cookie")
+	}
+
+	tests := []struct {
+		method, target string
+		want           int
+	}{
+		{"GET", "/api/dir/Root/Series/Shared", http.StatusOK},
+		{"GET", "/api/book/Root/Series/Shared/vol1.cbz/list", http.StatusOK},
+		{"GET", "/api/book/Root/Series/Shared/vol1.cbz/image/0", http.StatusOK},
+		{"GET", "/viewer/", http.StatusOK},
+		{"GET", "/api/book/Root/Series/private.cbz/list", http.StatusNotFound},
+		{"GET", "/api/dir/Root/Series", http.StatusNotFound},
+		{"GET", "/api/dir", http.StatusUnauthorized},
+		{"GET", "/api/recent", http.StatusUnauthorized},
+		{"POST", "/api/command/mkdir", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		if response := authRequest(t, server, tt.method, tt.target, nil, cookie); response.Code != tt.want {
+			t.Errorf("%s %s = %d, want %d; body = %s", tt.method, tt.target, response.Code, tt.want, response.Body.String())
+		}
+	}
+
+	// The signature must match the server secret
+	forged := &http.Cookie{Name: shareCookieName, Value: share.ID + ".forged"}
+	if response := authRequest(t, server, "GET", "/api/dir/Root/Series/Shared", nil, forged); response.Code != http.StatusUnauthorized {
+		t.Fatalf("forged token = %d, want 401", response.Code)
+	}
+
+	listed := authRequest(t, server, "GET", "/api/shares", nil, admin)
+	if !strings.Contains(listed.Body.String(), share.ID) {
+		t.Fatalf("list = %s", listed.Body.String())
+	}
+	if response := authRequest(t, server, "DELETE", "/api/shares/"+share.ID, nil, admin); response.Code != http.StatusOK {
+		t.Fatalf("revoke = %d", response.Code)
+	}
+	if response := authRequest(t, server, "GET", "/api/dir/Root/Series/Shared", nil, cookie); response.Code != http.StatusUnauthorized {
+		t.Fatalf("revoked share = %d, want 401", response.Code)
+	}
+}
+
+func TestShareRequiresPermission(t *testing.T) {
+	server := newAuthTestServer(t)
+	admin := sessionCookie(t, authRequest(t, server, "POST", "/api/settings/users", map[string]interface{}{"name": "admin", "password": "adminpass"}))
+	authRequest(t, server, "POST", "/api/settings/users", map[string]interface{}{"name": "reader", "password": "readerpass"}, admin)
+	reader := sessionCookie(t, authRequest(t, server, "POST", "/api/auth/login", map[string]string{"name": "reader", "password": "readerpass"}))
+
+	response := authRequest(t, server, "POST", "/api/shares", map[string]string{"path": "Root"}, reader)
+	if response.Code != http.StatusForbidden {
+		t.Fatalf("reader share = %d, want 403", response.Code)
+	}
+}
+
+func TestShareRequiresAccounts(t *testing.T) {
+	server := newAuthTestServer(t)
+	if response := authRequest(t, server, "POST", "/api/shares", map[string]string{"path": "Root"}); response.Code != http.StatusConflict {
+		t.Fatalf("share without accounts = %d, want 409", response.Code)
+	}
+}
+
+func TestDeletedUserSharesRevoked(t *testing.T) {
+	server := newAuthTestServerWithConfig(t, &Config{
+		Roots:  []RootConfig{{Path: t.TempDir(), Name: "Root"}},
+		Groups: map[string]AccessRule{"readers": {Roots: []string{"*"}}},
+	})
+	admin := sessionCookie(t, authRequest(t, server, "POST", "/api/settings/users", map[string]interface{}{"name": "admin", "password": "adminpass"}))
+	authRequest(t, server, "POST", "/api/settings/users", map[string]interface{}{"name": "sharer", "password": "sharerpass", "groups": []string{"readers"}, "access": map[string]bool{"share": true}}, admin)
+	sharer := sessionCookie(t, authRequest(t, server, "POST", "/api/auth/login", map[string]string{"name": "sharer", "password": "sharerpass"}))
+
+	// A share-only rule is kept rather than cleared as empty
+	authRequest(t, server, "PUT", "/api/settings/users/sharer", map[string]interface{}{"access": map[string]bool{"share": true}}, admin)
+	created := authRequest(t, server, "POST", "/api/shares", map[string]string{"path": "Root"}, sharer)
+	var share shareInfo
+	if err := json.Unmarshal(created.Body.Bytes(), &share); err != nil || created.Code != http.StatusOK {
+		t.Fatalf("create share = %d; body = %s", created.Code, created.Body.String())
+	}
+
+	authRequest(t, server, "DELETE", "/api/settings/users/sharer", nil, admin)
+	if _, ok := server.shares.lookup(strings.TrimPrefix(share.URL, "http://example.com/share/")); ok {
+		t.Fatal("share of deleted user still valid")
+	}
+}
+
+func TestMediaURLIsSignedForExternalPlayers(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"movie.mp4", "other.mp4"} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	server := newAuthTestServerWithConfig(t, &Config{Roots: []RootConfig{{Path: root, Name: "Root"}}})
+	admin := sessionCookie(t, authRequest(t, server, "POST", "/api/settings/users", map[string]interface{}{"name": "admin", "password": "adminpass"}))
+
+	var media struct {
+		URL string `json:"url"`
+	}
+	response := authRequest(t, server, "GET", "/api/media-url/Root/movie.mp4", nil, admin)
+	if err := json.Unmarshal(response.Body.Bytes(), &media); err != nil || !strings.Contains(media.URL, "?media=") {
+		t.Fatalf("media url = %s", response.Body.String())
+	}
+	// The player fetches the file without a session
+	target := media.URL[strings.Index(media.URL, "/api/"):]
+	if response := authRequest(t, server, "GET", target, nil); response.Code != http.StatusOK || response.Body.String() != "movie.mp4" {
+		t.Fatalf("signed file = %d", response.Code)
+	}
+	// The signature covers only that file, and only until it expires
+	query := target[strings.Index(target, "?"):]
+	if response := authRequest(t, server, "GET", "/api/file/Root/other.mp4"+query, nil); response.Code != http.StatusUnauthorized {
+		t.Fatalf("other file = %d, want 401", response.Code)
+	}
+	if response := authRequest(t, server, "GET", "/api/dir/Root"+query, nil); response.Code != http.StatusUnauthorized {
+		t.Fatalf("listing = %d, want 401", response.Code)
+	}
+	expired := server.shares.mediaToken("admin", "Root/movie.mp4", time.Now().Add(-time.Minute))
+	if _, ok := server.shares.mediaUser(expired, "Root/movie.mp4", time.Now()); ok {
+		t.Fatal("expired media token accepted")
+	}
+}
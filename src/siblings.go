@@ -0,0 +1,145 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// parentPath returns the resolved directory containing a non-root item
+func parentPath(resolved *ResolvedPath) *ResolvedPath {
+	parentRelative := filepath.Dir(resolved.RelativePath)
+	if parentRelative == "." {
+		parentRelative = ""
+	}
+	return &ResolvedPath{
+		RootName: resolved.RootName, RelativePath: parentRelative,
+		RootPath: resolved.RootPath, FullPath: filepath.Join(resolved.RootPath, parentRelative),
+	}
+}
+
+// childPath returns the resolved path of a direct child of a directory
+func childPath(resolved *ResolvedPath, name string) *ResolvedPath {
+	return &ResolvedPath{
+		RootName: resolved.RootName, RelativePath: filepath.Join(resolved.RelativePath, name),
+		RootPath: resolved.RootPath, FullPath: filepath.Join(resolved.FullPath, name),
+	}
+}
+
+// bookItems keeps only the books of a listing, preserving its order
+func bookItems(items []fileItem) []fileItem {
+	books := make([]fileItem, 0, len(items))
+	for _, item := range items {
+		if item.Type == "book" {
+			books = append(books, item)
+		}
+	}
+	return books
+}
+
+// findBookSiblings returns the books before and after a book in listing order.
+// With cross set, a missing neighbour is looked up in the adjacent sibling folders.
+func (s *Server) findBookSiblings(book *ResolvedPath, cross bool) (*fileItem, *fileItem, error) {
+	parent := parentPath(book)
+	items, err := s.readDirItems(parent)
+	if err != nil {
+		return nil, nil, err
+	}
+	books := bookItems(items)
+
+	name := filepath.Base(book.FullPath)
+	var previous, next *fileItem
+	for i := range books {
+		if books[i].Name != name {
+			continue
+		}
+		if i > 0 {
+			previous = &books[i-1]
+		}
+		if i+1 < len(books) {
+			next = &books[i+1]
+		}
+		break
+	}
+
+	if !cross || parent.RelativePath == "" || (previous != nil && next != nil) {
+		return previous, next, nil
+	}
+
+	grandparent := parentPath(parent)
+	folders, err := s.readDirItems(grandparent)
+	if err != nil {
+		return previous, next, nil
+	}
+	folderName := filepath.Base(parent.FullPath)
+	position := -1
+	for i := range folders {
+		if folders[i].Type == "directory" && folders[i].Name == folderName {
+			position = i
+			break
+		}
+	}
+	if position < 0 {
+		return previous, next, nil
+	}
+
+	for i := position - 1; previous == nil && i >= 0; i-- {
+		if siblingBooks := s.folderBooks(grandparent, folders[i]); len(siblingBooks) > 0 {
+			previous = &siblingBooks[len(siblingBooks)-1]
+		}
+	}
+	for i := position + 1; next == nil && i < len(folders); i++ {
+		if siblingBooks := s.folderBooks(grandparent, folders[i]); len(siblingBooks) > 0 {
+			next = &siblingBooks[0]
+		}
+	}
+	return previous, next, nil
+}
+
+// folderBooks lists the books directly inside a directory item, or nil for other items
+func (s *Server) folderBooks(parent *ResolvedPath, item fileItem) []fileItem {
+	if item.Type != "directory" {
+		return nil
+	}
+	items, err := s.readDirItems(childPath(parent, item.Name))
+	if err != nil {
+		return nil
+	}
+	return bookItems(items)
+}
+
+// handleBookSiblings returns the previous and next book of a book
+func (s *Server) handleBookSiblings(w http.ResponseWriter, r *http.Request) {
+	requestPath, _ := url.PathUnescape(mux.Vars(r)["path"])
+	resolved, err := s.resolveRequestPath(r, requestPath)
+	if err != nil {
+		respondError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if resolved.RelativePath == "" {
+		respondError(w, "A root directory is not a book", http.StatusBadRequest)
+		return
+	}
+
+	info, err := os.Stat(resolved.FullPath)
+	if err != nil || info.IsDir() || !isArchiveFile(resolved.FullPath) {
+		respondError(w, "book not found", http.StatusNotFound)
+		return
+	}
+
+	cross, _ := strconv.ParseBool(r.URL.Query().Get("cross"))
+	previous, next, err := s.findBookSiblings(resolved, cross)
+	if err != nil {
+		respondError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, struct {
+		Previous *fileItem `json:"previous"`
+		Next     *fileItem `json:"next"`
+	}{previous, next})
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFindBookSiblingsCrossesFolders(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"A/vol2.cbz", "A/vol10.cbz", "A/notes.txt", "B/vol11.cbz", "C/vol1.cbz"} {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	server := initServer(&Config{Roots: []RootConfig{{Path: root, Name: "Root"}}})
+
+	book, err := server.resolvePath("Root/B/vol11.cbz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous, next, err := server.findBookSiblings(book, false)
+	if err != nil || previous != nil || next != nil {
+		t.Fatalf("same-folder siblings = %v, %v, %v; want none", previous, next, err)
+	}
+
+	previous, next, err = server.findBookSiblings(book, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if previous == nil || previous.Path != filepath.Join("Root", "A", "vol10.cbz") {
+		t.Fatalf("previous = %+v, want A/vol10.cbz", previous)
+	}
+	if next == nil || next.Path != filepath.Join("Root", "C", "vol1.cbz") {
+		t.Fatalf("next = %+v, want C/vol1.cbz", next)
+	}
+}
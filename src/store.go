@@ -0,0 +1,136 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// getDataDir returns the directory for persistent server state (collections, users, progress...)
+func getDataDir() string {
+	// 環境変数で指定されていればそれを使う（Docker用）
+	if envDir := os.Getenv("DATA_DIR"); envDir != "" {
+		return envDir
+	}
+	// 設定ファイルと同じ場所の data ディレクトリ
+	if configPath != "" {
+		return filepath.Join(filepath.Dir(configPath), "data")
+	}
+	if configDir, err := os.UserConfigDir(); err == nil {
+		return filepath.Join(configDir, "LiteComics", "data")
+	}
+	return "data"
+}
+
+// readJSONFile decodes a JSON file into v. A missing file leaves v untouched.
+// A file that cannot be decoded is renamed to path + ".corrupt", so that the
+// store starting empty does not overwrite it on the next save.
+func readJSONFile(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		corrupt := path + ".corrupt"
+		if renameErr := os.Rename(path, corrupt); renameErr != nil {
+			return fmt.Errorf("%v (could not move it aside: %v)", err, renameErr)
+		}
+		return fmt.Errorf("%v; moved to %s", err, corrupt)
+	}
+	return nil
+}
+
+// writeJSONFile atomically replaces path with the JSON encoding of v
+func writeJSONFile(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	temp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-")
+	if err != nil {
+		return err
+	}
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		os.Remove(temp.Name())
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		os.Remove(temp.Name())
+		return err
+	}
+	if err := os.Rename(temp.Name(), path); err != nil {
+		os.Remove(temp.Name())
+		return err
+	}
+	return nil
+}
+
+// logStoreError reports a persistence failure without interrupting the request
+func logStoreError(name string, err error) {
+	log.Printf("Warning: failed to persist %s: %v", name, err)
+}
+
+// logStoreLoadError reports a store that could not be read at startup
+func logStoreLoadError(name string, err error) {
+	log.Printf("Warning: failed to load %s, starting empty: %v", name, err)
+}
+
+// newID returns a random identifier for stored records
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// movedPath rewrites p when it is oldPath or lies below it. The second result reports a change.
+func movedPath(p, oldPath, newPath string) (string, bool) {
+	if p == oldPath {
+		return newPath, true
+	}
+	if strings.HasPrefix(p, oldPath+string(filepath.Separator)) {
+		return newPath + p[len(oldPath):], true
+	}
+	return p, false
+}
+
+// onPathMoved updates every stored reference after an item is renamed or moved
+func (s *Server) onPathMoved(oldPath, newPath string) {
+	s.collections.movePath(oldPath, newPath)
+	s.readingLists.movePath(oldPath, newPath)
+	s.bookIDs.movePath(oldPath, newPath)
+	s.shares.movePath(oldPath, newPath)
+	s.progress.movePath(oldPath, newPath)
+	s.bookmarks.movePath(oldPath, newPath)
+	s.history.movePath(oldPath, newPath)
+	s.koreaderDocs.movePath(oldPath, newPath)
+	s.onLibraryChanged()
+}
+
+// onUserDeleted drops the per-user reading state and the share links of a deleted account
+func (s *Server) onUserDeleted(name string) {
+	s.shares.removeUser(name)
+	s.progress.removeUser(name)
+	s.readCounts.removeUser(name)
+	s.bookmarks.removeUser(name)
+	s.history.removeUser(name)
+	s.preferences.removeUser(name)
+}
+
+// onLibraryChanged drops library-wide scan results after files are added, moved or removed
+func (s *Server) onLibraryChanged() {
+	s.recent.invalidate()
+	s.readCounts.invalidate()
+	s.koreaderDocs.invalidate()
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	tokenScopeRead = "read" // GET and HEAD requests only
+	tokenScopeFull = "full" // everything the owner may do
+	// tokenPrefix marks LiteComics API tokens so they are recognizable in scripts and logs
+	tokenPrefix = "lc_"
+	// tokenUseInterval limits how often lastUsed is written back to disk
+	tokenUseInterval = time.Minute
+)
+
+// APIToken is a personal bearer token for scripts and reader apps. Only a hash of the secret is stored.
+type APIToken struct {
+	ID       string     `json:"id"`
+	User     string     `json:"user"`
+	Name     string     `json:"name"`
+	Scope    string     `json:"scope"`
+	Created  time.Time  `json:"created"`
+	Expires  *time.Time `json:"expires,omitempty"`
+	LastUsed *time.Time `json:"lastUsed,omitempty"`
+}
+
+// tokenUser returns the account and scope of a valid bearer token
+func (u *UserStore) tokenUser(secret string) (User, string, bool) {
+	hash := hashToken(secret)
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	token, ok := u.Tokens[hash]
+	if !ok {
+		return User{}, "", false
+	}
+	now := time.Now()
+	user, exists := u.Users[token.User]
+	if !exists || (token.Expires != nil && now.After(*token.Expires)) {
+		return User{}, "", false
+	}
+	if token.LastUsed == nil || now.Sub(*token.LastUsed) > tokenUseInterval {
+		token.LastUsed = &now
+		if err := u.save(); err != nil {
+			logStoreError("users", err)
+		}
+	}
+	return *user, token.Scope, true
+}
+
+// parseExpires parses an RFC 3339 time or a duration such as "720h" relative to now
+func parseExpires(value string, now time.Time) (time.Time, error) {
+	expires, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		ttl, durationErr := time.ParseDuration(value)
+		if durationErr != nil || ttl <= 0 {
+			return time.Time{}, errors.New("Expires must be an RFC 3339 time or a positive duration")
+		}
+		expires = now.Add(ttl)
+	}
+	if !expires.After(now) {
+		return time.Time{}, errors.New("Expires must be in the future")
+	}
+	return expires, nil
+}
+
+// revokeTokens deletes every token of name; callers must hold mu
+func (u *UserStore) revokeTokens(name string) {
+	for hash, token := range u.Tokens {
+		if token.User == name {
+			delete(u.Tokens, hash)
+		}
+	}
+}
+
+// bearerToken returns the token of an "Authorization: Bearer" header
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// authenticateBearer serves requests carrying a bearer token and reports whether
+// the request had one; invalid tokens are rejected rather than falling back to cookies.
+func (s *Server) authenticateBearer(w http.ResponseWriter, r *http.Request, next http.Handler) bool {
+	secret, ok := bearerToken(r)
+	if !ok {
+		return false
+	}
+	user, scope, ok := s.users.tokenUser(secret)
+	if !ok {
+		respondError(w, "Invalid or expired token", http.StatusUnauthorized)
+		return true
+	}
+	if scope == tokenScopeRead && r.Method != "GET" && r.Method != "HEAD" {
+		respondError(w, "This token is read-only", http.StatusForbidden)
+		return true
+	}
+	next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userContextKey, &user)))
+	return true
+}
+
+// handleTokens lists (GET) or creates (POST) API tokens. Users see their own
+// tokens; administrators see everyone's. The secret is only returned on creation.
+func (s *Server) handleTokens(w http.ResponseWriter, r *http.Request) {
+	user := requestUser(r)
+	if user == nil {
+		respondError(w, "API tokens require a user account", http.StatusBadRequest)
+		return
+	}
+	store := s.users
+
+	if r.Method == "GET" {
+		store.mu.Lock()
+		tokens := make([]APIToken, 0)
+		for _, token := range store.Tokens {
+			if token.User == user.Name || user.Admin {
+				tokens = append(tokens, *token)
+			}
+		}
+		store.mu.Unlock()
+		sort.Slice(tokens, func(i, j int) bool { return tokens[i].Created.After(tokens[j].Created) })
+		respondJSON(w, struct {
+			Tokens []APIToken `json:"tokens"`
+		}{tokens})
+		return
+	}
+
+	var req struct {
+		Name    string `json:"name"`
+		Scope   string `json:"scope"`
+		Expires string `json:"expires"` // RFC 3339 time, or a duration such as "720h"
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		respondError(w, "Name is required", http.StatusBadRequest)
+		return
+	}
+	if req.Scope == "" {
+		req.Scope = tokenScopeRead
+	}
+	if req.Scope != tokenScopeRead && req.Scope != tokenScopeFull {
+		respondError(w, "Scope must be read or full", http.StatusBadRequest)
+		return
+	}
+	now := time.Now()
+	token := &APIToken{ID: newID(), User: user.Name, Name: req.Name, Scope: req.Scope, Created: now}
+	if req.Expires != "" {
+		expires, err := parseExpires(req.Expires, now)
+		if err != nil {
+			respondError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		token.Expires = &expires
+	}
+
+	secret := tokenPrefix + newToken()
+	store.mu.Lock()
+	if _, ok := store.Users[user.Name]; !ok {
+		// Users known only from a proxy header have no account to attach tokens to
+		store.mu.Unlock()
+		respondError(w, "API tokens require a user account", http.StatusBadRequest)
+		return
+	}
+	store.Tokens[hashToken(secret)] = token
+	err := store.save()
+	result := *token
+	store.mu.Unlock()
+	if err != nil {
+		respondError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, struct {
+		APIToken
+		Token string `json:"token"`
+	}{result, secret})
+}
+
+// handleToken revokes (DELETE) an API token by ID
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	user := requestUser(r)
+	if user == nil {
+		respondError(w, "API tokens require a user account", http.StatusBadRequest)
+		return
+	}
+	id := mux.Vars(r)["id"]
+
+	store := s.users
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	for hash, token := range store.Tokens {
+		if token.ID != id || (token.User != user.Name && !user.Admin) {
+			continue
+		}
+		delete(store.Tokens, hash)
+		if err := store.save(); err != nil {
+			respondError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		respondJSON(w, map[string]string{"status": "ok"})
+		return
+	}
+	respondError(w, "Token not found", http.StatusNotFound)
+}
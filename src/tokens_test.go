@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func bearerRequest(server *Server, method, target, token string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, target, nil)
+	request.Header.Set("Authorization", "Bearer "+token)
+	response := httptest.NewRecorder()
+	server.router.ServeHTTP(response, request)
+	return response
+}
+
+func TestAPITokensScopeAndRevoke(t *testing.T) {
+	server := newAuthTestServer(t)
+	admin := sessionCookie(t, authRequest(t, server, "POST", "/api/settings/users", map[string]interface{}{"name": "admin", "password": "adminpass"}))
+
+	createToken := func(body map[string]string) (string, string) {
+		t.Helper()
+		response := authRequest(t, server, "POST", "/api/settings/tokens", body, admin)
+		if response.Code != http.StatusOK {
+			t.Fatalf("create token = %d; body = %s", response.Code, response.Body.String())
+		}
+		var created struct {
+			ID    string `json:"id"`
+			Token string `json:"token"`
+		}
+		if err := json.Unmarshal(response.Body.Bytes(), &created); err != nil {
+			t.Fatal(err)
+		}
+		return created.ID, created.Token
+	}
+	readID, readToken := createToken(map[string]string{"name": "reader app"})
+	_, fullToken := createToken(map[string]string{"name": "script", "scope": "full", "expires": "1h"})
+
+	if response := bearerRequest(server, "GET", "/api/dir", readToken); response.Code != http.StatusOK {
+		t.Fatalf("read token GET = %d", response.Code)
+	}
+	if response := bearerRequest(server, "POST", "/api/duplicates/scan", readToken); response.Code != http.StatusForbidden {
+		t.Fatalf("read token POST = %d, want 403", response.Code)
+	}
+	if response := bearerRequest(server, "GET", "/api/settings/config", fullToken); response.Code != http.StatusOK {
+		t.Fatalf("full token admin route = %d", response.Code)
+	}
+	if response := bearerRequest(server, "GET", "/api/dir", "lc_unknown"); response.Code != http.StatusUnauthorized {
+		t.Fatalf("unknown token = %d, want 401", response.Code)
+	}
+
+	// Listing never reveals secrets
+	listing := authRequest(t, server, "GET", "/api/settings/tokens", nil, admin)
+	var list struct {
+		Tokens []map[string]interface{} `json:"tokens"`
+	}
+	if err := json.Unmarshal(listing.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Tokens) != 2 || list.Tokens[0]["token"] != nil {
+		t.Fatalf("tokens = %s", listing.Body.String())
+	}
+
+	if response := authRequest(t, server, "DELETE", "/api/settings/tokens/"+readID, nil, admin); response.Code != http.StatusOK {
+		t.Fatalf("revoke = %d", response.Code)
+	}
+	if response := bearerRequest(server, "GET", "/api/dir", readToken); response.Code != http.StatusUnauthorized {
+		t.Fatalf("revoked token = %d, want 401", response.Code)
+	}
+}
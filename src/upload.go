@@ -21,6 +21,10 @@ func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
 		respondError(w, "File upload is disabled", http.StatusForbidden)
 		return
 	}
+	if !s.requestAccess(r).Upload {
+		respondError(w, "You do not have permission to upload", http.StatusForbidden)
+		return
+	}
 	deadline := time.Now().Add(24 * time.Hour)
 	controller := http.NewResponseController(w)
 	_ = controller.SetReadDeadline(deadline)
@@ -39,7 +43,8 @@ func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
 	}
 
 	destinationPath := r.FormValue("destination")
-	destination, err := s.resolveRequestPath(destinationPath)
+	auditPaths(r, destinationPath)
+	destination, err := s.resolveRequestPath(r, destinationPath)
 	if err != nil {
 		respondError(w, "Invalid destination path", http.StatusBadRequest)
 		return
@@ -106,6 +111,9 @@ func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
 		topLevels[strings.Split(clean, "/")[0]] = struct{}{}
 	}
 
+	for topLevel := range topLevels {
+		auditPaths(r, filepath.Join(destinationPath, topLevel))
+	}
 	for topLevel := range topLevels {
 		if _, err := os.Lstat(filepath.Join(destination.FullPath, filepath.FromSlash(topLevel))); err == nil {
 			respondError(w, fmt.Sprintf("An item named %q already exists in the destination", topLevel), http.StatusConflict)
@@ -180,6 +188,7 @@ func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
 		}
 		moved = append(moved, filepath.FromSlash(name))
 	}
+	s.onLibraryChanged()
 
 	respondJSON(w, struct {
 		Success   bool `json:"success"`
@@ -0,0 +1,441 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	userDataVersion = 1
+	// maxUserDataSize caps the size of an imported document
+	maxUserDataSize = 32 << 20
+)
+
+// browserPreferenceKeys are the localStorage settings taken over by the browser importer
+var browserPreferenceKeys = []string{"theme", "zoomLevel"}
+
+// userDataProgress is the progress in one book in an exported document
+type userDataProgress struct {
+	ID   string `json:"id"`
+	Path string `json:"path,omitempty"`
+	Progress
+	KOReader *koreaderPosition `json:"koreader,omitempty"`
+	browser  bool              // Read from localStorage, which has no completed flag
+}
+
+// userDataBookmarks are the bookmarks in one book in an exported document
+type userDataBookmarks struct {
+	BookID    string     `json:"bookId"`
+	Path      string     `json:"path"`
+	Bookmarks []Bookmark `json:"bookmarks"`
+}
+
+// userData is all per-user state as one document. Imports may instead, or in
+// addition, carry the browser's localStorage entries.
+type userData struct {
+	Version      int                 `json:"version"`
+	Exported     time.Time           `json:"exported,omitempty"`
+	User         string              `json:"user,omitempty"`
+	Progress     []userDataProgress  `json:"progress"`
+	Bookmarks    []userDataBookmarks `json:"bookmarks"`
+	History      []readingSession    `json:"history"`
+	Preferences  map[string]string   `json:"preferences"`
+	LocalStorage map[string]string   `json:"localStorage,omitempty"`
+}
+
+// handleExportUserData downloads the requester's progress, read state, bookmarks,
+// reading history and preferences as one JSON document
+func (s *Server) handleExportUserData(w http.ResponseWriter, r *http.Request) {
+	access := s.requestAccess(r)
+	user := requestUserName(r)
+	data := userData{
+		Version:     userDataVersion,
+		Exported:    time.Now(),
+		User:        user,
+		Progress:    make([]userDataProgress, 0),
+		Bookmarks:   make([]userDataBookmarks, 0),
+		History:     s.visibleSessions(r, historyRange{}),
+		Preferences: s.preferences.get(user),
+	}
+	for id, record := range s.progress.forUser(user) {
+		// Documents outside the library synced by KOReader have no path
+		if record.Path == "" || access.canSeePath(record.Path) {
+			data.Progress = append(data.Progress, userDataProgress{ID: id, Path: record.Path, Progress: record.Progress, KOReader: record.KOReader})
+		}
+	}
+	s.bookmarks.mu.Lock()
+	for id, book := range s.bookmarks.Users[user] {
+		if !access.canSeePath(book.Path) {
+			continue
+		}
+		bookmarks := make([]Bookmark, 0, len(book.Bookmarks))
+		for _, bookmark := range book.Bookmarks {
+			bookmarks = append(bookmarks, *bookmark)
+		}
+		data.Bookmarks = append(data.Bookmarks, userDataBookmarks{id, book.Path, bookmarks})
+	}
+	s.bookmarks.mu.Unlock()
+
+	w.Header().Set("Content-Disposition", `attachment; filename="litecomics-data.json"`)
+	respondJSON(w, data)
+}
+
+// userDataUnmatched is an imported entry that was skipped
+type userDataUnmatched struct {
+	Kind   string `json:"kind"` // "progress", "bookmark", "history" or "preference"
+	ID     string `json:"id,omitempty"`
+	Path   string `json:"path,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// userDataReport counts the imported entries that matched a book, per kind
+type userDataReport struct {
+	DryRun      bool                `json:"dryRun"`
+	Progress    int                 `json:"progress"`
+	Bookmarks   int                 `json:"bookmarks"`
+	History     int                 `json:"history"`
+	Preferences int                 `json:"preferences"`
+	Unmatched   []userDataUnmatched `json:"unmatched"`
+}
+
+// matchedBook is a library book an imported entry refers to
+type matchedBook struct {
+	id       string
+	resolved *ResolvedPath
+}
+
+// bookMatcher finds the books imported entries refer to for one import request
+type bookMatcher struct {
+	s       *Server
+	r       *http.Request
+	matches map[string]*matchedBook
+	ids     map[string]string // Book ID -> request path of every visible book, built on the first unknown ID
+}
+
+func newBookMatcher(s *Server, r *http.Request) *bookMatcher {
+	return &bookMatcher{s: s, r: r, matches: make(map[string]*matchedBook)}
+}
+
+// findID returns the request path of a book ID. IDs this server has not seen
+// (documents exported elsewhere) are looked up by fingerprinting the library once;
+// the new IDs are recorded in the index together.
+func (m *bookMatcher) findID(id string) (string, bool) {
+	if path, ok := m.s.findBookByID(id); ok {
+		return path, true
+	}
+	if m.ids == nil {
+		m.ids = make(map[string]string)
+		access := m.s.requestAccess(m.r)
+		fingerprints := make(map[string][]fileItem) // fingerprint -> books, more than one for copies
+		m.s.walkLibrary(func(item fileItem, fullPath string) error {
+			if item.Type != "book" || !access.canSeePath(item.Path) {
+				return nil
+			}
+			if known := m.s.bookIDs.known(item.Path, item.Size, item.Modified); known != "" {
+				m.ids[known] = item.Path
+				return nil
+			}
+			if fingerprint, err := computeBookID(fullPath); err == nil {
+				fingerprints[fingerprint] = append(fingerprints[fingerprint], item)
+			}
+			return nil
+		})
+
+		records := make(map[string]bookIDRecord)
+		for fingerprint, items := range fingerprints {
+			for _, item := range items {
+				m.ids[copyBookID(fingerprint, item.Path)] = item.Path
+			}
+			if _, ok := m.ids[fingerprint]; !ok {
+				m.ids[fingerprint] = items[0].Path
+			}
+			// Copies are left to bookID, which decides which of them keeps the fingerprint
+			if item := items[0]; len(items) == 1 && !m.s.isCopyOf(fingerprint, item.Path) {
+				records[fingerprint] = bookIDRecord{Path: item.Path, Size: item.Size, ModTime: item.Modified}
+			}
+		}
+		m.s.bookIDs.recordAll(records)
+	}
+	path, ok := m.ids[id]
+	return path, ok
+}
+
+// match finds the visible book an imported entry refers to: by book ID when the
+// book is in the library, otherwise by path
+func (m *bookMatcher) match(id, path string) *matchedBook {
+	key := id + "\x00" + path
+	if match, ok := m.matches[key]; ok {
+		return match
+	}
+	var match *matchedBook
+	if id != "" {
+		if found, ok := m.findID(id); ok {
+			if resolved, err := m.s.resolveRequestPath(m.r, found); err == nil {
+				match = &matchedBook{id, resolved}
+			}
+		}
+	}
+	if match == nil && path != "" {
+		if resolved, err := m.s.resolveRequestPath(m.r, path); err == nil && isArchiveFile(resolved.FullPath) {
+			if current, err := m.s.bookID(resolved); err == nil {
+				match = &matchedBook{current, resolved}
+			}
+		}
+	}
+	m.matches[key] = match
+	return match
+}
+
+// browserUserData converts the browser's localStorage entries: viewer positions
+// (viewer_page_, viewer_offset_, viewer_direction_ and viewer_updated_ keyed by
+// "id:<book ID>" or path), the opened-file history (file_history) and settings
+func browserUserData(storage map[string]string, report *userDataReport) userData {
+	var data userData
+	positions := make(map[string]*userDataProgress)
+	position := func(key string) *userDataProgress {
+		entry, ok := positions[key]
+		if !ok {
+			entry = &userDataProgress{Progress: Progress{Page: -2}, browser: true}
+			if id, ok := strings.CutPrefix(key, "id:"); ok {
+				entry.ID = id
+			} else {
+				entry.Path = key
+			}
+			positions[key] = entry
+		}
+		return entry
+	}
+	for key, value := range storage {
+		switch {
+		case strings.HasPrefix(key, "viewer_page_"):
+			if page, err := strconv.Atoi(value); err == nil {
+				position(strings.TrimPrefix(key, "viewer_page_")).Page = page
+			}
+		case strings.HasPrefix(key, "viewer_offset_"):
+			if offset, err := strconv.Atoi(value); err == nil {
+				position(strings.TrimPrefix(key, "viewer_offset_")).Offset = offset
+			}
+		case strings.HasPrefix(key, "viewer_direction_"):
+			position(strings.TrimPrefix(key, "viewer_direction_")).Direction = value
+		case strings.HasPrefix(key, "viewer_updated_"):
+			if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+				position(strings.TrimPrefix(key, "viewer_updated_")).Updated = time.UnixMilli(ms)
+			}
+		}
+	}
+	for _, entry := range positions {
+		// Offsets and directions without a saved page are leftovers
+		if entry.Page != -2 {
+			data.Progress = append(data.Progress, *entry)
+		}
+	}
+
+	if value, ok := storage["file_history"]; ok {
+		var opened []struct {
+			Path      string `json:"path"`
+			Type      string `json:"type"`
+			Timestamp int64  `json:"timestamp"`
+		}
+		if err := json.Unmarshal([]byte(value), &opened); err != nil {
+			report.Unmatched = append(report.Unmatched, userDataUnmatched{Kind: "history", Reason: "file_history is not valid JSON"})
+		}
+		for _, item := range opened {
+			if item.Type != "book" {
+				report.Unmatched = append(report.Unmatched, userDataUnmatched{Kind: "history", Path: item.Path, Reason: "not a book"})
+				continue
+			}
+			opened := time.UnixMilli(item.Timestamp)
+			data.History = append(data.History, readingSession{Path: item.Path, Start: opened, End: opened, Pages: []int{}})
+		}
+	}
+
+	data.Preferences = make(map[string]string)
+	for _, key := range browserPreferenceKeys {
+		if value, ok := storage[key]; ok {
+			data.Preferences[key] = value
+		}
+	}
+	return data
+}
+
+// handleImportUserData merges an exported document, or the browser's localStorage
+// entries, into the requester's state. Entries are matched to books by book ID,
+// then by path; with ?dryRun=true nothing is saved and only the report is returned.
+func (s *Server) handleImportUserData(w http.ResponseWriter, r *http.Request) {
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dryRun"))
+	r.Body = http.MaxBytesReader(w, r.Body, maxUserDataSize)
+	var data userData
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		respondError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if data.Version > userDataVersion {
+		respondError(w, fmt.Sprintf("Unsupported version %d", data.Version), http.StatusBadRequest)
+		return
+	}
+
+	report := userDataReport{DryRun: dryRun, Unmatched: make([]userDataUnmatched, 0)}
+	if data.LocalStorage != nil {
+		browser := browserUserData(data.LocalStorage, &report)
+		data.Progress = append(data.Progress, browser.Progress...)
+		data.History = append(data.History, browser.History...)
+		if data.Preferences == nil {
+			data.Preferences = make(map[string]string)
+		}
+		for key, value := range browser.Preferences {
+			if _, ok := data.Preferences[key]; !ok {
+				data.Preferences[key] = value
+			}
+		}
+	}
+	skip := func(kind, id, path, reason string) {
+		report.Unmatched = append(report.Unmatched, userDataUnmatched{kind, id, path, reason})
+	}
+
+	user := requestUserName(r)
+	matcher := newBookMatcher(s, r)
+	fail := func(err error) {
+		respondError(w, err.Error(), http.StatusInternalServerError)
+	}
+
+	// Everything is matched and validated before any store changes, and each
+	// store is then written once
+	var progressEntries []userDataProgress
+	var progressIDs []string
+	var progressRecords []progressRecord
+	for _, entry := range data.Progress {
+		if entry.Page < -1 || (entry.Direction != "" && entry.Direction != "rtl" && entry.Direction != "ltr") {
+			skip("progress", entry.ID, entry.Path, "invalid progress")
+			continue
+		}
+		record := progressRecord{Progress: entry.Progress, KOReader: entry.KOReader}
+		id := entry.ID
+		// KOReader documents outside the library are kept by digest
+		if !strings.HasPrefix(id, kosyncDocumentPrefix) || entry.Path != "" {
+			match := matcher.match(entry.ID, entry.Path)
+			if match == nil {
+				skip("progress", entry.ID, entry.Path, "book not found")
+				continue
+			}
+			id, record.Path = match.id, match.resolved.RequestPath()
+			record.Pages = s.bookPageCount(match.resolved)
+			if entry.browser && record.Pages > 0 {
+				// The viewer's own rule for a finished book
+				record.Completed = record.Page >= record.Pages-2
+			}
+		}
+		if now := time.Now(); record.Updated.IsZero() || record.Updated.After(now) {
+			record.Updated = now
+		}
+		progressEntries = append(progressEntries, entry)
+		progressIDs = append(progressIDs, id)
+		progressRecords = append(progressRecords, record)
+	}
+
+	bookmarks := make(map[string]bookBookmarks) // book ID -> bookmarks to add
+	for _, book := range data.Bookmarks {
+		match := matcher.match(book.BookID, book.Path)
+		if match == nil {
+			for range book.Bookmarks {
+				skip("bookmark", book.BookID, book.Path, "book not found")
+			}
+			continue
+		}
+		pages := s.bookPageCount(match.resolved)
+		imported := bookmarks[match.id]
+		imported.Path = match.resolved.RequestPath()
+		for _, bookmark := range book.Bookmarks {
+			if bookmark.Page < 0 || bookmark.Page >= pages || len([]rune(bookmark.Note)) > maxBookmarkNoteLength {
+				skip("bookmark", book.BookID, book.Path, fmt.Sprintf("invalid bookmark on page %d", bookmark.Page))
+				continue
+			}
+			if bookmark.ID == "" {
+				bookmark.ID = newID()
+			}
+			if bookmark.Created.IsZero() {
+				bookmark.Created = time.Now()
+			}
+			bookmark := bookmark
+			imported.Bookmarks = append(imported.Bookmarks, &bookmark)
+			report.Bookmarks++
+		}
+		if len(imported.Bookmarks) > 0 {
+			bookmarks[match.id] = imported
+		}
+	}
+
+	sessions := make([]readingSession, 0, len(data.History))
+	for _, session := range data.History {
+		if session.Start.IsZero() || session.End.Before(session.Start) {
+			skip("history", session.BookID, session.Path, "invalid session")
+			continue
+		}
+		match := matcher.match(session.BookID, session.Path)
+		if match == nil {
+			skip("history", session.BookID, session.Path, "book not found")
+			continue
+		}
+		session.BookID, session.Path = match.id, match.resolved.RequestPath()
+		if session.Series == "" {
+			session.Series, _, _ = s.seriesInfoFor(match.resolved.FullPath)
+		}
+		if session.Pages == nil {
+			session.Pages = []int{}
+		}
+		sessions = append(sessions, session)
+	}
+	report.History = len(sessions)
+
+	changes := make(map[string]*string, len(data.Preferences))
+	for key, value := range data.Preferences {
+		value := value
+		changes[key] = &value
+	}
+	if err := validatePreferences(s.preferences.get(user), changes); err != nil {
+		skip("preference", "", "", err.Error())
+		changes = nil
+	} else {
+		report.Preferences = len(changes)
+	}
+
+	// Progress is only accepted when it is newer than what is saved
+	accepted, err := s.progress.putAll(user, progressIDs, progressRecords, dryRun)
+	if err != nil {
+		fail(err)
+		return
+	}
+	for i, entry := range progressEntries {
+		if !accepted[i] {
+			skip("progress", entry.ID, entry.Path, "newer progress already saved")
+			continue
+		}
+		report.Progress++
+	}
+	if dryRun {
+		respondJSON(w, report)
+		return
+	}
+	if len(bookmarks) > 0 {
+		if _, err := s.bookmarks.merge(user, bookmarks); err != nil {
+			fail(err)
+			return
+		}
+	}
+	if len(sessions) > 0 {
+		if _, err := s.history.merge(user, sessions); err != nil {
+			fail(err)
+			return
+		}
+	}
+	if len(changes) > 0 {
+		if _, err := s.preferences.update(user, changes); err != nil {
+			fail(err)
+			return
+		}
+	}
+	respondJSON(w, report)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUserDataExportImport(t *testing.T) {
+	root := t.TempDir()
+	order := []string{"01.jpg", "02.jpg", "03.jpg"}
+	for _, name := range []string{"a.cbz", "b.cbz"} {
+		pages := map[string]string{"01.jpg": name, "02.jpg": "b", "03.jpg": "c"}
+		writeTestZip(t, filepath.Join(root, name), zip.Store, pages, order)
+	}
+	cfg := &Config{Roots: []RootConfig{{Path: root, Name: "Root"}}}
+	server := newAuthTestServerWithConfig(t, cfg)
+
+	authRequest(t, server, "PUT", "/api/book/Root/a.cbz/progress", map[string]interface{}{"page": 1, "direction": "ltr"})
+	authRequest(t, server, "POST", "/api/book/Root/a.cbz/bookmarks", map[string]interface{}{"page": 2, "note": "spread"})
+	authRequest(t, server, "PUT", "/api/preferences", map[string]string{"theme": "light"})
+	response := authRequest(t, server, "GET", "/api/userdata", nil)
+	var exported userData
+	if err := json.Unmarshal(response.Body.Bytes(), &exported); err != nil || len(exported.Progress) != 1 || len(exported.Bookmarks) != 1 ||
+		exported.Preferences["theme"] != "light" {
+		t.Fatalf("export = %s", response.Body.String())
+	}
+
+	// A new server where the book has moved: the book ID still finds it
+	if err := os.Mkdir(filepath.Join(root, "moved"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(root, "a.cbz"), filepath.Join(root, "moved", "a.cbz")); err != nil {
+		t.Fatal(err)
+	}
+	server = newAuthTestServerWithConfig(t, cfg)
+	exported.Progress = append(exported.Progress, userDataProgress{ID: "missing", Path: "Root/gone.cbz"})
+
+	var report userDataReport
+	response = authRequest(t, server, "POST", "/api/userdata/import?dryRun=true", exported)
+	if err := json.Unmarshal(response.Body.Bytes(), &report); err != nil || !report.DryRun || report.Progress != 1 || report.Bookmarks != 1 ||
+		report.Preferences != 1 || len(report.Unmatched) != 1 || report.Unmatched[0].Path != "Root/gone.cbz" {
+		t.Fatalf("dry run = %s", response.Body.String())
+	}
+	if response := authRequest(t, server, "GET", "/api/book/Root/moved/a.cbz/progress", nil); response.Code != http.StatusNotFound {
+		t.Fatalf("dry run saved progress: %d", response.Code)
+	}
+
+	authRequest(t, server, "POST", "/api/userdata/import", exported)
+	var progress Progress
+	response = authRequest(t, server, "GET", "/api/book/Root/moved/a.cbz/progress", nil)
+	if err := json.Unmarshal(response.Body.Bytes(), &progress); err != nil || progress.Page != 1 || progress.Direction != "ltr" {
+		t.Fatalf("imported progress = %s", response.Body.String())
+	}
+	var bookmarks struct {
+		Bookmarks []libraryBookmark `json:"bookmarks"`
+	}
+	response = authRequest(t, server, "GET", "/api/bookmarks", nil)
+	if err := json.Unmarshal(response.Body.Bytes(), &bookmarks); err != nil || len(bookmarks.Bookmarks) != 1 ||
+		bookmarks.Bookmarks[0].Path != filepath.Join("Root", "moved", "a.cbz") || bookmarks.Bookmarks[0].Note != "spread" {
+		t.Fatalf("imported bookmarks = %s", response.Body.String())
+	}
+	// Importing again adds no duplicates
+	authRequest(t, server, "POST", "/api/userdata/import", exported)
+	if len(server.bookmarks.list("", bookmarks.Bookmarks[0].BookID)) != 1 {
+		t.Fatal("bookmarks duplicated on second import")
+	}
+
+	// Progress older than what the server has is reported, not counted
+	stale := userData{Progress: []userDataProgress{{Path: "Root/moved/a.cbz", Progress: Progress{Page: 0, Updated: time.Unix(1, 0)}}}}
+	for _, target := range []string{"/api/userdata/import?dryRun=true", "/api/userdata/import"} {
+		report = userDataReport{}
+		response = authRequest(t, server, "POST", target, stale)
+		if err := json.Unmarshal(response.Body.Bytes(), &report); err != nil || report.Progress != 0 || len(report.Unmatched) != 1 {
+			t.Fatalf("stale import %s = %s", target, response.Body.String())
+		}
+	}
+
+	// Several entries for one book are applied in order, the same way in a dry run
+	repeated := userData{Progress: []userDataProgress{
+		{Path: "Root/moved/a.cbz", Progress: Progress{Page: 2, Updated: time.Now().Add(time.Hour)}},
+		{Path: "Root/moved/a.cbz", Progress: Progress{Page: 1, Updated: time.Unix(2, 0)}},
+	}}
+	for _, target := range []string{"/api/userdata/import?dryRun=true", "/api/userdata/import"} {
+		report = userDataReport{}
+		response = authRequest(t, server, "POST", target, repeated)
+		if err := json.Unmarshal(response.Body.Bytes(), &report); err != nil || report.Progress != 1 || len(report.Unmatched) != 1 {
+			t.Fatalf("repeated import %s = %s", target, response.Body.String())
+		}
+	}
+	response = authRequest(t, server, "GET", "/api/book/Root/moved/a.cbz/progress", nil)
+	if err := json.Unmarshal(response.Body.Bytes(), &progress); err != nil || progress.Page != 2 {
+		t.Fatalf("repeated progress = %s", response.Body.String())
+	}
+
+	// The browser's localStorage entries, keyed by path
+	browser := map[string]interface{}{"localStorage": map[string]string{
+		"viewer_page_Root/b.cbz":      "2",
+		"viewer_offset_Root/b.cbz":    "1",
+		"viewer_updated_Root/b.cbz":   "1700000000000",
+		"viewer_direction_Root/x.cbz": "rtl",
+		"file_history":                `[{"path":"Root/b.cbz","name":"b.cbz","type":"book","timestamp":1700000000000},{"path":"Root/v.mp4","type":"video","timestamp":1}]`,
+		"zoomLevel":                   "120",
+	}}
+	report = userDataReport{}
+	response = authRequest(t, server, "POST", "/api/userdata/import", browser)
+	if err := json.Unmarshal(response.Body.Bytes(), &report); err != nil || report.Progress != 1 || report.History != 1 ||
+		report.Preferences != 1 || len(report.Unmatched) != 1 {
+		t.Fatalf("browser import = %s", response.Body.String())
+	}
+	response = authRequest(t, server, "GET", "/api/book/Root/b.cbz/progress", nil)
+	if err := json.Unmarshal(response.Body.Bytes(), &progress); err != nil || progress.Page != 2 || progress.Offset != 1 || !progress.Completed {
+		t.Fatalf("browser progress = %s", response.Body.String())
+	}
+	if preferences := server.preferences.get(""); preferences["theme"] != "light" || preferences["zoomLevel"] != "120" {
+		t.Fatalf("preferences = %v", preferences)
+	}
+}
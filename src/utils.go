@@ -27,6 +27,11 @@ type ResolvedPath struct {
 	FullPath     string
 }
 
+// RequestPath returns the canonical "RootName/relative/path" form used in API responses
+func (p *ResolvedPath) RequestPath() string {
+	return filepath.Join(p.RootName, p.RelativePath)
+}
+
 var (
 	archiveExtensions = []string{".cbz", ".zip", ".cbr", ".rar", ".cb7", ".7z", ".epub"}
 	videoExtensions   = []string{".mp4", ".mkv", ".webm", ".avi", ".mov", ".m2ts", ".ts", ".wmv", ".flv", ".mpg", ".mpeg"}
@@ -54,6 +59,36 @@ func respondJSON(w http.ResponseWriter, data interface{}) {
 	json.NewEncoder(w).Encode(data)
 }
 
+// respondJSONStatus sends data as JSON with a status other than 200 OK
+func respondJSONStatus(w http.ResponseWriter, data interface{}, code int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(data)
+}
+
+// respondJSONWithETag sends data with a content-derived ETag, answering 304 when the client's copy is current
+func respondJSONWithETag(w http.ResponseWriter, r *http.Request, data interface{}) {
+	body, err := json.Marshal(data)
+	if err != nil {
+		respondError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	hash := md5.Sum(body)
+	etag := `"` + hex.EncodeToString(hash[:]) + `"`
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	for _, candidate := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		if candidate = strings.TrimSpace(candidate); candidate == etag || candidate == "W/"+etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(append(body, '\n'))
+}
+
 func respondError(w http.ResponseWriter, message string, code int) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(code)
@@ -73,7 +108,27 @@ func generateCacheKey(path string) string {
 	return hex.EncodeToString(hash[:])
 }
 
-func (s *Server) resolveRequestPath(requestPath string) (*ResolvedPath, error) {
+// resolveRequestPath resolves a path from a request, rejecting roots the requester cannot see
+// and ignored items
+func (s *Server) resolveRequestPath(r *http.Request, requestPath string) (*ResolvedPath, error) {
+	resolved, err := s.resolvePath(requestPath)
+	if err != nil {
+		return nil, err
+	}
+	if !s.requestAccess(r).canSeePath(resolved.RequestPath()) {
+		return nil, fmt.Errorf("invalid root name")
+	}
+	// Ignored items stay hidden when addressed directly, not only in listings
+	info, err := os.Stat(resolved.FullPath)
+	if s.ignoreMatcherFor(resolved.RootName).hidden(resolved.RelativePath, err == nil && info.IsDir()) {
+		return nil, fmt.Errorf("path not found")
+	}
+	return resolved, nil
+}
+
+// resolvePath resolves a "RootName/relative/path" against the configured roots
+// without access checks, for background work and stored paths
+func (s *Server) resolvePath(requestPath string) (*ResolvedPath, error) {
 	parts := strings.FieldsFunc(filepath.Clean(requestPath), func(r rune) bool {
 		return r == filepath.Separator
 	})
@@ -110,7 +165,8 @@ func isPathSafe(root, path string) bool {
 	return err == nil && !strings.HasPrefix(rel, "..") && rel != ".."
 }
 
-func createZipArchive(sourceDir, zipPath string) error {
+// createZipArchive zips sourceDir into zipPath, leaving out entries for which ignored returns true
+func createZipArchive(sourceDir, zipPath string, ignored func(path string, info os.FileInfo) bool) error {
 	zipFile, err := os.Create(zipPath)
 	if err != nil {
 		return err
@@ -126,6 +182,12 @@ func createZipArchive(sourceDir, zipPath string) error {
 		if err != nil {
 			return err
 		}
+		if path != sourceDir && ignored != nil && ignored(path, info) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
 
 		// Get relative path from base
 		relPath, err := filepath.Rel(basePath, path)